@@ -0,0 +1,258 @@
+package sqlpro
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+// anonymizeBatchSize is the number of rows CopyAnonymized
+// inserts with one InsertBulk
+const anonymizeBatchSize = 500
+
+// ExportAnonymized runs the query and streams the rows to w in the given
+// format. target is a pointer to a struct used to scan each row, fields
+// tagged with "anonymize=..." are transformed before they are written:
+//
+// anonymize=hash: string fields are replaced by the hex sha256 of their value
+// anonymize=fake: string fields are replaced by a stable fake value derived from the hash
+// anonymize=null: the field is set to its zero value (NULL for pointers)
+//
+// The columns are written in the order of the query, columns not mapped to a
+// field are skipped. CSV output starts with a header row.
+func (db *DB) ExportAnonymized(ctx context.Context, w io.Writer, format ExportFormat, target interface{}, query string, args ...interface{}) error {
+	var (
+		csvW  *csv.Writer
+		jsonE *json.Encoder
+	)
+
+	targetV, info := anonymizeTarget(target)
+
+	rows, err := db.queryRows(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	mapped := make([]string, 0, len(cols))
+	for _, col := range cols {
+		if _, ok := info[col]; ok {
+			mapped = append(mapped, col)
+		}
+	}
+
+	switch format {
+	case ExportCSV:
+		csvW = csv.NewWriter(w)
+		err = csvW.Write(mapped)
+		if err != nil {
+			return err
+		}
+	case ExportNDJSON:
+		jsonE = json.NewEncoder(w)
+	default:
+		return fmt.Errorf("ExportAnonymized: Unknown format %q.", format)
+	}
+
+	for rows.Next() {
+		targetV.Set(reflect.Zero(targetV.Type()))
+		err = scanRow(targetV, rows, db.TimeLayouts)
+		if err != nil {
+			return db.debugError(err)
+		}
+		err = anonymizeStruct(targetV, info)
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case ExportCSV:
+			record := make([]string, 0, len(mapped))
+			for _, col := range mapped {
+				s, err := exportString(info[col].fieldValue(targetV), info[col])
+				if err != nil {
+					return err
+				}
+				record = append(record, s)
+			}
+			err = csvW.Write(record)
+		case ExportNDJSON:
+			record := jsonRecord{cols: mapped, values: make([]interface{}, 0, len(mapped))}
+			for _, col := range mapped {
+				record.values = append(record.values, info[col].fieldValue(targetV).Interface())
+			}
+			err = jsonE.Encode(record)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return db.debugError(err)
+	}
+
+	if csvW != nil {
+		csvW.Flush()
+		return csvW.Error()
+	}
+	return nil
+}
+
+// jsonRecord is a JSON object with the keys in the order of cols, unlike
+// maps, which encoding/json writes with sorted keys
+type jsonRecord struct {
+	cols   []string
+	values []interface{}
+}
+
+func (r jsonRecord) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for idx, col := range r.cols {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(col)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(r.values[idx])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// CopyAnonymized runs the query and inserts the anonymized rows into table of
+// the dst handle using InsertBulk. See ExportAnonymized for target.
+func (db *DB) CopyAnonymized(ctx context.Context, dst *DB, table string, target interface{}, query string, args ...interface{}) error {
+	targetV, info := anonymizeTarget(target)
+	batch := reflect.MakeSlice(reflect.SliceOf(targetV.Type()), 0, anonymizeBatchSize)
+
+	err := db.QueryEachContext(ctx, target, func() error {
+		err := anonymizeStruct(targetV, info)
+		if err != nil {
+			return err
+		}
+		batch = reflect.Append(batch, targetV)
+		if batch.Len() < anonymizeBatchSize {
+			return nil
+		}
+		err = dst.InsertBulkContext(ctx, table, batch.Interface())
+		batch = batch.Slice(0, 0)
+		return err
+	}, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if batch.Len() > 0 {
+		return dst.InsertBulkContext(ctx, table, batch.Interface())
+	}
+	return nil
+}
+
+func anonymizeTarget(target interface{}) (reflect.Value, structInfo) {
+	v := reflect.ValueOf(target)
+	if target == nil || v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("Anonymize: target needs to be a pointer to a struct, have %T", target))
+	}
+	return v.Elem(), getStructInfo(v.Elem().Type())
+}
+
+// anonymizeStruct applies the anonymize modes of info to the struct rv
+func anonymizeStruct(rv reflect.Value, info structInfo) error {
+	for _, fi := range info {
+		if fi.anonymize == "" {
+			continue
+		}
+		fieldV := fi.fieldValue(rv)
+
+		if fi.anonymize == "null" {
+			fieldV.Set(reflect.Zero(fieldV.Type()))
+			continue
+		}
+
+		strV := fieldV
+		if strV.Kind() == reflect.Ptr {
+			if strV.IsNil() {
+				continue
+			}
+			strV = strV.Elem()
+		}
+		if strV.Kind() != reflect.String {
+			return fmt.Errorf("Anonymize: Unable to use %q on field %s of type %s, need string.", fi.anonymize, fi.name, fieldV.Type())
+		}
+
+		sum := sha256.Sum256([]byte(strV.String()))
+		hash := hex.EncodeToString(sum[:])
+
+		switch fi.anonymize {
+		case "hash":
+			strV.SetString(hash)
+		case "fake":
+			if strings.Contains(strV.String(), "@") {
+				strV.SetString(hash[0:12] + "@example.com")
+			} else {
+				strV.SetString(fi.dbName + "-" + hash[0:8])
+			}
+		}
+	}
+	return nil
+}
+
+// exportString renders the field value for CSV output
+func exportString(fieldV reflect.Value, fi *fieldInfo) (string, error) {
+	if fi.isJson {
+		data, err := json.Marshal(fieldV.Interface())
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if fieldV.Kind() == reflect.Ptr {
+		if fieldV.IsNil() {
+			return "", nil
+		}
+		fieldV = fieldV.Elem()
+	}
+	switch v := fieldV.Interface().(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return string(v), nil
+	case json.RawMessage:
+		return string(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
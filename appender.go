@@ -0,0 +1,117 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Appender appends rows to a table, *duckdb.Appender of go-duckdb
+// implements it
+type Appender interface {
+	AppendRow(args ...driver.Value) error
+	Close() error
+}
+
+// AppenderFunc returns an Appender for table on the driver connection
+// conn. schema is empty for the default schema.
+type AppenderFunc func(conn driver.Conn, schema, table string) (Appender, error)
+
+// WithAppender lets InsertBulk use the appender API of DuckDB, which is
+// a lot faster than a multi-row INSERT. As go-duckdb is not a dependency
+// of sqlpro, the appender needs to be passed in:
+//
+//	db, err := sqlpro.Open("duckdb", dsn, sqlpro.WithAppender(
+//		func(conn driver.Conn, schema, table string) (sqlpro.Appender, error) {
+//			return duckdb.NewAppenderFromConn(conn, schema, table)
+//		}))
+//
+// The appender writes all columns of the table, so InsertBulk falls back
+// to INSERT if the rows miss a column, e.g. an omitempty primary key with
+// a default. Transactions use INSERT as well, as the appender needs its
+// own connection.
+func WithAppender(f AppenderFunc) Option {
+	return func(db *DB) {
+		db.appender = f
+	}
+}
+
+// appendRows inserts rows using the appender and returns false if the
+// appender can't be used for the rows
+func (db *DB) appendRows(ctx context.Context, table string, rows []map[string]interface{}, info map[string]*fieldInfo) (bool, error) {
+	if db.appender == nil || db.Driver != DUCKDB || db.sqlTx != nil || db.sqlDB == nil {
+		return false, nil
+	}
+
+	probe, err := db.queryRows(ctx, db.Paginate("SELECT * FROM "+db.escTable(table), Limit(0)))
+	if err != nil {
+		return false, err
+	}
+	cols, err := probe.Columns()
+	closeRows(probe)
+	if err != nil {
+		return false, err
+	}
+	if len(cols) != len(info) {
+		return false, nil
+	}
+	for _, col := range cols {
+		if info[col] == nil {
+			return false, nil
+		}
+	}
+
+	err = db.checkWrite("INSERT INTO " + table)
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := db.sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		dc, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("InsertBulk: Driver connection %T is no driver.Conn.", driverConn)
+		}
+		schema, name := db.appenderSchema(table)
+		app, err := db.appender(dc, schema, name)
+		if err != nil {
+			return err
+		}
+		values := make([]driver.Value, len(cols))
+		for _, row := range rows {
+			for idx, col := range cols {
+				values[idx], err = driver.DefaultParameterConverter.ConvertValue(db.nullValue(row[col], info[col]))
+				if err != nil {
+					app.Close()
+					return fmt.Errorf("InsertBulk: Column %q: %w", col, err)
+				}
+			}
+			err = app.AppendRow(values...)
+			if err != nil {
+				app.Close()
+				return err
+			}
+		}
+		// Close flushes the appended rows
+		return app.Close()
+	})
+	if err != nil {
+		return false, db.sqlError(err, "APPEND "+table, []interface{}{})
+	}
+	return true, nil
+}
+
+// appenderSchema splits table into schema and name, the schema defaults to
+// the schema set by WithSchema
+func (db *DB) appenderSchema(table string) (string, string) {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return schema, name
+	}
+	return db.schema, table
+}
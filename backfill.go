@@ -0,0 +1,118 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+)
+
+// BackfillSpec describes a backfill of a column. Rows with NULL in
+// Column are paged by their integer primary key (keyset pagination), the
+// value is computed by Value and written in batches. Each batch is written in
+// its own transaction, so an interrupted backfill can be restarted and
+// continues with the rows still NULL.
+type BackfillSpec struct {
+	Table      string
+	Column     string
+	PrimaryKey string // integer primary key column, defaults to "id"
+	BatchSize  int    // defaults to 1000
+	StartAfter int64  // only rows with a primary key greater than this are filled
+
+	// Value returns the value for the row with the given primary key,
+	// returning <nil> leaves the row untouched.
+	Value func(pk int64) (interface{}, error)
+
+	// Progress is called after each batch, it can be <nil>
+	Progress func(lastPK int64, updated int64)
+}
+
+// Backfill fills the column given in the spec and returns the number of
+// rows updated.
+func (db *DB) Backfill(ctx context.Context, spec BackfillSpec) (int64, error) {
+	var updated int64
+
+	if spec.Table == "" || spec.Column == "" || spec.Value == nil {
+		return 0, fmt.Errorf("Backfill: Table, Column and Value are required.")
+	}
+	if spec.PrimaryKey == "" {
+		spec.PrimaryKey = "id"
+	}
+	if spec.BatchSize <= 0 {
+		spec.BatchSize = 1000
+	}
+
+	lastPK := spec.StartAfter
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return updated, err
+		}
+
+		pks := []int64{}
+		err = db.QueryContext(ctx, &pks, db.Paginate("SELECT @ FROM @ WHERE @ IS NULL AND @ > ? ORDER BY @", Limit(int64(spec.BatchSize))),
+			spec.PrimaryKey, spec.Table, spec.Column, spec.PrimaryKey, lastPK, spec.PrimaryKey)
+		if err != nil {
+			return updated, err
+		}
+		if len(pks) == 0 {
+			return updated, nil
+		}
+
+		n, err := db.backfillBatch(ctx, spec, pks)
+		if err != nil {
+			return updated, err
+		}
+		updated += n
+		lastPK = pks[len(pks)-1]
+
+		if spec.Progress != nil {
+			spec.Progress(lastPK, updated)
+		}
+
+		if len(pks) < spec.BatchSize {
+			return updated, nil
+		}
+	}
+}
+
+func (db *DB) backfillBatch(ctx context.Context, spec BackfillSpec, pks []int64) (int64, error) {
+	var updated int64
+
+	tx := db
+	if db.sqlTx == nil && db.sqlDB != nil {
+		var err error
+		tx, err = db.BeginContext(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			if tx.ActiveTX() {
+				tx.Rollback()
+			}
+		}()
+	}
+
+	for _, pk := range pks {
+		value, err := spec.Value(pk)
+		if err != nil {
+			return 0, fmt.Errorf("Backfill: Value for %s=%d failed: %w", spec.PrimaryKey, pk, err)
+		}
+		if value == nil {
+			continue
+		}
+		// The IS NULL check keeps values written concurrently
+		n, _, err := tx.execContext(ctx, "UPDATE @ SET @ = ? WHERE @ = ? AND @ IS NULL",
+			spec.Table, spec.Column, value, spec.PrimaryKey, pk, spec.Column)
+		if err != nil {
+			return 0, err
+		}
+		updated += n
+	}
+
+	if tx != db {
+		err := tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,186 @@
+package sqlpro
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// WriteBatcher coalesces single row inserts issued within a short window
+// into one transaction (group commit). This reduces the commit overhead
+// (fsync) considerably on SQLite, where every write transaction is
+// serialized.
+//
+// Rows are inserted using Insert, so primary keys are set like for
+// DB.Insert. If the transaction fails, the rows of the batch are retried
+// one by one, so that only the failing rows report an error.
+type WriteBatcher struct {
+	db     *DB
+	window time.Duration
+
+	mtx     sync.Mutex
+	pending []*batchedInsert
+	timer   *time.Timer
+	closed  bool
+
+	running sync.WaitGroup
+}
+
+type batchedInsert struct {
+	table string
+	data  interface{}
+	done  func(err error)
+}
+
+// NewWriteBatcher returns a batcher collecting inserts for the given
+// window, before sending them to the database.
+func (db *DB) NewWriteBatcher(window time.Duration) *WriteBatcher {
+	if db.sqlTx != nil {
+		panic("sqlpro.DB.NewWriteBatcher: Unable to batch writes on a Transaction.")
+	}
+	return &WriteBatcher{
+		db:     db,
+		window: window,
+	}
+}
+
+// Insert queues data to be inserted into table. done is called with the
+// result after the batch has been committed, it can be <nil>. Pass
+// a pointer to a struct for the primary key to be set.
+func (wb *WriteBatcher) Insert(table string, data interface{}, done func(err error)) {
+	if done == nil {
+		done = func(error) {}
+	}
+
+	wb.mtx.Lock()
+	defer wb.mtx.Unlock()
+
+	if wb.closed {
+		panic("sqlpro.WriteBatcher.Insert: Batcher is closed.")
+	}
+
+	wb.pending = append(wb.pending, &batchedInsert{table: table, data: data, done: done})
+	if wb.timer == nil {
+		wb.timer = time.AfterFunc(wb.window, wb.flushPending)
+	}
+}
+
+// InsertWait queues data and waits for the batch to be committed
+func (wb *WriteBatcher) InsertWait(ctx context.Context, table string, data interface{}) error {
+	result := make(chan error, 1)
+	wb.Insert(table, data, func(err error) {
+		result <- err
+	})
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush sends all pending inserts and waits for all batches to finish
+func (wb *WriteBatcher) Flush() {
+	wb.flushPending()
+	wb.running.Wait()
+}
+
+// Close flushes the batcher. Insert must not be called after Close.
+func (wb *WriteBatcher) Close() {
+	wb.mtx.Lock()
+	wb.closed = true
+	wb.mtx.Unlock()
+
+	wb.Flush()
+}
+
+func (wb *WriteBatcher) flushPending() {
+	wb.mtx.Lock()
+	if wb.timer != nil {
+		wb.timer.Stop()
+		wb.timer = nil
+	}
+	batch := wb.pending
+	wb.pending = nil
+	if len(batch) > 0 {
+		wb.running.Add(1)
+	}
+	wb.mtx.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	defer wb.running.Done()
+	wb.execBatch(batch)
+}
+
+func (wb *WriteBatcher) execBatch(batch []*batchedInsert) {
+	err := wb.insertTx(batch)
+	if err == nil {
+		for _, bi := range batch {
+			bi.done(nil)
+		}
+		return
+	}
+
+	if len(batch) == 1 {
+		batch[0].done(err)
+		return
+	}
+
+	// Retry one by one, so that only the failing rows report errors
+	for _, bi := range batch {
+		bi.done(wb.db.Insert(bi.table, bi.data))
+	}
+}
+
+func (wb *WriteBatcher) insertTx(batch []*batchedInsert) error {
+	tx, err := wb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	restore := make([]func(), 0, len(batch))
+	for _, bi := range batch {
+		restore = append(restore, keepPrimaryKey(bi.data))
+		err = tx.Insert(bi.table, bi.data)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		// The ids set by the inserts have been rolled back
+		for _, f := range restore {
+			f()
+		}
+	}
+	return err
+}
+
+// keepPrimaryKey returns a func which restores the current value of the
+// primary key of data, if data is a pointer to a struct with one primary key
+func keepPrimaryKey(data interface{}) func() {
+	rv := reflect.Indirect(reflect.ValueOf(data))
+	if rv.Kind() != reflect.Struct || !rv.CanAddr() {
+		return func() {}
+	}
+	pk := getStructInfo(rv.Type()).onlyPrimaryKey()
+	if pk == nil {
+		return func() {}
+	}
+	fieldV := pk.fieldValue(rv)
+	orig := reflect.New(fieldV.Type()).Elem()
+	orig.Set(fieldV)
+	return func() {
+		fieldV.Set(orig)
+	}
+}
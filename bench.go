@@ -0,0 +1,197 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Workload is a benchmark run by Bench
+type Workload struct {
+	Name  string
+	N     int                                            // number of operations, defaults to 1000
+	Setup func(ctx context.Context, db *DB) error        // run before the operations, not measured
+	Op    func(ctx context.Context, db *DB, i int) error // operation i, 0 <= i < N
+}
+
+// BenchResult holds the latencies and allocations of a Workload
+type BenchResult struct {
+	Workload    string
+	N           int
+	Total       time.Duration
+	P50         time.Duration
+	P90         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+	AllocsPerOp uint64
+	BytesPerOp  uint64
+}
+
+func (r BenchResult) String() string {
+	return fmt.Sprintf("%s: n=%d total=%s p50=%s p90=%s p99=%s max=%s %d allocs/op %d B/op",
+		r.Workload, r.N, r.Total, r.P50, r.P90, r.P99, r.Max, r.AllocsPerOp, r.BytesPerOp)
+}
+
+// Bench runs the operations of w one after another and returns their
+// latency percentiles and allocations. Latencies are measured with the
+// system time, not the Clock of db. Allocations are counted for the whole
+// process, so Bench should not run in parallel to other work.
+func Bench(ctx context.Context, db *DB, w Workload) (BenchResult, error) {
+	n := w.N
+	if n <= 0 {
+		n = 1000
+	}
+	if w.Setup != nil {
+		err := w.Setup(ctx, db)
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("Bench %s: Setup: %w", w.Name, err)
+		}
+	}
+
+	latencies := make([]time.Duration, n)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		opStart := time.Now()
+		err := w.Op(ctx, db, i)
+		latencies[i] = time.Since(opStart)
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("Bench %s: Operation %d: %w", w.Name, i, err)
+		}
+	}
+	total := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	return BenchResult{
+		Workload:    w.Name,
+		N:           n,
+		Total:       total,
+		P50:         percentile(latencies, 50),
+		P90:         percentile(latencies, 90),
+		P99:         percentile(latencies, 99),
+		Max:         latencies[n-1],
+		AllocsPerOp: (after.Mallocs - before.Mallocs) / uint64(n),
+		BytesPerOp:  (after.TotalAlloc - before.TotalAlloc) / uint64(n),
+	}, nil
+}
+
+// percentile returns the p-th percentile of the sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// benchRow is a row of the table used by StandardWorkloads
+type benchRow struct {
+	ID    int64  `db:"id,pk,omitempty"`
+	Name  string `db:"name"`
+	Value int64  `db:"value"`
+}
+
+// benchBatch is the number of rows per InsertBulk and range scan of
+// StandardWorkloads
+const benchBatch = 100
+
+// StandardWorkloads returns the workloads single insert, bulk insert,
+// point select and range scan, each with n operations. The workloads drop
+// and recreate table in their setup, the selects run on n rows.
+func StandardWorkloads(table string, n int) []Workload {
+	var ids []int64
+
+	fill := func(ctx context.Context, db *DB) error {
+		err := benchTable(ctx, db, table)
+		if err != nil {
+			return err
+		}
+		rows := make([]benchRow, 0, benchBatch)
+		for i := 0; i < n; i++ {
+			rows = append(rows, benchRow{Name: "row " + strconv.Itoa(i), Value: int64(i)})
+			if len(rows) == benchBatch || i == n-1 {
+				err = db.InsertBulkContext(ctx, table, rows)
+				if err != nil {
+					return err
+				}
+				rows = rows[:0]
+			}
+		}
+		ids = nil
+		return db.QueryContext(ctx, &ids, "SELECT @ FROM @ ORDER BY @", "id", table, "id")
+	}
+
+	return []Workload{
+		{
+			Name: "single insert",
+			N:    n,
+			Setup: func(ctx context.Context, db *DB) error {
+				return benchTable(ctx, db, table)
+			},
+			Op: func(ctx context.Context, db *DB, i int) error {
+				return db.InsertContext(ctx, table, &benchRow{Name: "row " + strconv.Itoa(i), Value: int64(i)})
+			},
+		},
+		{
+			Name: "bulk insert",
+			N:    n,
+			Setup: func(ctx context.Context, db *DB) error {
+				return benchTable(ctx, db, table)
+			},
+			Op: func(ctx context.Context, db *DB, i int) error {
+				rows := make([]benchRow, benchBatch)
+				for j := range rows {
+					rows[j] = benchRow{Name: "row " + strconv.Itoa(i*benchBatch+j), Value: int64(j)}
+				}
+				return db.InsertBulkContext(ctx, table, rows)
+			},
+		},
+		{
+			Name:  "point select",
+			N:     n,
+			Setup: fill,
+			Op: func(ctx context.Context, db *DB, i int) error {
+				var row benchRow
+				return db.QueryContext(ctx, &row, "SELECT * FROM @ WHERE @ = ?", table, "id", ids[i%len(ids)])
+			},
+		},
+		{
+			Name:  "range scan",
+			N:     n,
+			Setup: fill,
+			Op: func(ctx context.Context, db *DB, i int) error {
+				var rows []benchRow
+				return db.QueryContext(ctx, &rows, db.Paginate("SELECT * FROM @ WHERE @ >= ? ORDER BY @", Limit(benchBatch)),
+					table, "id", ids[i%len(ids)], "id")
+			},
+		},
+	}
+}
+
+// benchTable drops and creates the table of StandardWorkloads
+func benchTable(ctx context.Context, db *DB, table string) error {
+	var id string
+	switch db.Driver {
+	case POSTGRES:
+		id = "BIGSERIAL PRIMARY KEY"
+	case MYSQL:
+		id = "BIGINT AUTO_INCREMENT PRIMARY KEY"
+	case MSSQL:
+		id = "BIGINT IDENTITY PRIMARY KEY"
+	default:
+		id = "INTEGER PRIMARY KEY"
+	}
+	err := db.ExecContext(ctx, "DROP TABLE IF EXISTS @", table)
+	if err != nil {
+		return err
+	}
+	return db.ExecContext(ctx, "CREATE TABLE @ (@ "+id+", @ VARCHAR(100), @ BIGINT)", table, "id", "name", "value")
+}
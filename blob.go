@@ -0,0 +1,344 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// blobChunkSize is the number of bytes written and read per statement
+const blobChunkSize = 256 * 1024
+
+// BlobRef identifies the blob column of a row. On Postgres the column
+// needs to be of type OID, it references a large object, or of type bytea
+// if Bytea is set. On SQLite the column holds the data as BLOB.
+type BlobRef struct {
+	Table      string
+	Column     string
+	PrimaryKey string // primary key column, defaults to "id"
+	PK         interface{}
+	Bytea      bool // Postgres only, the column is of type bytea and read and written in chunks
+}
+
+func (ref BlobRef) check(op string) (BlobRef, error) {
+	if ref.Table == "" || ref.Column == "" || ref.PK == nil {
+		return ref, fmt.Errorf("%s: Table, Column and PK are required.", op)
+	}
+	if ref.PrimaryKey == "" {
+		ref.PrimaryKey = "id"
+	}
+	return ref, nil
+}
+
+// InsertBlob writes the data read from r into the blob column of the
+// existing row given by ref, in chunks so the data is never held in memory
+// as a whole. A previous blob is replaced. Outside of a transaction, the
+// chunks are written in a new transaction. The number of bytes written is
+// returned.
+func (db *DB) InsertBlob(ctx context.Context, ref BlobRef, r io.Reader) (int64, error) {
+	ref, err := ref.check("InsertBlob")
+	if err != nil {
+		return 0, err
+	}
+
+	if db.sqlTx == nil {
+		tx, err := db.BeginContext(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			if tx.ActiveTX() {
+				tx.Rollback()
+			}
+		}()
+		n, err := tx.InsertBlob(ctx, ref, r)
+		if err != nil {
+			return 0, err
+		}
+		return n, tx.Commit()
+	}
+
+	var write func(offset int64, chunk []byte) error
+
+	switch {
+	case db.Driver == POSTGRES && ref.Bytea:
+		affected, _, err := db.ExecContextRowsAffected(ctx, "UPDATE @ SET @ = '' WHERE @ = ?", ref.Table, ref.Column, ref.PrimaryKey, ref.PK)
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			return 0, ErrQueryReturnedZeroRows
+		}
+		write = func(offset int64, chunk []byte) error {
+			return db.ExecContext(ctx, "UPDATE @ SET @ = @ || ? WHERE @ = ?",
+				ref.Table, ref.Column, ref.Column, chunk, ref.PrimaryKey, ref.PK)
+		}
+	case db.Driver == POSTGRES:
+		var oldOid *int64
+		err = db.QueryContext(ctx, &oldOid, "SELECT @ FROM @ WHERE @ = ?", ref.Column, ref.Table, ref.PrimaryKey, ref.PK)
+		if err != nil {
+			return 0, err
+		}
+		if oldOid != nil {
+			err = db.ExecContext(ctx, "SELECT lo_unlink(?)", *oldOid)
+			if err != nil {
+				return 0, err
+			}
+		}
+		oid, err := db.CreateLargeObject(ctx)
+		if err != nil {
+			return 0, err
+		}
+		err = db.ExecContext(ctx, "UPDATE @ SET @ = ? WHERE @ = ?", ref.Table, ref.Column, oid, ref.PrimaryKey, ref.PK)
+		if err != nil {
+			return 0, err
+		}
+		lo, err := db.OpenLargeObject(ctx, oid, LargeObjectWrite)
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.CopyBuffer(lo, r, make([]byte, blobChunkSize))
+		if err != nil {
+			lo.Close()
+			return n, err
+		}
+		return n, lo.Close()
+	case db.Driver == SQLITE3:
+		affected, _, err := db.ExecContextRowsAffected(ctx, "UPDATE @ SET @ = X'' WHERE @ = ?", ref.Table, ref.Column, ref.PrimaryKey, ref.PK)
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			return 0, ErrQueryReturnedZeroRows
+		}
+		write = func(offset int64, chunk []byte) error {
+			return db.ExecContext(ctx, "UPDATE @ SET @ = CAST(@ || ? AS BLOB) WHERE @ = ?",
+				ref.Table, ref.Column, ref.Column, chunk, ref.PrimaryKey, ref.PK)
+		}
+	default:
+		return 0, fmt.Errorf("InsertBlob: Unsupported driver %q.", db.Driver)
+	}
+
+	var written int64
+	buf := make([]byte, blobChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			err = write(written, buf[:n])
+			if err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+		switch readErr {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return written, nil
+		default:
+			return written, readErr
+		}
+	}
+}
+
+// ReadBlob copies the blob column of the row given by ref into w, in
+// chunks so the data is never held in memory as a whole. Large objects are
+// read in a read-only transaction if the handle is no transaction. A NULL blob
+// writes nothing. The number of bytes copied is returned.
+func (db *DB) ReadBlob(ctx context.Context, ref BlobRef, w io.Writer) (int64, error) {
+	ref, err := ref.check("ReadBlob")
+	if err != nil {
+		return 0, err
+	}
+
+	var read func(offset int64) ([]byte, error)
+
+	switch {
+	case db.Driver == POSTGRES && ref.Bytea:
+		read = func(offset int64) (chunk []byte, err error) {
+			err = db.QueryRowContext(ctx, "SELECT substring(@ FROM ? FOR ?) FROM @ WHERE @ = ?",
+				[]interface{}{ref.Column, offset + 1, blobChunkSize, ref.Table, ref.PrimaryKey, ref.PK}, &chunk)
+			return chunk, err
+		}
+	case db.Driver == POSTGRES:
+		if db.sqlTx == nil {
+			// large objects can only be opened in a transaction
+			tx, err := db.BeginContext(ctx, &sql.TxOptions{ReadOnly: true})
+			if err != nil {
+				return 0, err
+			}
+			defer tx.Rollback()
+			return tx.ReadBlob(ctx, ref, w)
+		}
+		var oid *int64
+		err = db.QueryContext(ctx, &oid, "SELECT @ FROM @ WHERE @ = ?", ref.Column, ref.Table, ref.PrimaryKey, ref.PK)
+		if err != nil {
+			return 0, err
+		}
+		if oid == nil {
+			return 0, nil
+		}
+		lo, err := db.OpenLargeObject(ctx, *oid, LargeObjectRead)
+		if err != nil {
+			return 0, err
+		}
+		defer lo.Close()
+		return io.CopyBuffer(w, lo, make([]byte, blobChunkSize))
+	case db.Driver == SQLITE3:
+		read = func(offset int64) (chunk []byte, err error) {
+			err = db.QueryRowContext(ctx, "SELECT substr(@, ?, ?) FROM @ WHERE @ = ?",
+				[]interface{}{ref.Column, offset + 1, blobChunkSize, ref.Table, ref.PrimaryKey, ref.PK}, &chunk)
+			return chunk, err
+		}
+	default:
+		return 0, fmt.Errorf("ReadBlob: Unsupported driver %q.", db.Driver)
+	}
+
+	var copied int64
+	for {
+		chunk, err := read(copied)
+		if err != nil {
+			return copied, err
+		}
+		n, err := w.Write(chunk)
+		copied += int64(n)
+		if err != nil {
+			return copied, err
+		}
+		if len(chunk) < blobChunkSize {
+			return copied, nil
+		}
+	}
+}
+
+// LargeObjectMode is the access mode of OpenLargeObject
+type LargeObjectMode int32
+
+const (
+	LargeObjectWrite LargeObjectMode = 0x20000
+	LargeObjectRead  LargeObjectMode = 0x40000
+)
+
+// LargeObject is an open Postgres large object. It reads, writes and
+// seeks in chunks using the server side lo_* functions, so the object is
+// never held in memory as a whole. A LargeObject is only valid in the
+// transaction it was opened in.
+type LargeObject struct {
+	db  *DB
+	ctx context.Context
+	fd  int32
+}
+
+// CreateLargeObject creates an empty Postgres large object and returns its
+// oid. It needs to run inside a transaction.
+func (db *DB) CreateLargeObject(ctx context.Context) (int64, error) {
+	err := db.checkLargeObject("CreateLargeObject")
+	if err != nil {
+		return 0, err
+	}
+	var oid int64
+	err = db.QueryContext(ctx, &oid, "SELECT lo_create(0)")
+	return oid, err
+}
+
+// UnlinkLargeObject removes the Postgres large object oid. It needs to
+// run inside a transaction.
+func (db *DB) UnlinkLargeObject(ctx context.Context, oid int64) error {
+	err := db.checkLargeObject("UnlinkLargeObject")
+	if err != nil {
+		return err
+	}
+	return db.ExecContext(ctx, "SELECT lo_unlink(?)", oid)
+}
+
+// OpenLargeObject opens the Postgres large object oid in mode. It needs to
+// run inside a transaction and is closed at its end if not closed before.
+// ctx is used for all operations on the returned LargeObject.
+//
+//	lo, err := tx.OpenLargeObject(ctx, oid, sqlpro.LargeObjectRead)
+//	if err != nil {
+//		return err
+//	}
+//	defer lo.Close()
+//	_, err = io.Copy(w, lo)
+func (db *DB) OpenLargeObject(ctx context.Context, oid int64, mode LargeObjectMode) (*LargeObject, error) {
+	err := db.checkLargeObject("OpenLargeObject")
+	if err != nil {
+		return nil, err
+	}
+	lo := &LargeObject{db: db, ctx: ctx}
+	err = db.QueryContext(ctx, &lo.fd, "SELECT lo_open(?, ?)", oid, int32(mode))
+	if err != nil {
+		return nil, err
+	}
+	return lo, nil
+}
+
+func (db *DB) checkLargeObject(op string) error {
+	if db.Driver != POSTGRES {
+		return fmt.Errorf("%s: Unsupported driver %q.", op, db.Driver)
+	}
+	if db.sqlTx == nil {
+		return fmt.Errorf("%s: Large objects need a transaction.", op)
+	}
+	return nil
+}
+
+// Read reads up to len(p) bytes, at most blobChunkSize per statement
+func (lo *LargeObject) Read(p []byte) (int, error) {
+	if len(p) > blobChunkSize {
+		p = p[:blobChunkSize]
+	}
+	var chunk []byte
+	err := lo.db.QueryRowContext(lo.ctx, "SELECT loread(?, ?)", []interface{}{lo.fd, len(p)}, &chunk)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, chunk)
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes p in chunks of blobChunkSize
+func (lo *LargeObject) Write(p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		chunk := p[written:min(written+blobChunkSize, len(p))]
+		var n int
+		err := lo.db.QueryRowContext(lo.ctx, "SELECT lowrite(?, ?)", []interface{}{lo.fd, chunk}, &n)
+		if err != nil {
+			return written, err
+		}
+		written += n
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Seek moves the position of the next Read or Write, see io.Seeker
+func (lo *LargeObject) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	err := lo.db.QueryRowContext(lo.ctx, "SELECT lo_lseek64(?, ?, ?)", []interface{}{lo.fd, offset, whence}, &pos)
+	return pos, err
+}
+
+// Tell returns the current position
+func (lo *LargeObject) Tell() (int64, error) {
+	var pos int64
+	err := lo.db.QueryRowContext(lo.ctx, "SELECT lo_tell64(?)", []interface{}{lo.fd}, &pos)
+	return pos, err
+}
+
+// Truncate truncates or extends the large object to size bytes
+func (lo *LargeObject) Truncate(size int64) error {
+	return lo.db.ExecContext(lo.ctx, "SELECT lo_truncate64(?, ?)", lo.fd, size)
+}
+
+// Close closes the large object
+func (lo *LargeObject) Close() error {
+	return lo.db.ExecContext(lo.ctx, "SELECT lo_close(?)", lo.fd)
+}
@@ -0,0 +1,218 @@
+package sqlpro
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrCheckConstraint error = errors.New("Check constraint failed.")
+
+// checkTerm is one comparison of a check constraint, check constraints are
+// a list of terms joined by AND
+type checkTerm struct {
+	left   string // column name or literal
+	op     string // >=, <=, <>, =, >, <, IS NULL, IS NOT NULL
+	right  string
+	source string
+}
+
+var (
+	checkAndRegexp     = regexp.MustCompile(`(?i)\s+AND\s+`)
+	checkOperand       = `([A-Za-z_][A-Za-z0-9_]*|"[^"]+"|-?[0-9]+(?:\.[0-9]+)?|'(?:[^']|'')*')`
+	checkCompareRegexp = regexp.MustCompile(`^\s*` + checkOperand + `\s*(>=|<=|<>|!=|=|>|<)\s*` + checkOperand + `\s*$`)
+	checkNullRegexp    = regexp.MustCompile(`(?i)^\s*` + checkOperand + `\s+IS\s+(NOT\s+)?NULL\s*$`)
+)
+
+// parseCheck parses a check constraint as given in the "check" tag. It
+// returns nil if the expression is too complex to be evaluated in Go, such
+// checks are only enforced by the database.
+func parseCheck(check string) []checkTerm {
+	terms := []checkTerm{}
+	for _, part := range checkAndRegexp.Split(strings.TrimSpace(check), -1) {
+		if m := checkNullRegexp.FindStringSubmatch(part); m != nil {
+			op := "IS NULL"
+			if m[2] != "" {
+				op = "IS NOT NULL"
+			}
+			terms = append(terms, checkTerm{left: m[1], op: op, source: part})
+			continue
+		}
+		m := checkCompareRegexp.FindStringSubmatch(part)
+		if m == nil {
+			return nil
+		}
+		op := m[2]
+		if op == "!=" {
+			op = "<>"
+		}
+		terms = append(terms, checkTerm{left: m[1], op: op, right: m[3], source: part})
+	}
+	return terms
+}
+
+// ValidateConstraints evaluates the check constraints declared in the
+// "check" tags of row, e.g.
+//
+//	D float64 `db:"d" check:"d >= 0"`
+//
+// Supported are comparisons of columns and literals, joined by AND, and
+// IS [NOT] NULL. Checks which cannot be evaluated in Go are skipped. The
+// returned error wraps ErrCheckConstraint.
+func (db *DB) ValidateConstraints(row interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(row))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Errorf("ValidateConstraints: need struct or pointer to struct, got %T", row))
+	}
+	info := getStructInfo(rv.Type())
+
+	for _, fi := range info {
+		for _, term := range fi.checks {
+			ok, err := term.eval(info, rv)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w %s: %s (value: %v)", ErrCheckConstraint, fi.dbName, fi.check, checkFieldValue(fi.fieldValue(rv)))
+			}
+		}
+	}
+	return nil
+}
+
+// CheckClauses returns the CHECK constraints declared in the "check" tags
+// of row, ordered by column, for CREATE TABLE statements:
+//
+//	err := db.Exec("CREATE TABLE item (id INTEGER PRIMARY KEY, d REAL, " +
+//		strings.Join(db.CheckClauses(item{}), ", ") + ")")
+//
+// This way the database enforces the checks ValidateConstraints evaluates
+// in Go, including those too complex for Go.
+func (db *DB) CheckClauses(row interface{}) []string {
+	rv := reflect.Indirect(reflect.ValueOf(row))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Errorf("CheckClauses: need struct or pointer to struct, got %T", row))
+	}
+	info := getStructInfo(rv.Type())
+
+	cols := mapKeys(info)
+	sort.Strings(cols)
+	clauses := []string{}
+	for _, col := range cols {
+		if info[col].check != "" {
+			clauses = append(clauses, "CHECK ("+info[col].check+")")
+		}
+	}
+	return clauses
+}
+
+// operand returns the value for s, which is a column or a literal
+func (term checkTerm) operand(info structInfo, rv reflect.Value, s string) (interface{}, error) {
+	if strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") && len(s) >= 2 {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	if strings.EqualFold(s, "NULL") {
+		return nil, nil
+	}
+	fi, ok := info[strings.Trim(s, `"`)]
+	if !ok {
+		return nil, fmt.Errorf("ValidateConstraints: Unknown column %q in check %q", s, term.source)
+	}
+	return checkFieldValue(fi.fieldValue(rv)), nil
+}
+
+// checkFieldValue returns the value of the field as float64 or string, or
+// <nil> for NULL
+func checkFieldValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		if v.Bool() {
+			return float64(1)
+		}
+		return float64(0)
+	case reflect.String:
+		return v.String()
+	}
+	return v.Interface()
+}
+
+// eval evaluates the term. Like in SQL, comparisons with NULL pass.
+func (term checkTerm) eval(info structInfo, rv reflect.Value) (bool, error) {
+	left, err := term.operand(info, rv, term.left)
+	if err != nil {
+		return false, err
+	}
+	switch term.op {
+	case "IS NULL":
+		return left == nil, nil
+	case "IS NOT NULL":
+		return left != nil, nil
+	}
+
+	right, err := term.operand(info, rv, term.right)
+	if err != nil {
+		return false, err
+	}
+	if left == nil || right == nil {
+		return true, nil
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return true, nil
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return true, nil
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		// Unable to compare in Go, leave it to the database
+		return true, nil
+	}
+
+	switch term.op {
+	case "=":
+		return cmp == 0, nil
+	case "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package sqlpro
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Clock is the time source of a DB handle. Set DB.Clock to a fake in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// QueryInfo is passed to DB.QueryHook and to Middlewares
+type QueryInfo struct {
+	SQL   string
+	Args  []interface{}
+	Start time.Time // taken from DB.Clock
+	Stop  time.Time // for queries, this is when the rows are returned, not when they are read
+	Err   error
+}
+
+// now returns the current time of the Clock of db
+func (db *DB) now() time.Time {
+	if db.Clock == nil {
+		return time.Now()
+	}
+	return db.Clock.Now()
+}
+
+// random returns the source of randomness of db
+func (db *DB) random() io.Reader {
+	if db.Rand == nil {
+		return rand.Reader
+	}
+	return db.Rand
+}
+
+// NewUUID returns a random (version 4) UUID read from DB.Rand. Use this for
+// generated ids, so tests can set a deterministic DB.Rand.
+func (db *DB) NewUUID() (string, error) {
+	var b [16]byte
+	_, err := io.ReadFull(db.random(), b[:])
+	if err != nil {
+		return "", fmt.Errorf("NewUUID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// runHook calls QueryHook for the statement sqlS started at start
+func (db *DB) runHook(ctx context.Context, sqlS string, args []interface{}, start time.Time, err error) {
+	if db.QueryHook == nil {
+		return
+	}
+	db.QueryHook(ctx, QueryInfo{SQL: sqlS, Args: args, Start: start, Stop: db.now(), Err: err})
+}
@@ -0,0 +1,195 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaRetryAfter is the time a replica is skipped after a connection
+// error, before it is pinged again
+var ReplicaRetryAfter = 5 * time.Second
+
+// replicaSet holds the read replicas of a handle opened by OpenCluster
+type replicaSet struct {
+	replicas []*DB
+	next     atomic.Uint64
+
+	mtx       sync.Mutex
+	downUntil []time.Time
+}
+
+// OpenCluster opens the primary database and its read replicas. The
+// returned handle sends SELECT queries outside transactions and read-only
+// transactions to the replicas in round-robin, everything else goes to the
+// primary. SELECT queries locking rows, e.g. FOR UPDATE, or calling
+// functions like nextval and transactions importing a snapshot run on the
+// primary as well. The replicas use the settings of the handle, e.g. its
+// schema and logger. Replicas failing with a connection error are skipped for
+// ReplicaRetryAfter, if no replica is available the primary is used. Use
+// Primary to read from the primary, e.g. directly after a write.
+func OpenCluster(driverS, primaryDSN string, replicaDSNs []string, opts ...Option) (*DB, error) {
+	primary, err := Open(driverS, primaryDSN, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rs := &replicaSet{downUntil: make([]time.Time, len(replicaDSNs))}
+	for _, dsn := range replicaDSNs {
+		replica, err := Open(driverS, dsn, opts...)
+		if err != nil {
+			rs.close()
+			primary.Close()
+			return nil, err
+		}
+		// ApplyConfig on the primary applies to the replicas
+		replica.config = primary.config
+		rs.replicas = append(rs.replicas, replica)
+	}
+	primary.replicas = rs
+	return primary, nil
+}
+
+// Primary returns a copy which sends all queries and transactions to the
+// primary database
+func (db *DB) Primary() *DB {
+	newDB := *db
+	newDB.primaryOnly = true
+	return &newDB
+}
+
+// replica returns the next healthy replica, or <nil> if the primary is to
+// be used
+func (db *DB) replica(ctx context.Context) *DB {
+	if db.replicas == nil || db.primaryOnly || db.sqlTx != nil {
+		return nil
+	}
+	rs := db.replicas
+	now := db.now()
+	for range rs.replicas {
+		idx := int(rs.next.Add(1) % uint64(len(rs.replicas)))
+
+		rs.mtx.Lock()
+		downUntil := rs.downUntil[idx]
+		rs.mtx.Unlock()
+
+		switch {
+		case downUntil.IsZero():
+			return rs.replicas[idx]
+		case now.Before(downUntil):
+			continue
+		}
+		// health check of a replica marked down
+		err := rs.replicas[idx].sqlDB.PingContext(ctx)
+		if err != nil {
+			rs.markDown(idx, now)
+			continue
+		}
+		rs.mtx.Lock()
+		rs.downUntil[idx] = time.Time{}
+		rs.mtx.Unlock()
+		return rs.replicas[idx]
+	}
+	return nil
+}
+
+// onReplica returns a copy of db which runs on the connections of
+// replica, so the settings of db, e.g. its schema, logger, debug level and
+// time layouts, apply on the replica, too
+func (db *DB) onReplica(replica *DB) *DB {
+	newDB := *db
+	newDB.db = replica.db
+	newDB.sqlDB = replica.sqlDB
+	newDB.sqliteConn = replica.sqliteConn
+	newDB.pgx = replica.pgx
+	newDB.maxIdleConns = replica.maxIdleConns
+	newDB.DSN = replica.DSN
+	newDB.MaxPlaceholder = replica.MaxPlaceholder
+	newDB.txBeginMtx = replica.txBeginMtx
+	newDB.openTxs = replica.openTxs
+	newDB.snapshotPool = replica.snapshotPool
+	newDB.stmtCache = replica.stmtCache
+	newDB.throttle = replica.throttle
+	newDB.flights = nil
+	newDB.fallback = nil
+	newDB.replicas = nil
+	return &newDB
+}
+
+var (
+	// replicaLockRegexp matches locking clauses, which need the primary
+	replicaLockRegexp = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|NO\s+KEY\s+UPDATE|SHARE|KEY\s+SHARE)\b`)
+	// replicaWriteRegexp matches SELECT INTO and functions which write or
+	// depend on the primary, e.g. sequences, advisory locks and snapshots
+	replicaWriteRegexp = regexp.MustCompile(`(?i)\bINTO\b|\b(nextval|setval|currval|lastval|pg_(try_)?advisory_\w+|txid_current\w*|pg_current_xact_id\w*|pg_export_snapshot|pg_current_wal_\w+|pg_notify|lo_\w+)\s*\(`)
+)
+
+// replicaQuery returns true if query can run on a replica, i.e. it is a
+// SELECT which does not lock rows or call functions needing the primary
+func replicaQuery(query string) bool {
+	return isSelect(query) && !replicaLockRegexp.MatchString(query) && !replicaWriteRegexp.MatchString(query)
+}
+
+// replicaFailed marks replica down if err is a connection error and
+// returns true in that case
+func (db *DB) replicaFailed(replica *DB, err error) bool {
+	if !IsConnectionError(err) {
+		return false
+	}
+	for idx, r := range db.replicas.replicas {
+		if r == replica {
+			db.replicas.markDown(idx, db.now())
+		}
+	}
+	return true
+}
+
+// queryReplica runs the SELECT query on a replica. It returns <nil> rows
+// if the query is to be run on the primary.
+func (db *DB) queryReplica(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if !replicaQuery(query) {
+		return nil, nil
+	}
+	replica := db.replica(ctx)
+	if replica == nil {
+		return nil, nil
+	}
+	rows, err := db.onReplica(replica).queryRows(ctx, query, args...)
+	if err != nil && db.replicaFailed(replica, err) {
+		return nil, nil
+	}
+	return rows, err
+}
+
+// beginReplica starts the read-only transaction on a replica. It returns
+// a <nil> tx if the transaction is to be started on the primary.
+func (db *DB) beginReplica(ctx context.Context, topts *sql.TxOptions) (*DB, error) {
+	replica := db.replica(ctx)
+	if replica == nil {
+		return nil, nil
+	}
+	tx, err := db.onReplica(replica).txBeginContext(ctx, topts)
+	if err != nil && db.replicaFailed(replica, err) {
+		return nil, nil
+	}
+	return tx, err
+}
+
+func (rs *replicaSet) markDown(idx int, now time.Time) {
+	rs.mtx.Lock()
+	rs.downUntil[idx] = now.Add(ReplicaRetryAfter)
+	rs.mtx.Unlock()
+}
+
+func (rs *replicaSet) close() error {
+	var firstErr error
+	for _, replica := range rs.replicas {
+		err := replica.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,54 @@
+package sqlpro
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type ctxCommentKey struct{}
+
+// WithComment returns a copy of ctx carrying key=value. All statements run
+// with this ctx get the values appended as SQL comment, e.g.
+//
+//	SELECT * FROM x /*request_id='abc'*/
+//
+// The format follows sqlcommenter, so DB-side logs can be correlated with
+// application traces.
+func WithComment(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(ctxCommentKey{}).(map[string]string)
+	values := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		values[k] = v
+	}
+	values[key] = value
+	return context.WithValue(ctx, ctxCommentKey{}, values)
+}
+
+// commentFromContext returns the SQL comment for the values set using
+// WithComment, or "" if none are set
+func commentFromContext(ctx context.Context) string {
+	values, _ := ctx.Value(ctxCommentKey{}).(map[string]string)
+	if len(values) == 0 {
+		return ""
+	}
+
+	keys := mapKeys(values)
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, url.QueryEscape(k)+"='"+url.QueryEscape(values[k])+"'")
+	}
+	return "/*" + strings.Join(parts, ",") + "*/"
+}
+
+// withComment appends the comment from ctx to query
+func withComment(ctx context.Context, query string) string {
+	comment := commentFromContext(ctx)
+	if comment == "" {
+		return query
+	}
+	return query + " " + comment
+}
@@ -0,0 +1,57 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+func (db *DB) SyncComments(table string, row interface{}) error {
+	return db.SyncCommentsContext(context.Background(), table, row)
+}
+
+// SyncCommentsContext sets the comments of the columns of table to the
+// "comment=" option of the db tags of row, e.g.
+//
+//	Name string `db:"name,notnull,comment=Full name, as entered"`
+//
+// The comment must be the last option of the tag. Columns without comment
+// option are left alone. SQLite does not support comments, there this does
+// nothing.
+func (db *DB) SyncCommentsContext(ctx context.Context, table string, row interface{}) error {
+	t := reflect.TypeOf(row)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("SyncComments: need struct, got %T", row))
+	}
+
+	switch db.Driver {
+	case POSTGRES:
+	case SQLITE3:
+		return nil
+	default:
+		return fmt.Errorf("SyncComments: Unsupported driver %q.", db.Driver)
+	}
+
+	info := getStructInfo(t)
+	cols := make([]string, 0, len(info))
+	for col, fi := range info {
+		// nested "prefix" fields are read from JOINs, not columns of table
+		if fi.comment != "" && (fi.path == nil || !fi.readOnly) {
+			cols = append(cols, col)
+		}
+	}
+	sort.Strings(cols)
+
+	for _, col := range cols {
+		// COMMENT does not support placeholders
+		err := db.ExecContext(ctx, "COMMENT ON COLUMN @.@ IS "+db.EscValue(info[col].comment), table, col)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
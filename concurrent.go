@@ -0,0 +1,112 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// ScanConcurrently runs query in parallel range queries with workers
+// goroutines. The first column of query needs to be an integer primary
+// key. The range between its minimum and maximum is split into one chunk
+// per worker, each worker queries its chunk and passes the rows, ordered
+// by primary key, to handler. As the handler reads the rows, slow handlers
+// slow down their query. The first error stops all workers and is
+// returned. ScanConcurrently can't be used on a transaction, as it does
+// not support parallel queries.
+//
+//	err := db.ScanConcurrently(ctx, "SELECT * FROM job WHERE status = ?", []interface{}{"open"}, 4,
+//		func(worker int, rows *sql.Rows) error {
+//			var jobs []job
+//			return sqlpro.Scan(&jobs, rows)
+//		})
+func (db *DB) ScanConcurrently(ctx context.Context, query string, args []interface{}, workers int, handler func(worker int, rows *sql.Rows) error) error {
+	if db.sqlTx != nil {
+		return fmt.Errorf("ScanConcurrently: Unable to run parallel queries in a transaction.")
+	}
+	if handler == nil {
+		return fmt.Errorf("ScanConcurrently: handler is required.")
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	subquery := "SELECT * FROM (" + query + ") AS t"
+
+	// the primary key is the first column
+	var probe *sql.Rows
+	err := db.QueryContext(ctx, &probe, db.Paginate(subquery, Limit(0)), args...)
+	if err != nil {
+		return err
+	}
+	cols, err := probe.Columns()
+	probe.Close()
+	if err != nil {
+		return err
+	}
+	pk := cols[0]
+
+	var minPK, maxPK sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT min(@), max(@) FROM ("+query+") AS t",
+		append([]interface{}{pk, pk}, args...), &minPK, &maxPK)
+	if err != nil {
+		return err
+	}
+	if !minPK.Valid {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errMtx   sync.Mutex
+		firstErr error
+	)
+	chunkSize := (maxPK.Int64-minPK.Int64)/int64(workers) + 1
+	for worker := 0; worker < workers; worker++ {
+		from := minPK.Int64 + int64(worker)*chunkSize
+		if from > maxPK.Int64 {
+			break
+		}
+		wg.Add(1)
+		go func(worker int, from int64) {
+			defer wg.Done()
+			// each worker sets LastError on its own copy
+			wdb := *db
+			err := wdb.scanChunk(ctx, subquery, args, pk, from, from+chunkSize, func(rows *sql.Rows) error {
+				return handler(worker, rows)
+			})
+			if err != nil {
+				errMtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMtx.Unlock()
+				cancel()
+			}
+		}(worker, from)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// scanChunk queries the rows of subquery with pk in [from, to) and passes
+// them to handler
+func (db *DB) scanChunk(ctx context.Context, subquery string, args []interface{}, pk string, from, to int64, handler func(rows *sql.Rows) error) error {
+	chunkArgs := append(append([]interface{}{}, args...), pk, from, pk, to, pk)
+	rows, err := db.queryRows(ctx, subquery+" WHERE @ >= ? AND @ < ? ORDER BY @", chunkArgs...)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	err = handler(rows)
+	if err != nil {
+		return err
+	}
+	return rows.Err()
+}
@@ -0,0 +1,94 @@
+package sqlpro
+
+import (
+	"time"
+)
+
+// Config holds the tunables which can be changed at runtime with
+// ApplyConfig, e.g. to turn on debugging in production from a signal
+// handler or an admin endpoint. Zero fields keep the current value.
+type Config struct {
+	Debug              DebugLevel    // replaces the level of SetDebug, the Debug flags still add to it
+	SlowQueryThreshold time.Duration // statements running longer are reported as WarningSlowQuery
+	TxRetry            TxRetry       // replaces DB.TxRetry
+	InspectMaxRows     int           // replaces DB.InspectMaxRows
+}
+
+// ApplyConfig atomically sets the non-zero fields of cfg in the runtime
+// configuration of db and all handles derived from it, including running
+// transactions and the replicas of OpenCluster. Once applied, the
+// configuration takes precedence over SetDebug, TxRetry and
+// InspectMaxRows. Use ResetConfig to go back to these.
+func (db *DB) ApplyConfig(cfg Config) {
+	for {
+		old := db.loadConfig()
+		merged := db.CurrentConfig()
+		if cfg.Debug != 0 {
+			merged.Debug = cfg.Debug
+		}
+		if cfg.SlowQueryThreshold != 0 {
+			merged.SlowQueryThreshold = cfg.SlowQueryThreshold
+		}
+		if cfg.TxRetry.Max != 0 || cfg.TxRetry.Backoff != nil {
+			merged.TxRetry = cfg.TxRetry
+		}
+		if cfg.InspectMaxRows != 0 {
+			merged.InspectMaxRows = cfg.InspectMaxRows
+		}
+		if db.config.CompareAndSwap(old, &merged) {
+			return
+		}
+	}
+}
+
+// ResetConfig drops the configuration applied by ApplyConfig, so the
+// values of SetDebug, TxRetry and InspectMaxRows are used again
+func (db *DB) ResetConfig() {
+	db.config.Store(nil)
+}
+
+// CurrentConfig returns the applied configuration, or the configuration
+// taken from the fields of db if ApplyConfig was not called
+func (db *DB) CurrentConfig() Config {
+	if cfg := db.loadConfig(); cfg != nil {
+		return *cfg
+	}
+	return Config{
+		Debug:          db.debugLevel,
+		TxRetry:        db.TxRetry,
+		InspectMaxRows: db.InspectMaxRows,
+	}
+}
+
+func (db *DB) loadConfig() *Config {
+	if db.config == nil {
+		return nil
+	}
+	return db.config.Load()
+}
+
+func (db *DB) txRetry() TxRetry {
+	if cfg := db.loadConfig(); cfg != nil {
+		return cfg.TxRetry
+	}
+	return db.TxRetry
+}
+
+func (db *DB) inspectMaxRows() int {
+	if cfg := db.loadConfig(); cfg != nil {
+		return cfg.InspectMaxRows
+	}
+	return db.InspectMaxRows
+}
+
+// checkSlowQuery reports a WarningSlowQuery if the statement started at
+// start exceeds the SlowQueryThreshold
+func (db *DB) checkSlowQuery(query string, start time.Time) {
+	cfg := db.loadConfig()
+	if cfg == nil || cfg.SlowQueryThreshold <= 0 {
+		return
+	}
+	if took := db.now().Sub(start); took > cfg.SlowQueryThreshold {
+		db.warn(WarningSlowQuery, query, "Statement took %s: %s", took, query)
+	}
+}
@@ -0,0 +1,81 @@
+package sqlpro
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// converter converts values of a registered type from and to the
+// database representation
+type converter struct {
+	toDB   func(interface{}) (interface{}, error)
+	fromDB func(interface{}, reflect.Value) error
+}
+
+var (
+	convertersMtx sync.RWMutex
+	converters    = map[reflect.Type]*converter{}
+)
+
+// RegisterConverter registers conversion funcs for values of type t, e.g.
+// uuid.UUID, decimal.Decimal or net.IP. toDB receives a value of type t and
+// returns a value the driver can store. fromDB receives the value scanned
+// from the database (<nil> for NULL) and sets it into target, which is an
+// addressable value of type t. Register converters during init, before
+// using the types in queries.
+func RegisterConverter(t reflect.Type, toDB func(interface{}) (interface{}, error), fromDB func(interface{}, reflect.Value) error) {
+	if toDB == nil || fromDB == nil {
+		panic(fmt.Errorf("RegisterConverter: toDB and fromDB must not be <nil> for %s", t))
+	}
+	convertersMtx.Lock()
+	defer convertersMtx.Unlock()
+	converters[t] = &converter{toDB: toDB, fromDB: fromDB}
+}
+
+// getConverter returns the converter registered for t or <nil>
+func getConverter(t reflect.Type) *converter {
+	convertersMtx.RLock()
+	defer convertersMtx.RUnlock()
+	return converters[t]
+}
+
+// convertToDB converts v using a registered converter, values of types
+// without converter are returned unchanged
+func convertToDB(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	conv := getConverter(reflect.TypeOf(v))
+	if conv == nil {
+		return v, nil
+	}
+	v2, err := conv.toDB(v)
+	if err != nil {
+		return nil, fmt.Errorf("sqlpro: Unable to convert %T for database: %w", v, err)
+	}
+	return v2, nil
+}
+
+// mustConvertToDB is convertToDB for callers which cannot return an error
+func mustConvertToDB(v interface{}) interface{} {
+	v2, err := convertToDB(v)
+	if err != nil {
+		panic(err)
+	}
+	return v2
+}
+
+// rawScan keeps the scanned value for a converter
+type rawScan struct {
+	value interface{}
+}
+
+func (rs *rawScan) Scan(value interface{}) error {
+	if b, ok := value.([]byte); ok {
+		// the driver may reuse the buffer
+		value = append([]byte(nil), b...)
+	}
+	rs.value = value
+	return nil
+}
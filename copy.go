@@ -0,0 +1,191 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// copyFromMaxArgs is the maximum number of placeholders CopyFrom
+// uses per INSERT statement
+const copyFromMaxArgs = 999
+
+func (db *DB) CopyFrom(table string, cols []string, next func() ([]interface{}, bool)) (int64, error) {
+	return db.CopyFromContext(context.Background(), table, cols, next)
+}
+
+// CopyFromContext streams rows into table. next is called for each row and
+// returns the values for cols, it returns false once all rows are read. This
+// way large imports can be done without holding all rows in memory.
+//
+// On Postgres the rows are sent using COPY, other drivers receive batches of
+// multi-row INSERT statements. pgx handles return an error, see OpenPgx.
+// If the handle is not a transaction, all rows are written within one
+// transaction. CopyFromContext returns the number of rows written.
+func (db *DB) CopyFromContext(ctx context.Context, table string, cols []string, next func() ([]interface{}, bool)) (int64, error) {
+	if len(cols) == 0 {
+		return 0, fmt.Errorf("CopyFrom: Need at least one column.")
+	}
+
+	err := db.checkIdentifiers(table, cols)
+	if err != nil {
+		return 0, err
+	}
+
+	if db.Driver == POSTGRES && db.pgx {
+		return 0, fmt.Errorf("CopyFrom: COPY is not available for pgx through database/sql.")
+	}
+
+	err = db.checkWrite("COPY " + table)
+	if err != nil {
+		return 0, err
+	}
+
+	if db.sqlTx == nil && db.sqlDB != nil {
+		tx, err := db.BeginContext(ctx, nil)
+		if err != nil {
+			return 0, db.sqlError(err, "BEGIN TRANSACTION", []interface{}{})
+		}
+		count, err := tx.CopyFromContext(ctx, table, cols, next)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+		return count, nil
+	}
+
+	if db.Driver == POSTGRES && db.sqlTx != nil {
+		return db.copyIn(ctx, db.sqlTx, table, cols, next)
+	}
+
+	return db.copyInsert(ctx, table, cols, next)
+}
+
+// copyIn sends the rows returned by next using the Postgres COPY protocol
+func (db *DB) copyIn(ctx context.Context, txn *sql.Tx, table string, cols []string, next func() ([]interface{}, bool)) (int64, error) {
+	var count int64
+
+	copyStmt := pq.CopyIn(table, cols...)
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		copyStmt = pq.CopyInSchema(schema, name, cols...)
+	} else if db.schema != "" {
+		copyStmt = pq.CopyInSchema(db.schema, table, cols...)
+	}
+	stmt, err := txn.PrepareContext(ctx, copyStmt)
+	if err != nil {
+		return 0, db.sqlError(err, "Prepare", []interface{}{})
+	}
+	defer stmt.Close()
+
+	for {
+		values, ok := next()
+		if !ok {
+			break
+		}
+		if len(values) != len(cols) {
+			return 0, fmt.Errorf("CopyFrom: Expected %d values, got %d.", len(cols), len(values))
+		}
+		_, err = stmt.ExecContext(ctx, values...)
+		if err != nil {
+			return 0, db.sqlError(err, "Exec", values)
+		}
+		count++
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, db.sqlError(err, "Exec DONE", []interface{}{})
+	}
+
+	return count, nil
+}
+
+// copyInsert sends the rows returned by next in batches of INSERT statements
+func (db *DB) copyInsert(ctx context.Context, table string, cols []string, next func() ([]interface{}, bool)) (int64, error) {
+	var count int64
+
+	batchSize := copyFromMaxArgs / len(cols)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	insert := strings.Builder{}
+	insert.WriteString("INSERT INTO ")
+	insert.WriteString(db.escTable(table))
+	insert.WriteString(" (")
+	for idx, col := range cols {
+		if idx > 0 {
+			insert.WriteRune(',')
+		}
+		insert.WriteString(db.Esc(col))
+	}
+	insert.WriteString(") VALUES ")
+	prefix := insert.String()
+
+	args := make([]interface{}, 0, batchSize*len(cols))
+	rows := 0
+
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+		sb := strings.Builder{}
+		sb.WriteString(prefix)
+		for i := 0; i < rows; i++ {
+			if i > 0 {
+				sb.WriteRune(',')
+			}
+			sb.WriteRune('(')
+			for j := range cols {
+				if j > 0 {
+					sb.WriteRune(',')
+				}
+				sb.WriteRune(db.PlaceholderValue)
+			}
+			sb.WriteRune(')')
+		}
+		rowsAffected, _, err := db.execContext(ctx, sb.String(), args...)
+		if err == nil && rowsAffected != int64(rows) {
+			err = ErrMismatchedRowsAffected
+		}
+		if err != nil {
+			return err
+		}
+		count += int64(rows)
+		args = args[:0]
+		rows = 0
+		return nil
+	}
+
+	for {
+		values, ok := next()
+		if !ok {
+			break
+		}
+		if len(values) != len(cols) {
+			return 0, fmt.Errorf("CopyFrom: Expected %d values, got %d.", len(cols), len(values))
+		}
+		args = append(args, values...)
+		rows++
+		if rows >= batchSize {
+			err := flush()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err := flush()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,354 @@
+package sqlpro
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dialect contains the SQL syntax differences between databases which
+// sqlpro needs to build statements. Register a Dialect with RegisterDialect
+// to use databases other than the built-in ones with Open.
+type Dialect interface {
+	// Driver returns the name of the database/sql driver, it is used as
+	// Driver of the handle
+	Driver() string
+	// Placeholder returns the placeholder style for bound args
+	Placeholder() PlaceholderMode
+	// EscIdent quotes the identifier name
+	EscIdent(name string) string
+	// Returning reports if the id of inserted rows is read using
+	// RETURNING, otherwise LastInsertId is used
+	Returning() bool
+	// Upsert returns the clause appended to an INSERT, which updates the
+	// columns update of the existing row if the row conflicts on the
+	// unique columns conflict, or "" if the database has no such clause
+	Upsert(conflict, update []string) string
+	// Paginate appends the clauses for limit and offset to query, limit is
+	// -1 for no limit
+	Paginate(query string, limit, offset int64) string
+	// UpdateFrom returns an UPDATE of table, which sets the columns set
+	// from rows joined on the key columns. table is escaped, each row
+	// holds the escaped values of cols.
+	UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string
+}
+
+var (
+	dialectsMtx sync.RWMutex
+	dialects    = map[string]Dialect{}
+)
+
+func init() {
+	RegisterDialect("postgres", postgresDialect{})
+	RegisterDialect("cockroach", postgresDialect{}) // CockroachDB speaks the Postgres protocol
+	RegisterDialect("sqlite3", sqliteDialect{})
+	RegisterDialect("mysql", mysqlDialect{})
+	RegisterDialect("sqlserver", mssqlDialect{})
+	RegisterDialect("duckdb", duckdbDialect{})
+}
+
+// RegisterDialect makes dialect available to Open by name. As with
+// sql.Register, it panics if name is already registered or dialect is nil.
+func RegisterDialect(name string, dialect Dialect) {
+	dialectsMtx.Lock()
+	defer dialectsMtx.Unlock()
+
+	if dialect == nil {
+		panic("sqlpro.RegisterDialect: Dialect is nil.")
+	}
+	if _, ok := dialects[name]; ok {
+		panic(fmt.Sprintf("sqlpro.RegisterDialect: Dialect %q registered twice.", name))
+	}
+	dialects[name] = dialect
+}
+
+func lookupDialect(name string) Dialect {
+	dialectsMtx.RLock()
+	defer dialectsMtx.RUnlock()
+
+	return dialects[name]
+}
+
+// dialect returns the dialect of the handle. The dialect set by Open is
+// only used while it matches Driver, so handles with a changed Driver
+// use the dialect registered for the driver.
+func (db *DB) dialect() Dialect {
+	if db.dialectImpl != nil && db.dialectImpl.Driver() == string(db.Driver) {
+		return db.dialectImpl
+	}
+	if d := lookupDialect(string(db.Driver)); d != nil {
+		return d
+	}
+	return standardDialect{}
+}
+
+// standardDialect follows the SQL standard and is the base of the
+// built-in dialects
+type standardDialect struct{}
+
+func (standardDialect) Driver() string {
+	return ""
+}
+
+func (standardDialect) Placeholder() PlaceholderMode {
+	return QUESTION
+}
+
+func (standardDialect) EscIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (standardDialect) Returning() bool {
+	return false
+}
+
+func (d standardDialect) Upsert(conflict, update []string) string {
+	return upsertOnConflict(d, conflict, update)
+}
+
+func (standardDialect) Paginate(query string, limit, offset int64) string {
+	// SQL:2008, needs ORDER BY on some databases
+	query += " OFFSET " + strconv.FormatInt(max(offset, 0), 10) + " ROWS"
+	if limit >= 0 {
+		query += " FETCH NEXT " + strconv.FormatInt(limit, 10) + " ROWS ONLY"
+	}
+	return query
+}
+
+func (d standardDialect) UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string {
+	// UPDATE ... FROM with a VALUES list in a CTE, as SQLite has no column
+	// names for subqueries
+	return "WITH v(" + escJoinWith(d, cols) + ") AS (" + valuesList(rows) + ") UPDATE " + table +
+		" SET " + updateSets(d, "", set) + " FROM v WHERE " + updateKeys(d, table, key)
+}
+
+type postgresDialect struct {
+	standardDialect
+}
+
+func (postgresDialect) Driver() string {
+	return POSTGRES
+}
+
+func (postgresDialect) Placeholder() PlaceholderMode {
+	return DOLLAR
+}
+
+func (postgresDialect) Returning() bool {
+	return true
+}
+
+func (postgresDialect) Paginate(query string, limit, offset int64) string {
+	return paginateLimit(query, limit, offset, "")
+}
+
+func (d postgresDialect) UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string {
+	// Postgres resolves the types of a VALUES list from its content, untyped
+	// literals end up as text. We prepend a typed row of NULLs taken from
+	// the table's row type, it never matches the primary key.
+	typed := make([]string, 0, len(cols))
+	for _, col := range cols {
+		typed = append(typed, "(NULL::"+table+")."+d.EscIdent(col))
+	}
+	return updateFromValues(d, table, cols, append([][]string{typed}, rows...), set, key)
+}
+
+type sqliteDialect struct {
+	standardDialect
+}
+
+func (sqliteDialect) Driver() string {
+	return SQLITE3
+}
+
+func (sqliteDialect) Paginate(query string, limit, offset int64) string {
+	// SQLite needs a LIMIT for OFFSET
+	return paginateLimit(query, limit, offset, "-1")
+}
+
+type mysqlDialect struct {
+	standardDialect
+}
+
+func (mysqlDialect) Driver() string {
+	return MYSQL
+}
+
+func (mysqlDialect) EscIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d mysqlDialect) Upsert(conflict, update []string) string {
+	sets := make([]string, 0, len(update))
+	for _, col := range update {
+		sets = append(sets, d.EscIdent(col)+" = VALUES("+d.EscIdent(col)+")")
+	}
+	// MySQL uses all unique keys of the table
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (mysqlDialect) Paginate(query string, limit, offset int64) string {
+	// MySQL needs a LIMIT for OFFSET, this is the documented maximum
+	return paginateLimit(query, limit, offset, "18446744073709551615")
+}
+
+func (d mysqlDialect) UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string {
+	// UPDATE ... JOIN with the rows as UNION ALL of SELECTs, which unlike
+	// VALUES ROW(...) works with all versions
+	selects := make([]string, 0, len(rows))
+	for idx, row := range rows {
+		values := make([]string, 0, len(row))
+		for idx2, value := range row {
+			if idx == 0 {
+				value += " AS " + d.EscIdent(cols[idx2])
+			}
+			values = append(values, value)
+		}
+		selects = append(selects, "SELECT "+strings.Join(values, ","))
+	}
+	return "UPDATE " + table + " JOIN (" + strings.Join(selects, "\nUNION ALL ") + ") AS v ON " +
+		updateKeys(d, table, key) + " SET " + updateSets(d, table, set)
+}
+
+type mssqlDialect struct {
+	standardDialect
+}
+
+func (mssqlDialect) Driver() string {
+	return MSSQL
+}
+
+func (mssqlDialect) Placeholder() PlaceholderMode {
+	return AT
+}
+
+func (mssqlDialect) EscIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// Returning is true for the OUTPUT clause used by SQL Server
+func (mssqlDialect) Returning() bool {
+	return true
+}
+
+// Upsert returns "", SQL Server only supports upserts using MERGE
+func (mssqlDialect) Upsert(conflict, update []string) string {
+	return ""
+}
+
+func (d mssqlDialect) Paginate(query string, limit, offset int64) string {
+	if offset <= 0 {
+		// OFFSET ... FETCH needs ORDER BY, TOP does not
+		return selectTop(query, limit)
+	}
+	return d.standardDialect.Paginate(query, limit, offset)
+}
+
+func (d mssqlDialect) UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string {
+	return "UPDATE " + table + " SET " + updateSets(d, "", set) + " FROM " + table +
+		" JOIN (" + valuesList(rows) + ") AS v(" + escJoinWith(d, cols) + ") ON " + updateKeys(d, table, key)
+}
+
+// duckdbDialect has no LastInsertId, ids are read using RETURNING
+type duckdbDialect struct {
+	standardDialect
+}
+
+func (duckdbDialect) Driver() string {
+	return DUCKDB
+}
+
+func (duckdbDialect) Returning() bool {
+	return true
+}
+
+func (duckdbDialect) Paginate(query string, limit, offset int64) string {
+	return paginateLimit(query, limit, offset, "")
+}
+
+func (d duckdbDialect) UpdateFrom(table string, cols []string, rows [][]string, set, key []string) string {
+	return updateFromValues(d, table, cols, rows, set, key)
+}
+
+// updateFromValues returns the UPDATE ... FROM (VALUES ...) used by
+// Postgres and DuckDB
+func updateFromValues(d Dialect, table string, cols []string, rows [][]string, set, key []string) string {
+	return "UPDATE " + table + " SET " + updateSets(d, "", set) + " FROM (" + valuesList(rows) +
+		") AS v(" + escJoinWith(d, cols) + ") WHERE " + updateKeys(d, table, key)
+}
+
+// valuesList returns the VALUES list of rows
+func valuesList(rows [][]string) string {
+	sb := strings.Builder{}
+	sb.WriteString("VALUES ")
+	for idx, row := range rows {
+		if idx > 0 {
+			sb.WriteString(",\n")
+		}
+		sb.WriteRune('(')
+		sb.WriteString(strings.Join(row, ","))
+		sb.WriteRune(')')
+	}
+	return sb.String()
+}
+
+// updateSets returns the SET list assigning the columns set from v, with
+// the escaped table prepended to the columns if not empty
+func updateSets(d Dialect, table string, set []string) string {
+	sets := make([]string, 0, len(set))
+	for _, col := range set {
+		target := d.EscIdent(col)
+		if table != "" {
+			target = table + "." + target
+		}
+		sets = append(sets, target+"=v."+d.EscIdent(col))
+	}
+	return strings.Join(sets, ",")
+}
+
+// updateKeys returns the condition joining table and v on the key columns
+func updateKeys(d Dialect, table string, key []string) string {
+	conds := make([]string, 0, len(key))
+	for _, col := range key {
+		conds = append(conds, table+"."+d.EscIdent(col)+"=v."+d.EscIdent(col))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// escJoinWith escapes the names with the dialect and joins them with ","
+func escJoinWith(d Dialect, names []string) string {
+	escaped := make([]string, 0, len(names))
+	for _, name := range names {
+		escaped = append(escaped, d.EscIdent(name))
+	}
+	return strings.Join(escaped, ",")
+}
+
+// upsertOnConflict returns the ON CONFLICT clause used by Postgres and
+// SQLite
+func upsertOnConflict(d Dialect, conflict, update []string) string {
+	sets := make([]string, 0, len(update))
+	for _, col := range update {
+		sets = append(sets, d.EscIdent(col)+" = excluded."+d.EscIdent(col))
+	}
+	escConflict := make([]string, 0, len(conflict))
+	for _, col := range conflict {
+		escConflict = append(escConflict, d.EscIdent(col))
+	}
+	return "ON CONFLICT (" + strings.Join(escConflict, ",") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// paginateLimit appends LIMIT and OFFSET to query, noLimit is used as
+// LIMIT for an OFFSET without limit if not empty
+func paginateLimit(query string, limit, offset int64, noLimit string) string {
+	if limit >= 0 {
+		query += " LIMIT " + strconv.FormatInt(limit, 10)
+	} else if noLimit != "" {
+		query += " LIMIT " + noLimit
+	}
+	if offset > 0 {
+		query += " OFFSET " + strconv.FormatInt(offset, 10)
+	}
+	return query
+}
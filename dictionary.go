@@ -0,0 +1,124 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Dictionary is a catalog of the tables of a database, see DataDictionary
+type Dictionary struct {
+	Driver dbDriver          `json:"driver"`
+	Tables []DictionaryTable `json:"tables"`
+}
+
+type DictionaryTable struct {
+	Name    string             `json:"name"`
+	Comment string             `json:"comment,omitempty"`
+	GoType  string             `json:"go_type,omitempty"` // registered using RegisterTable
+	Columns []DictionaryColumn `json:"columns"`
+}
+
+type DictionaryColumn struct {
+	Name         string `json:"name" db:"name"`
+	DatabaseType string `json:"database_type" db:"type"`
+	NotNull      bool   `json:"not_null" db:"notnull"`
+	PrimaryKey   bool   `json:"primary_key" db:"pk"`
+	Comment      string `json:"comment,omitempty" db:"comment"`
+	GoField      string `json:"go_field,omitempty" db:"-"`
+	GoType       string `json:"go_type,omitempty" db:"-"`
+	Tag          string `json:"tag,omitempty" db:"-"`
+}
+
+var registeredTables sync.Map // table -> reflect.Type
+
+// RegisterTable registers the struct type of row as Go type of table. This
+// is used by DataDictionary to add the Go fields to the columns.
+func RegisterTable(table string, row interface{}) {
+	t := reflect.TypeOf(row)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("RegisterTable: need struct, got %T", row))
+	}
+	registeredTables.Store(table, t)
+}
+
+// DataDictionary returns all tables of the database with their columns,
+// comments (Postgres) and the Go fields of the types registered with
+// RegisterTable. The result can be rendered as JSON.
+func (db *DB) DataDictionary(ctx context.Context) (*Dictionary, error) {
+	type tableRow struct {
+		Name    string `db:"name"`
+		Comment string `db:"comment"`
+	}
+
+	var (
+		tables     []tableRow
+		err        error
+		colsQuery  string
+		tableQuery string
+	)
+
+	switch db.Driver {
+	case SQLITE3:
+		tableQuery = `SELECT name, '' AS comment FROM sqlite_master
+			WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+		colsQuery = `SELECT name, type, "notnull" <> 0 AS "notnull", pk <> 0 AS pk, '' AS comment
+			FROM pragma_table_info(?) ORDER BY cid`
+	case POSTGRES:
+		tableQuery = `SELECT c.relname AS name, COALESCE(obj_description(c.oid, 'pg_class'), '') AS comment
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND n.nspname = current_schema() ORDER BY c.relname`
+		colsQuery = `SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type,
+				a.attnotnull AS "notnull",
+				EXISTS(SELECT 1 FROM pg_index i WHERE i.indrelid = a.attrelid AND i.indisprimary AND a.attnum = ANY(i.indkey)) AS pk,
+				COALESCE(col_description(a.attrelid, a.attnum), '') AS comment
+			FROM pg_attribute a
+			WHERE a.attrelid = ?::regclass AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum`
+	default:
+		return nil, fmt.Errorf("DataDictionary: Unsupported driver %q.", db.Driver)
+	}
+
+	err = db.QueryContext(ctx, &tables, tableQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := &Dictionary{Driver: db.Driver, Tables: []DictionaryTable{}}
+	for _, table := range tables {
+		dt := DictionaryTable{Name: table.Name, Comment: table.Comment, Columns: []DictionaryColumn{}}
+
+		tableArg := table.Name
+		if db.Driver == POSTGRES {
+			tableArg = db.Esc(table.Name)
+		}
+		err = db.QueryContext(ctx, &dt.Columns, colsQuery, tableArg)
+		if err != nil {
+			return nil, err
+		}
+
+		if t, ok := registeredTables.Load(table.Name); ok {
+			rt := t.(reflect.Type)
+			dt.GoType = rt.String()
+			info := getStructInfo(rt)
+			for idx := range dt.Columns {
+				fi, ok := info[dt.Columns[idx].Name]
+				if !ok {
+					continue
+				}
+				dt.Columns[idx].GoField = fi.name
+				dt.Columns[idx].GoType = fi.structField.Type.String()
+				dt.Columns[idx].Tag = string(fi.structField.Tag)
+				if dt.Columns[idx].Comment == "" {
+					dt.Columns[idx].Comment = fi.comment
+				}
+			}
+		}
+
+		dict.Tables = append(dict.Tables, dt)
+	}
+	return dict, nil
+}
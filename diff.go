@@ -0,0 +1,277 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type RowDiffKind string
+
+const (
+	DiffInserted RowDiffKind = "inserted" // row only exists in b
+	DiffChanged  RowDiffKind = "changed"  // row exists in a and b with different values
+	DiffDeleted  RowDiffKind = "deleted"  // row only exists in a
+)
+
+// RowDiff describes one difference found by DiffRows
+type RowDiff struct {
+	Kind    RowDiffKind
+	Key     []interface{}          // values of the key columns
+	Columns []string               // changed columns, set for DiffChanged
+	A       map[string]interface{} // row in a, <nil> for DiffInserted
+	B       map[string]interface{} // row in b, <nil> for DiffDeleted
+}
+
+// DiffRows compares table in a and b row by row and calls fn for every
+// difference. Both sides are streamed ordered by keyCols, which must
+// identify a row. The ordering of the key values needs to be the same in both
+// databases, so text keys should use a byte-wise (C) collation.
+//
+// Values are compared after normalizing []byte to string, numbers to float64
+// and times to UTC, so a and b can use different drivers.
+func DiffRows(ctx context.Context, a, b *DB, table string, keyCols []string, fn func(diff RowDiff) error) error {
+	if len(keyCols) == 0 {
+		return fmt.Errorf("DiffRows: Need at least one key column.")
+	}
+
+	ca, err := newDiffCursor(ctx, a, table, keyCols)
+	if err != nil {
+		return err
+	}
+	defer closeRows(ca.rows)
+
+	cb, err := newDiffCursor(ctx, b, table, keyCols)
+	if err != nil {
+		return err
+	}
+	defer closeRows(cb.rows)
+
+	cols := ca.cols
+	for _, col := range cb.cols {
+		if _, ok := ca.colIdx[col]; !ok {
+			cols = append(cols, col)
+		}
+	}
+
+	for _, c := range []*diffCursor{ca, cb} {
+		err = c.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	for !ca.done || !cb.done {
+		var diff *RowDiff
+
+		cmp := 0
+		switch {
+		case ca.done:
+			cmp = 1
+		case cb.done:
+			cmp = -1
+		default:
+			cmp = compareKeys(ca.key(), cb.key())
+		}
+
+		switch {
+		case cmp < 0:
+			diff = &RowDiff{Kind: DiffDeleted, Key: ca.key(), A: ca.rowMap()}
+			err = ca.next()
+		case cmp > 0:
+			diff = &RowDiff{Kind: DiffInserted, Key: cb.key(), B: cb.rowMap()}
+			err = cb.next()
+		default:
+			changed := []string{}
+			for _, col := range cols {
+				if !diffValueEqual(ca.value(col), cb.value(col)) {
+					changed = append(changed, col)
+				}
+			}
+			if len(changed) > 0 {
+				diff = &RowDiff{Kind: DiffChanged, Key: ca.key(), Columns: changed, A: ca.rowMap(), B: cb.rowMap()}
+			}
+			err = ca.next()
+			if err == nil {
+				err = cb.next()
+			}
+		}
+
+		if diff != nil {
+			// fn is called with the rows read before advancing
+			errFn := fn(*diff)
+			if errFn != nil {
+				return errFn
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffCursor streams the rows of one side of DiffRows
+type diffCursor struct {
+	rows   *sql.Rows
+	cols   []string
+	colIdx map[string]int
+	keyIdx []int
+	row    []interface{}
+	done   bool
+}
+
+func newDiffCursor(ctx context.Context, db *DB, table string, keyCols []string) (*diffCursor, error) {
+	rows, err := db.queryRows(ctx, "SELECT * FROM @ ORDER BY "+db.escJoin(keyCols), table)
+	if err != nil {
+		return nil, err
+	}
+	// both cursors are open while fn runs, don't hold the slot
+	releaseRows(rows)
+
+	c := &diffCursor{rows: rows, colIdx: map[string]int{}}
+	c.cols, err = rows.Columns()
+	if err != nil {
+		closeRows(rows)
+		return nil, err
+	}
+	for idx, col := range c.cols {
+		c.colIdx[col] = idx
+	}
+	for _, keyCol := range keyCols {
+		idx, ok := c.colIdx[keyCol]
+		if !ok {
+			closeRows(rows)
+			return nil, fmt.Errorf("DiffRows: Key column %q not found in %s.", keyCol, db)
+		}
+		c.keyIdx = append(c.keyIdx, idx)
+	}
+	return c, nil
+}
+
+func (c *diffCursor) next() error {
+	if !c.rows.Next() {
+		c.done = true
+		c.row = nil
+		return c.rows.Err()
+	}
+	row := make([]interface{}, len(c.cols))
+	ptrs := make([]interface{}, len(c.cols))
+	for idx := range row {
+		ptrs[idx] = &row[idx]
+	}
+	err := c.rows.Scan(ptrs...)
+	if err != nil {
+		return err
+	}
+	for idx, v := range row {
+		row[idx] = diffNormalize(v)
+	}
+	c.row = row
+	return nil
+}
+
+func (c *diffCursor) key() []interface{} {
+	key := make([]interface{}, 0, len(c.keyIdx))
+	for _, idx := range c.keyIdx {
+		key = append(key, c.row[idx])
+	}
+	return key
+}
+
+func (c *diffCursor) value(col string) interface{} {
+	idx, ok := c.colIdx[col]
+	if !ok {
+		return nil
+	}
+	return c.row[idx]
+}
+
+func (c *diffCursor) rowMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(c.cols))
+	for idx, col := range c.cols {
+		m[col] = c.row[idx]
+	}
+	return m
+}
+
+// diffNormalize converts driver values, so that values from
+// different drivers can be compared
+func diffNormalize(v interface{}) interface{} {
+	switch v0 := v.(type) {
+	case []byte:
+		return string(v0)
+	case int64:
+		return float64(v0)
+	case int32:
+		return float64(v0)
+	case float32:
+		return float64(v0)
+	case time.Time:
+		return v0.UTC()
+	default:
+		return v
+	}
+}
+
+func diffValueEqual(a, b interface{}) bool {
+	ta, okA := a.(time.Time)
+	tb, okB := b.(time.Time)
+	if okA && okB {
+		return ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareKeys compares the normalized key values, <nil> sorts first
+func compareKeys(a, b []interface{}) int {
+	for idx := range a {
+		cmp := compareValue(a[idx], b[idx])
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func compareValue(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch va := a.(type) {
+	case float64:
+		vb, ok := b.(float64)
+		if ok {
+			switch {
+			case va < vb:
+				return -1
+			case va > vb:
+				return 1
+			}
+			return 0
+		}
+	case time.Time:
+		vb, ok := b.(time.Time)
+		if ok {
+			switch {
+			case va.Before(vb):
+				return -1
+			case va.After(vb):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,101 @@
+package sqlpro
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PostgresDSN builds a Postgres connection URL with correctly escaped
+// parts
+type PostgresDSN struct {
+	Host     string // defaults to "localhost"
+	Port     int    // defaults to the driver default 5432
+	DB       string
+	User     string
+	Password string
+	SSLMode  string            // e.g. "disable", "require", "verify-full"
+	Params   map[string]string // further connection parameters, e.g. "application_name"
+}
+
+func (p PostgresDSN) String() string {
+	u := url.URL{Scheme: "postgres", Host: p.Host, Path: "/" + p.DB}
+	if u.Host == "" {
+		u.Host = "localhost"
+	}
+	if p.Port > 0 {
+		u.Host += ":" + strconv.Itoa(p.Port)
+	}
+	switch {
+	case p.User != "" && p.Password != "":
+		u.User = url.UserPassword(p.User, p.Password)
+	case p.User != "":
+		u.User = url.User(p.User)
+	}
+	q := url.Values{}
+	for key, value := range p.Params {
+		q.Set(key, value)
+	}
+	if p.SSLMode != "" {
+		q.Set("sslmode", p.SSLMode)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// redacted replaces secrets in DSNs
+const redacted = "xxxxx"
+
+// secretParams are DSN parameters holding secrets
+var secretParams = []string{"password", "sslpassword", "_auth_pass", sqliteKeyParam}
+
+// secretKeyValue matches secrets in key=value DSNs, values can be quoted
+var secretKeyValue = regexp.MustCompile(`(?i)\b(password|sslpassword)\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// SafeDSN returns the DSN of the handle with passwords and keys replaced,
+// use it instead of DSN for logging
+func (db *DB) SafeDSN() string {
+	return RedactDSN(db.DSN)
+}
+
+// RedactDSN returns dsn with passwords and keys replaced. It supports URLs,
+// key=value DSNs as used by Postgres and file names with parameters as
+// used by SQLite.
+func RedactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// the error would print the DSN
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		u.RawQuery = redactParams(u.RawQuery)
+		return u.String()
+	}
+	if path, query, found := strings.Cut(dsn, "?"); found {
+		return path + "?" + redactParams(query)
+	}
+	return secretKeyValue.ReplaceAllString(dsn, "$1="+redacted)
+}
+
+// redactParams replaces the values of secretParams in the URL query
+func redactParams(query string) string {
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return redacted
+	}
+	changed := false
+	for _, key := range secretParams {
+		if params.Has(key) {
+			params.Set(key, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return query
+	}
+	return params.Encode()
+}
@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
@@ -47,6 +49,10 @@ func checkData(data interface{}) (rv reflect.Value, structMode bool, err error)
 		return erro()
 	}
 
+	if isReadOnlyModel(rv.Type()) {
+		return rv, false, fmt.Errorf("%w: %s", ErrReadOnlyModel, rv.Type())
+	}
+
 	return rv, structMode, nil
 }
 
@@ -90,7 +96,7 @@ func (db *DB) InsertContext(ctx context.Context, table string, data interface{})
 			}
 			pk := structInfo.onlyPrimaryKey()
 			if pk != nil && pk.structField.Type.Kind() == reflect.Int64 {
-				setPrimaryKey(row.FieldByName(pk.name), insert_id)
+				setPrimaryKey(pk.fieldValue(row), insert_id)
 			}
 		}
 	} else {
@@ -101,7 +107,7 @@ func (db *DB) InsertContext(ctx context.Context, table string, data interface{})
 		pk := structInfo.onlyPrimaryKey()
 		// log.Printf("PK: %d", insert_id)
 		if pk != nil && pk.structField.Type.Kind() == reflect.Int64 && rv.CanAddr() {
-			setPrimaryKey(rv.FieldByName(pk.name), insert_id)
+			setPrimaryKey(pk.fieldValue(rv), insert_id)
 		}
 	}
 
@@ -134,7 +140,8 @@ func (db *DB) InsertBulk(table string, data interface{}) error {
 // []*struct
 // []struct
 //
-// sqlpro will executes one INSERT statement per call.
+// sqlpro will executes one INSERT statement per call. DuckDB handles opened
+// with WithAppender use the appender API instead.
 func (db *DB) InsertBulkContext(ctx context.Context, table string, data interface{}) error {
 	var (
 		rv         reflect.Value
@@ -173,11 +180,21 @@ func (db *DB) InsertBulkContext(ctx context.Context, table string, data interfac
 		}
 	}
 
+	err = db.checkIdentifiers(table, mapKeys(key_map))
+	if err != nil {
+		return err
+	}
+
+	appended, err := db.appendRows(ctx, table, rows, key_map)
+	if err != nil || appended {
+		return err
+	}
+
 	insert := strings.Builder{} // make([]string, 0)
 	keys := make([]string, 0, len(key_map))
 
 	insert.WriteString("INSERT INTO ")
-	insert.WriteString(db.Esc(table))
+	insert.WriteString(db.escTable(table))
 	insert.WriteString(" (")
 
 	idx := 0
@@ -222,9 +239,17 @@ func (db *DB) UpdateBulk(table string, data interface{}) error {
 	return db.UpdateBulkContext(context.Background(), table, data)
 }
 
-// UpdateBulkContext updates all records of the passed slice. It using a single
-// exec to send the data to the database. This is generally faster than calling Update
-// with a slice (which sends individual update requests).
+// UpdateBulkContext updates all records of the passed slice. Rows are grouped
+// by the set of columns they provide (omitempty may drop columns) and each group
+// is sent as one UPDATE statement joining the table with a VALUES list on the
+// primary key columns. This is generally much faster than calling Update with a
+// slice (which sends individual update requests).
+//
+// The statement is built by Dialect.UpdateFrom, e.g.
+//
+// Postgres: UPDATE t SET ... FROM (VALUES ...) AS v(...) WHERE t.pk = v.pk
+// SQLite:   WITH v(...) AS (VALUES ...) UPDATE t SET ... FROM v WHERE t.pk = v.pk
+// MySQL:    UPDATE t JOIN (SELECT ... UNION ALL SELECT ...) AS v ON t.pk = v.pk SET ...
 func (db *DB) UpdateBulkContext(ctx context.Context, table string, data interface{}) error {
 	var (
 		rv         reflect.Value
@@ -246,61 +271,102 @@ func (db *DB) UpdateBulkContext(ctx context.Context, table string, data interfac
 		return nil
 	}
 
-	update := strings.Builder{} // make([]string, 0)
+	groups := make(map[string]*updateBulkGroup, 0)
+	groupOrder := make([]string, 0)
+
 	for i := 0; i < l; i++ {
 		row := reflect.Indirect(rv.Index(i)).Interface()
 		values, structInfo, err := db.valuesFromStruct(row)
 		if err != nil {
 			return errors.Wrap(err, "sqlpro.UpdateBulk error.")
 		}
-		where := strings.Builder{}
-		whereCount := 0
-		update.WriteString("UPDATE ")
-		update.WriteString(db.Esc(table))
-		update.WriteString(" SET ")
-		idx2 := 0
-		for key, value := range values {
-			value2 := db.nullValue(value, structInfo[key])
-			if structInfo[key].primaryKey {
-				// skip primary keys for update
-				if value2 == nil {
-					return fmt.Errorf("Unable to build UPDATE clause with <nil> primary key: %s", key)
-				}
-				if whereCount > 0 {
-					where.WriteString(" AND ")
-				}
-				where.WriteString(db.Esc(key))
-				where.WriteRune('=')
-				where.WriteString(db.EscValueForInsert(value2, structInfo[key]))
-				whereCount++
-			} else {
-				if idx2 > 0 {
-					update.WriteRune(',')
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		groupKey := strings.Join(keys, "\x00")
+		group, ok := groups[groupKey]
+		if !ok {
+			err = db.checkIdentifiers(table, keys)
+			if err != nil {
+				return err
+			}
+			group = &updateBulkGroup{info: structInfo}
+			for _, key := range keys {
+				if structInfo[key].primaryKey {
+					group.pkKeys = append(group.pkKeys, key)
+				} else {
+					group.setKeys = append(group.setKeys, key)
 				}
-				idx2++
-				update.WriteString(db.Esc(key))
-				update.WriteRune('=')
-				update.WriteString(db.EscValueForInsert(value2, structInfo[key]))
 			}
+			if len(group.pkKeys) == 0 {
+				return fmt.Errorf("Unable to build UPDATE clause, at least one key needed.")
+			}
+			if len(group.setKeys) == 0 {
+				return fmt.Errorf("Unable to build UPDATE clause, no columns to set.")
+			}
+			groups[groupKey] = group
+			groupOrder = append(groupOrder, groupKey)
 		}
-		update.WriteString(" WHERE ")
-		update.Write([]byte(where.String()))
-		update.WriteRune(';')
-		update.WriteRune('\n')
-	}
 
-	rowsAffected, _, err := db.execContext(ctx, update.String())
-	if err == nil && rowsAffected != 1 {
-		err = ErrMismatchedRowsAffected
+		for _, key := range group.pkKeys {
+			if db.nullValue(values[key], structInfo[key]) == nil {
+				return fmt.Errorf("Unable to build UPDATE clause with <nil> primary key: %s", key)
+			}
+		}
+		group.rows = append(group.rows, values)
 	}
-	if err != nil {
-		return db.sqlError(err, update.String(), []interface{}{})
+
+	for _, groupKey := range groupOrder {
+		group := groups[groupKey]
+		update := db.updateBulkClause(table, group)
+		rowsAffected, _, err := db.execContext(ctx, update)
+		if err == nil && rowsAffected != int64(len(group.rows)) {
+			err = ErrMismatchedRowsAffected
+		}
+		if err != nil {
+			return db.sqlError(err, update, []interface{}{})
+		}
 	}
 
 	return nil
 }
 
+// updateBulkGroup collects rows for UpdateBulk which share the same columns
+type updateBulkGroup struct {
+	info    structInfo
+	pkKeys  []string
+	setKeys []string
+	rows    []map[string]interface{}
+}
+
+// updateBulkClause renders the UPDATE statement for one group of rows in
+// the syntax of the dialect
+func (db *DB) updateBulkClause(table string, group *updateBulkGroup) string {
+	keys := append(append([]string{}, group.pkKeys...), group.setKeys...)
+
+	rows := make([][]string, 0, len(group.rows))
+	for _, row := range group.rows {
+		values := make([]string, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, db.EscValueForInsert(row[key], group.info[key]))
+		}
+		rows = append(rows, values)
+	}
+	return db.dialect().UpdateFrom(db.escTable(table), keys, rows, group.setKeys, group.pkKeys)
+}
+
 func (db *DB) InsertBulkCopyIn(table string, data interface{}) error {
+	return db.InsertBulkCopyInContext(context.Background(), table, data)
+}
+
+// InsertBulkCopyInContext inserts the slice of structs using the Postgres
+// COPY protocol. If the handle is a transaction, the COPY runs inside that
+// transaction, otherwise a transaction is started and committed for the COPY.
+func (db *DB) InsertBulkCopyInContext(ctx context.Context, table string, data interface{}) error {
 	var (
 		rv         reflect.Value
 		structMode bool
@@ -338,35 +404,58 @@ func (db *DB) InsertBulkCopyIn(table string, data interface{}) error {
 		}
 	}
 
-	txn, err := db.sqlDB.Begin()
+	err = db.checkIdentifiers(table, mapKeys(key_map))
 	if err != nil {
-		return db.sqlError(err, "BEGIN TRANSACTION", []interface{}{})
-	}
-
-	keys := make([]string, 0, len(key_map))
-	for key := range key_map {
-		keys = append(keys, key)
+		return err
 	}
 
-	stmt, err := txn.Prepare(pq.CopyIn(table, keys...))
+	err = db.checkWrite("COPY " + table)
 	if err != nil {
-		return db.sqlError(err, "Prepare", []interface{}{})
+		return err
 	}
 
-	for _, row := range rows {
-		values := make([]interface{}, 0, len(key_map))
-		for _, key := range keys {
-			values = append(values, row[key])
+	var txn *sql.Tx
+
+	if db.sqlTx != nil {
+		txn = db.sqlTx
+	} else {
+		if db.sqlDB == nil {
+			panic("sqlpro.DB.InsertBulkCopyIn: The wrapper must be created using Open. The wrapper does not have access to the underlying sql.DB handle.")
 		}
-		_, err = stmt.Exec(values...)
+		txn, err = db.sqlDB.BeginTx(ctx, nil)
 		if err != nil {
-			return db.sqlError(err, "Exec", values)
+			return db.sqlError(err, "BEGIN TRANSACTION", []interface{}{})
 		}
+		defer func() {
+			if err != nil {
+				txn.Rollback()
+			}
+		}()
 	}
 
-	_, err = stmt.Exec()
+	keys := make([]string, 0, len(key_map))
+	for key := range key_map {
+		keys = append(keys, key)
+	}
+
+	idx := 0
+	_, err = db.copyIn(ctx, txn, table, keys, func() ([]interface{}, bool) {
+		if idx >= len(rows) {
+			return nil, false
+		}
+		values := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, rows[idx][key])
+		}
+		idx++
+		return values, true
+	})
 	if err != nil {
-		return db.sqlError(err, "Exec DONE", []interface{}{})
+		return err
+	}
+
+	if db.sqlTx != nil {
+		return nil
 	}
 
 	err = txn.Commit()
@@ -383,7 +472,7 @@ func (db *DB) insertStruct(ctx context.Context, table string, row interface{}) (
 		return 0, nil, err
 	}
 
-	sql, args, err := db.insertClauseFromValues(table, values, info)
+	sql, args, err := db.insertClauseFromValues(table, values, info, "")
 	if err != nil {
 		return 0, nil, err
 	}
@@ -392,21 +481,29 @@ func (db *DB) insertStruct(ctx context.Context, table string, row interface{}) (
 		pk := info.onlyPrimaryKey()
 		if pk != nil && pk.structField.Type.Kind() == reflect.Int64 {
 
-			// Fail if transaction present and not in write mode
-			if db.sqlTx != nil && !db.txWriteMode {
-				return 0, nil, fmt.Errorf("[%s] Trying to write into read-only transaction: %s", db, sql)
+			err := db.checkWrite(sql)
+			if err != nil {
+				return 0, nil, err
 			}
 
-			sql = sql + " RETURNING " + db.Esc(pk.dbName)
+			if db.Driver == MSSQL {
+				sql, args, err = db.insertClauseFromValues(table, values, info, "OUTPUT INSERTED."+db.Esc(pk.dbName))
+				if err != nil {
+					return 0, nil, err
+				}
+			} else {
+				sql = sql + " RETURNING " + db.Esc(pk.dbName)
+			}
 			var insert_id int64 = 0
-			if db.Debug || db.DebugExec {
-				log.Printf("%s SQL: %s\nARGS:\n%s", db, golib.CutStr(sql, 2000, "..."), argsToString(args...))
+			if db.debug(INSERT) {
+				db.logf("%s SQL: %s\nARGS:\n%s", db, golib.CutStr(sql, 2000, "..."), argsToString(args...))
 			}
-			err := db.Query(&insert_id, sql, args...)
+			err = db.Query(&insert_id, sql, args...)
 			if err != nil {
 				return 0, nil, err
 			}
 			// log.Printf("Returning ID: %d", insert_id)
+			db.auditTimes(ctx, table, values, info, insert_id)
 			return insert_id, info, nil
 		}
 	}
@@ -420,10 +517,18 @@ func (db *DB) insertStruct(ctx context.Context, table string, row interface{}) (
 		return 0, nil, err
 	}
 
+	db.auditTimes(ctx, table, values, info, db.auditPrimaryKey(values, info, insert_id))
 	return insert_id, info, nil
 }
 
-func (db *DB) insertClauseFromValues(table string, values map[string]interface{}, info structInfo) (string, []interface{}, error) {
+// insertClauseFromValues returns the INSERT statement for values, output
+// is put before VALUES, e.g. the OUTPUT clause of SQL Server
+func (db *DB) insertClauseFromValues(table string, values map[string]interface{}, info structInfo, output string) (string, []interface{}, error) {
+	err := db.checkIdentifiers(table, mapKeys(values))
+	if err != nil {
+		return "", nil, err
+	}
+
 	cols := make([]string, 0, len(values))
 	vs := make([]string, 0, len(values))
 	args := make([]interface{}, 0, len(values))
@@ -433,9 +538,13 @@ func (db *DB) insertClauseFromValues(table string, values map[string]interface{}
 		vs = append(vs, "?")
 		args = append(args, db.nullValue(value, info[col]))
 	}
-	return fmt.Sprintf("INSERT INTO %s (%s) VALUES(%s)",
-		db.Esc(table),
+	if output != "" {
+		output += " "
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) %sVALUES(%s)",
+		db.escTable(table),
 		strings.Join(cols, ","),
+		output,
 		strings.Join(vs, ","),
 	), args, nil
 }
@@ -454,11 +563,16 @@ func (db *DB) updateClauseFromRow(table string, row interface{}) (string, []inte
 		return "", nil, err
 	}
 
+	err = db.checkIdentifiers(table, mapKeys(values))
+	if err != nil {
+		return "", nil, err
+	}
+
 	update := strings.Builder{}
 	where := strings.Builder{}
 
 	update.WriteString("UPDATE ")
-	update.WriteString(db.Esc(table))
+	update.WriteString(db.escTable(table))
 	update.WriteString(" SET ")
 
 	where.WriteString(" WHERE ")
@@ -494,6 +608,20 @@ func (db *DB) updateClauseFromRow(table string, row interface{}) (string, []inte
 		return "", args, fmt.Errorf("Unable to build UPDATE clause, at least one key needed.")
 	}
 
+	if db.WarningHook != nil {
+		var omitted []string
+		for key, fi := range structInfo {
+			_, ok := values[key]
+			if !ok && fi.omitEmpty && !fi.readOnly {
+				omitted = append(omitted, key)
+			}
+		}
+		if len(omitted) > 0 {
+			sort.Strings(omitted)
+			db.warn(WarningOmitEmpty, "", "UPDATE %s: empty columns not updated: %s", table, strings.Join(omitted, ", "))
+		}
+	}
+
 	args = append(args, whereArgs...)
 
 	// Add where clause
@@ -538,6 +666,7 @@ func (db *DB) UpdateContext(ctx context.Context, table string, data interface{})
 		if err != nil {
 			return err
 		}
+		db.auditStructTimes(ctx, table, rv)
 	} else {
 		for i := 0; i < rv.Len(); i++ {
 			row := reflect.Indirect(rv.Index(i))
@@ -552,12 +681,152 @@ func (db *DB) UpdateContext(ctx context.Context, table string, data interface{})
 			if err != nil {
 				return err
 			}
+			db.auditStructTimes(ctx, table, row)
 		}
 	}
 
 	return nil
 }
 
+// UpdateWhere updates the columns given by set in all rows of table
+// matching where with one UPDATE and returns the number of rows affected.
+// set is a map of column names to values or a struct, of which all
+// columns but the primary keys are set, omitempty columns are left out if
+// empty. where is required, placeholders in where are replaced by args.
+//
+//	db.UpdateWhere(ctx, "job", map[string]interface{}{"status": "done"}, "id IN ?", ids)
+func (db *DB) UpdateWhere(ctx context.Context, table string, set interface{}, where string, args ...interface{}) (int64, error) {
+	if strings.TrimSpace(where) == "" {
+		return 0, fmt.Errorf("UpdateWhere: where is required.")
+	}
+
+	values := map[string]interface{}{}
+	if m, ok := set.(map[string]interface{}); ok {
+		values = m
+	} else {
+		structValues, info, err := db.valuesFromStruct(set)
+		if err != nil {
+			return 0, err
+		}
+		for col, value := range structValues {
+			if !info.primaryKey(col) {
+				values[col] = db.nullValue(value, info[col])
+			}
+		}
+	}
+	if len(values) == 0 {
+		return 0, fmt.Errorf("UpdateWhere: No columns to set.")
+	}
+
+	cols := mapKeys(values)
+	sort.Strings(cols)
+	err := db.checkIdentifiers(table, cols)
+	if err != nil {
+		return 0, err
+	}
+
+	update := strings.Builder{}
+	update.WriteString("UPDATE ")
+	update.WriteString(db.escTable(table))
+	update.WriteString(" SET ")
+	setArgs := make([]interface{}, 0, len(cols)+len(args))
+	for idx, col := range cols {
+		if idx > 0 {
+			update.WriteString(",")
+		}
+		update.WriteString(db.Esc(col))
+		update.WriteString("=")
+		update.WriteRune(db.PlaceholderValue)
+		setArgs = append(setArgs, values[col])
+	}
+	update.WriteString(" WHERE ")
+	update.WriteString(where)
+
+	rowsAffected, _, err := db.execContext(ctx, update.String(), append(setArgs, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return rowsAffected, nil
+}
+
+// DeleteOptions are the options of DeleteWhere
+type DeleteOptions struct {
+	AllowFullTable bool        // delete all rows if where is empty or always true
+	Returning      interface{} // pointer to a slice receiving the primary keys of the deleted rows, not supported for MySQL
+	PrimaryKey     string      // primary key column for Returning, defaults to "id"
+}
+
+// DeleteWhere deletes all rows of table matching where and returns the
+// number of rows deleted. Placeholders in where are replaced by args. An
+// empty where or one which is trivially true, like "1=1", is refused
+// unless opts.AllowFullTable is set.
+//
+//	db.DeleteWhere(ctx, "job", "status = ?", sqlpro.DeleteOptions{Returning: &ids}, "done")
+func (db *DB) DeleteWhere(ctx context.Context, table string, where string, opts DeleteOptions, args ...interface{}) (int64, error) {
+	if !opts.AllowFullTable && alwaysTrue(where) {
+		return 0, fmt.Errorf("DeleteWhere: where %q matches all rows, set AllowFullTable to delete them.", where)
+	}
+	if opts.PrimaryKey == "" {
+		opts.PrimaryKey = "id"
+	}
+	err := db.checkIdentifiers(table, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "DELETE FROM " + db.escTable(table)
+	whereClause := ""
+	if strings.TrimSpace(where) != "" {
+		whereClause = " WHERE " + where
+	}
+
+	if opts.Returning == nil {
+		rowsAffected, _, err := db.execContext(ctx, stmt+whereClause, args...)
+		if err != nil {
+			return 0, err
+		}
+		return rowsAffected, nil
+	}
+
+	rv := reflect.ValueOf(opts.Returning)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return 0, fmt.Errorf("DeleteWhere: Returning needs a pointer to a slice, got %T.", opts.Returning)
+	}
+	switch db.Driver {
+	case MYSQL:
+		return 0, fmt.Errorf("DeleteWhere: Returning is not supported for %q.", db.Driver)
+	case MSSQL:
+		stmt += " OUTPUT DELETED." + db.Esc(opts.PrimaryKey) + whereClause
+	default:
+		stmt += whereClause + " RETURNING " + db.Esc(opts.PrimaryKey)
+	}
+	err = db.checkWrite(stmt)
+	if err != nil {
+		return 0, err
+	}
+	// Query appends to the slice
+	before := rv.Elem().Len()
+	err = db.QueryContext(ctx, opts.Returning, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int64(rv.Elem().Len() - before), nil
+}
+
+// alwaysTrue returns true if where is empty or trivially true, like
+// "TRUE", "1" or "1=1"
+func alwaysTrue(where string) bool {
+	w := strings.ToLower(strings.Join(strings.FieldsFunc(where, func(r rune) bool {
+		return r == '(' || r == ')' || unicode.IsSpace(r)
+	}), ""))
+	switch w {
+	case "", "true", "1", "notfalse", "not0":
+		return true
+	}
+	left, right, found := strings.Cut(w, "=")
+	return found && left == right && !strings.ContainsAny(left, "?@<>!")
+}
+
 // Save saves the given data. It performs an INSERT if the only primary key is
 // zero, and and UPDATE if it is not. It panics if it the record has no primary
 // key or less than one
@@ -617,10 +886,15 @@ func (db *DB) valuesFromStruct(data interface{}) (map[string]interface{}, struct
 	values = make(map[string]interface{}, 0)
 	dataV = reflect.ValueOf(data)
 
+	if isReadOnlyModel(dataV.Type()) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrReadOnlyModel, dataV.Type())
+	}
+
 	info = getStructInfo(dataV.Type())
+	db.warnUnexported(dataV.Type())
 
 	for _, fieldInfo := range info {
-		dataF := dataV.FieldByName(fieldInfo.name)
+		dataF := fieldInfo.fieldValue(dataV)
 
 		actualData := dataF.Interface()
 		isZero := isZero(actualData)
@@ -645,10 +919,43 @@ func (db *DB) valuesFromStruct(data interface{}) (map[string]interface{}, struct
 			// we write "null", if it is not specified we write NULL if the json renders to "null"
 			if isZero && (fieldInfo.null || !fieldInfo.notNull && string(actualData.([]byte)) == "null") {
 				actualData = nil
+			} else {
+				actualData, err = wrapJSONVersion(fieldInfo.structField.Type, actualData.([]byte))
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 		}
 
-		values[fieldInfo.dbName] = actualData
+		if fieldInfo.isArray {
+			actualData = pq.Array(actualData)
+		}
+
+		if fieldInfo.isDuration {
+			switch d := actualData.(type) {
+			case time.Duration:
+				actualData = d.Seconds()
+			case *time.Duration:
+				if d == nil {
+					actualData = nil
+				} else {
+					actualData = d.Seconds()
+				}
+			default:
+				return nil, nil, fmt.Errorf("Unable to use duration on field %s of type %T, need time.Duration.", fieldInfo.name, actualData)
+			}
+		}
+
+		actualData, err = unwrapNull(actualData)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Unable to get value of %s.", fieldInfo.name)
+		}
+		actualData, err = convertToDB(actualData)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values[fieldInfo.dbName] = db.timeForWrite(actualData)
 		// log.Printf("Name: %s Value: %v %v", fieldInfo.name, dataF.Interface(), isZero)
 	}
 	return values, info, nil
@@ -662,40 +969,57 @@ func isZero(x interface{}) bool {
 	return reflect.DeepEqual(x, reflect.Zero(reflect.TypeOf(x)).Interface())
 }
 
-// execContext wraps DB.Exec and returns the number of affected rows as reported
-// by the driver as well as the ID inserted, if the driver supports it.
-func (db *DB) execContext(ctx context.Context, execSql string, args ...interface{}) (rowsAffected, insertID int64, err error) {
-	var (
-		execSql0 string
-		newArgs  []interface{}
-	)
-
-	if db.Debug || db.DebugExec {
-		log.Printf("%s SQL: %s\nARGS:\n%s", db, golib.CutStr(execSql, 2000, "..."), argsToString(args...))
+// checkWrite returns an error if db is a read-only handle or a transaction
+// not in write mode
+func (db *DB) checkWrite(stmt string) error {
+	if db.readOnly {
+		return fmt.Errorf("%w [%s]: %s", ErrReadOnly, db, stmt)
 	}
-
 	// Fail if transaction present and not in write mode
 	if db.sqlTx != nil && !db.txWriteMode {
-		return 0, 0, fmt.Errorf("[%s] Trying to write into read-only transaction: %s", db, execSql)
+		return fmt.Errorf("[%s] Trying to write into read-only transaction: %s", db, stmt)
+	}
+	return nil
+}
+
+// execResult runs execSql with placeholder rewriting, debug logging and
+// the write checks and returns the driver result and the rewritten SQL
+func (db *DB) execResult(ctx context.Context, execSql string, args ...interface{}) (result sql.Result, execSql0 string, err error) {
+	var newArgs []interface{}
+
+	if db.debug(execDebugLevel(execSql)) {
+		db.logf("%s SQL: %s\nARGS:\n%s", db, golib.CutStr(execSql, 2000, "..."), argsToString(args...))
+	}
+
+	err = db.checkWrite(execSql)
+	if err != nil {
+		return nil, execSql0, err
 	}
 
 	if len(args) > 0 {
 		execSql0, newArgs, err = db.replaceArgs(execSql, args...)
 		if err != nil {
-			return 0, 0, err
+			return nil, execSql0, err
 		}
 	} else {
 		execSql0 = execSql
 		newArgs = args
 	}
+	execSql0 = db.rewrite(execSql0)
+
+	err = db.checkExplainGuard(ctx, execSql0, newArgs)
+	if err != nil {
+		return nil, execSql0, db.debugError(err)
+	}
 
 	// logrus.Infof("[%p] EXEC #%d %s %s", db.sqlDB, db.transID, aurora.Green(fmt.Sprintf("%p", db.db)), execSql0[0:10])
 
-	var result sql.Result
+	ctx, cancel := db.queryTimeout(ctx)
+	defer cancel()
 
 	// tries := 0
 	for {
-		result, err = db.db.ExecContext(ctx, execSql0, newArgs...)
+		result, err = db.execContextStmt(ctx, execSql0, newArgs...)
 		if err != nil {
 			// pp.Println(err)
 			// sqlErr, ok := err.(sqlite3.Error)
@@ -708,16 +1032,26 @@ func (db *DB) execContext(ctx context.Context, execSql string, args ...interface
 			// 		}
 			// 	}
 			// }
-			return 0, 0, db.debugError(db.sqlError(err, execSql0, newArgs))
+			return nil, execSql0, db.debugError(db.sqlError(err, execSql0, newArgs))
 		}
 		break
 	}
 
+	return result, execSql0, nil
+}
+
+func (db *DB) execContext(ctx context.Context, execSql string, args ...interface{}) (rowsAffected, insertID int64, err error) {
+	result, execSql0, err := db.execResult(ctx, execSql, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	row_count, err := result.RowsAffected()
 	if err != nil {
 		// Ignore the error here, we might get
 		// no RowsAffected available after the empty statement from pq driver
 		// which is ok and not a real error (it happens with empty statements)
+		db.warn(WarningRowsAffected, execSql0, "%s", err)
 	}
 
 	if !db.SupportsLastInsertId {
@@ -730,3 +1064,14 @@ func (db *DB) execContext(ctx context.Context, execSql string, args ...interface
 	}
 	return row_count, last_insert_id, nil
 }
+
+// upsertClause returns the clause appended to an INSERT, which updates the
+// columns update of the existing row if the row conflicts on the unique
+// columns conflict. It returns an error if the dialect has no such clause.
+func (db *DB) upsertClause(conflict, update []string) (string, error) {
+	clause := db.dialect().Upsert(conflict, update)
+	if clause == "" {
+		return "", fmt.Errorf("Upsert: Not supported by driver %q.", db.Driver)
+	}
+	return clause, nil
+}
@@ -0,0 +1,47 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExpectNone is Stmt.ExpectRows for statements expected to affect no rows
+const ExpectNone int64 = -1
+
+// Stmt is a statement run by ExecMulti
+type Stmt struct {
+	SQL        string
+	Args       []interface{}
+	ExpectRows int64 // expected number of affected rows, 0 skips the check, see ExpectNone
+}
+
+// ExecMulti runs stmts in order and stops at the first error or
+// mismatched number of affected rows, which returns an error wrapping
+// ErrMismatchedRowsAffected. Outside of a transaction the statements run
+// in a new transaction using ExecTX, so either all or none are applied.
+// Inside a transaction, the caller needs to roll back on error.
+func (db *DB) ExecMulti(ctx context.Context, stmts []Stmt) error {
+	if db.sqlTx == nil {
+		return db.ExecTX(ctx, func(tx *DB) error {
+			return tx.ExecMulti(ctx, stmts)
+		})
+	}
+
+	for idx, stmt := range stmts {
+		rowsAffected, _, err := db.execContext(ctx, stmt.SQL, stmt.Args...)
+		if err != nil {
+			return fmt.Errorf("ExecMulti: Statement #%d: %w", idx, err)
+		}
+		expect := stmt.ExpectRows
+		if expect == ExpectNone {
+			expect = 0
+		} else if expect == 0 {
+			continue
+		}
+		if rowsAffected != expect {
+			return fmt.Errorf("%w ExecMulti: Statement #%d affected %d rows, expected %d: %s",
+				ErrMismatchedRowsAffected, idx, rowsAffected, expect, stmt.SQL)
+		}
+	}
+	return nil
+}
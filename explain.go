@@ -0,0 +1,104 @@
+package sqlpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+var ErrExplainCostExceeded error = errors.New("Estimated query cost exceeded.")
+
+// ExplainGuard is a staging guard rail against expensive statements (e.g.
+// missing indexes). Before a matching statement is executed, EXPLAIN is run
+// for it and the estimated total cost is compared to MaxCost.
+//
+// The guard needs cost estimates and is therefore only supported on Postgres.
+// Only SELECT, INSERT, UPDATE, DELETE, WITH and VALUES statements are
+// checked, as Postgres can't EXPLAIN others like DDL or SET.
+type ExplainGuard struct {
+	Match   *regexp.Regexp // only check statements matching, <nil> checks all explainable statements
+	MaxCost float64
+	Reject  bool // return ErrExplainCostExceeded instead of logging the statement
+}
+
+// checkExplainGuard runs EXPLAIN for the statement if the guard is set
+func (db *DB) checkExplainGuard(ctx context.Context, sqlS string, args []interface{}) error {
+	if db.ExplainGuard == nil || db.Driver != POSTGRES {
+		return nil
+	}
+	if !explainable(sqlS) {
+		return nil
+	}
+	if db.ExplainGuard.Match != nil && !db.ExplainGuard.Match.MatchString(sqlS) {
+		return nil
+	}
+
+	var plan []byte
+	rows, err := db.db.QueryContext(ctx, "EXPLAIN (FORMAT JSON) "+sqlS, args...)
+	if err != nil {
+		return db.sqlError(err, "EXPLAIN (FORMAT JSON) "+sqlS, args)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&plan)
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	cost, err := explainTotalCost(plan)
+	if err != nil {
+		return err
+	}
+	if cost <= db.ExplainGuard.MaxCost {
+		return nil
+	}
+
+	if db.ExplainGuard.Reject {
+		return errors.Wrapf(ErrExplainCostExceeded, "Cost %.2f > %.2f: %s", cost, db.ExplainGuard.MaxCost, db.sqlDebug(sqlS, args))
+	}
+	db.logf("sqlpro explain guard: Cost %.2f > %.2f: %s", cost, db.ExplainGuard.MaxCost, db.sqlDebug(sqlS, args))
+	return nil
+}
+
+// explainable returns true if Postgres can EXPLAIN the statement
+func explainable(sqlS string) bool {
+	fields := strings.FieldsFunc(sqlS, func(r rune) bool {
+		return r == '(' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "VALUES":
+		return true
+	}
+	return false
+}
+
+// explainTotalCost returns the "Total Cost" of the top plan node
+// of a Postgres EXPLAIN (FORMAT JSON) output
+func explainTotalCost(plan []byte) (float64, error) {
+	var explain []struct {
+		Plan struct {
+			TotalCost float64 `json:"Total Cost"`
+		} `json:"Plan"`
+	}
+	err := json.Unmarshal(plan, &explain)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to parse EXPLAIN output")
+	}
+	if len(explain) == 0 {
+		return 0, fmt.Errorf("Unable to parse EXPLAIN output: %q", string(plan))
+	}
+	return explain[0].Plan.TotalCost, nil
+}
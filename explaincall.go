@@ -0,0 +1,162 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+)
+
+// Statement is a statement recorded by ExplainCall
+type Statement struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+// ExplainCall runs fn with a handle which records the statements sent to
+// the database instead of running them, and returns the statements. The
+// handle is set up like a Postgres handle returned by Open. Every query
+// returns no rows and every exec reports one affected row, so calls
+// depending on results may fail after recording their statements.
+// Transactions are recorded as "BEGIN", "COMMIT" and "ROLLBACK".
+func ExplainCall(fn func(db *DB)) []Statement {
+	rec := &recorder{}
+	conn := sql.OpenDB(rec)
+	defer conn.Close()
+
+	db := New(conn)
+	db.sqlDB = conn
+	db.Driver = POSTGRES
+	db.PlaceholderMode = DOLLAR
+	db.UseReturningForLastId = true
+	db.SupportsLastInsertId = false
+
+	fn(db)
+
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	return rec.statements
+}
+
+// recorder is a driver.Connector recording all statements
+type recorder struct {
+	mtx        sync.Mutex
+	statements []Statement
+}
+
+func (rec *recorder) Connect(context.Context) (driver.Conn, error) {
+	return &recorderConn{rec: rec}, nil
+}
+
+func (rec *recorder) Driver() driver.Driver {
+	return recorderDriver{rec: rec}
+}
+
+func (rec *recorder) record(query string, args []driver.NamedValue) {
+	var values []interface{}
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	rec.mtx.Lock()
+	rec.statements = append(rec.statements, Statement{SQL: query, Args: values})
+	rec.mtx.Unlock()
+}
+
+type recorderDriver struct {
+	rec *recorder
+}
+
+func (rd recorderDriver) Open(string) (driver.Conn, error) {
+	return &recorderConn{rec: rd.rec}, nil
+}
+
+type recorderConn struct {
+	rec *recorder
+}
+
+func (rc *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{rec: rc.rec, query: query}, nil
+}
+
+func (rc *recorderConn) Close() error {
+	return nil
+}
+
+func (rc *recorderConn) Begin() (driver.Tx, error) {
+	return rc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (rc *recorderConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	rc.rec.record("BEGIN", nil)
+	return recorderTx{rec: rc.rec}, nil
+}
+
+func (rc *recorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rc.rec.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (rc *recorderConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rc.rec.record(query, args)
+	return recorderRows{}, nil
+}
+
+type recorderStmt struct {
+	rec   *recorder
+	query string
+}
+
+func (rs *recorderStmt) Close() error {
+	return nil
+}
+
+func (rs *recorderStmt) NumInput() int {
+	return -1
+}
+
+func (rs *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rs.rec.record(rs.query, namedValues(args))
+	return driver.RowsAffected(1), nil
+}
+
+func (rs *recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rs.rec.record(rs.query, namedValues(args))
+	return recorderRows{}, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+	for idx, arg := range args {
+		named = append(named, driver.NamedValue{Ordinal: idx + 1, Value: arg})
+	}
+	return named
+}
+
+type recorderTx struct {
+	rec *recorder
+}
+
+func (rt recorderTx) Commit() error {
+	rt.rec.record("COMMIT", nil)
+	return nil
+}
+
+func (rt recorderTx) Rollback() error {
+	rt.rec.record("ROLLBACK", nil)
+	return nil
+}
+
+type recorderRows struct{}
+
+func (recorderRows) Columns() []string {
+	return []string{}
+}
+
+func (recorderRows) Close() error {
+	return nil
+}
+
+func (recorderRows) Next([]driver.Value) error {
+	return io.EOF
+}
@@ -0,0 +1,86 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// WithFallback returns a copy of db which retries read queries on other,
+// if they fail with a connection error (see IsConnectionError). Use this
+// to fall back to a replica or a local cache if the primary is down.
+// FallbackHook is called for every failover.
+func (db *DB) WithFallback(other *DB) *DB {
+	newDB := *db
+	newDB.fallback = other
+	return &newDB
+}
+
+// IsConnectionError returns true if err indicates that the database could
+// not be reached, as opposed to errors in the query itself. Exceeded
+// deadlines and canceled contexts are no connection errors.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// context.DeadlineExceeded implements net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if code, ok := sqlState(err); ok {
+		switch {
+		case strings.HasPrefix(code, "08"): // connection exception
+			return true
+		case code == "57P01", code == "57P02", code == "57P03": // shutdown, cannot connect now
+			return true
+		}
+		return false
+	}
+
+	// database/sql does not export this error
+	return strings.Contains(err.Error(), "sql: database is closed")
+}
+
+// queryFallback runs the query on the fallback handle, if err is a
+// connection error and a fallback is set. Only read queries fall back,
+// writes like INSERT ... RETURNING may have been applied already. It
+// returns <nil> rows if the fallback does not apply.
+func (db *DB) queryFallback(ctx context.Context, err error, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.fallback == nil || db.sqlTx != nil || !replicaQuery(query) || !IsConnectionError(err) {
+		return nil, nil
+	}
+	if db.FallbackHook != nil {
+		db.FallbackHook(db.fallback, err)
+	}
+	return db.fallback.queryRows(ctx, query, args...)
+}
+
+// isRetryableRead returns true if query is a SELECT outside a transaction
+// which failed with a connection error. database/sql only retries before
+// the statement is sent, a SELECT can be repeated on a fresh connection.
+func (db *DB) isRetryableRead(ctx context.Context, query string, err error) bool {
+	if db.sqlTx != nil || ctx.Err() != nil || !IsConnectionError(err) {
+		return false
+	}
+	return isSelect(query)
+}
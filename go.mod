@@ -1,6 +1,6 @@
 module github.com/programmfabrik/sqlpro
 
-go 1.17
+go 1.23
 
 require (
 	github.com/lib/pq v1.10.9
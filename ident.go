@@ -0,0 +1,140 @@
+package sqlpro
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidIdentifier error = errors.New("Invalid identifier.")
+
+// maxIdentifierLength is the maximum length of identifiers in bytes, longer
+// identifiers are silently truncated by Postgres (NAMEDATALEN - 1)
+var maxIdentifierLength = map[dbDriver]int{
+	POSTGRES: 63,
+	MYSQL:    64,
+	MSSQL:    128,
+}
+
+// reservedWords contains the reserved key words per driver, which can only
+// be used as identifier when quoted
+var reservedWords = map[dbDriver]map[string]bool{
+	POSTGRES: wordSet(`ALL ANALYSE ANALYZE AND ANY ARRAY AS ASC ASYMMETRIC AUTHORIZATION BINARY BOTH
+		CASE CAST CHECK COLLATE COLLATION COLUMN CONCURRENTLY CONSTRAINT CREATE CROSS
+		CURRENT_CATALOG CURRENT_DATE CURRENT_ROLE CURRENT_SCHEMA CURRENT_TIME
+		CURRENT_TIMESTAMP CURRENT_USER DEFAULT DEFERRABLE DESC DISTINCT DO ELSE END
+		EXCEPT FALSE FETCH FOR FOREIGN FREEZE FROM FULL GRANT GROUP HAVING ILIKE IN
+		INITIALLY INNER INTERSECT INTO IS ISNULL JOIN LATERAL LEADING LEFT LIKE LIMIT
+		LOCALTIME LOCALTIMESTAMP NATURAL NOT NOTNULL NULL OFFSET ON ONLY OR ORDER OUTER
+		OVERLAPS PLACING PRIMARY REFERENCES RETURNING RIGHT SELECT SESSION_USER SIMILAR
+		SOME SYMMETRIC SYSTEM_USER TABLE TABLESAMPLE THEN TO TRAILING TRUE UNION UNIQUE
+		USER USING VARIADIC VERBOSE WHEN WHERE WINDOW WITH`),
+	SQLITE3: wordSet(`ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE COLUMN
+		COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE CURRENT_TIME
+		CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED DELETE DESC DETACH
+		DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE EXCLUSIVE EXISTS EXPLAIN
+		FAIL FILTER FIRST FOLLOWING FOR FOREIGN FROM FULL GENERATED GLOB GROUP GROUPS
+		HAVING IF IGNORE IMMEDIATE IN INDEX INDEXED INITIALLY INNER INSERT INSTEAD
+		INTERSECT INTO IS ISNULL JOIN KEY LAST LEFT LIKE LIMIT MATCH MATERIALIZED
+		NATURAL NO NOT NOTHING NOTNULL NULL NULLS OF OFFSET ON OR ORDER OTHERS OUTER
+		OVER PARTITION PLAN PRAGMA PRECEDING PRIMARY QUERY RAISE RANGE RECURSIVE
+		REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE RESTRICT RETURNING RIGHT
+		ROLLBACK ROW ROWS SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES TO
+		TRANSACTION TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW
+		VIRTUAL WHEN WHERE WINDOW WITH WITHOUT`),
+	MYSQL: wordSet(`ACCESSIBLE ADD ALL ALTER ANALYZE AND AS ASC ASENSITIVE BEFORE BETWEEN BIGINT
+		BINARY BLOB BOTH BY CALL CASCADE CASE CHANGE CHAR CHARACTER CHECK COLLATE COLUMN
+		CONDITION CONSTRAINT CONTINUE CONVERT CREATE CROSS CUBE CUME_DIST CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER CURSOR DATABASE DATABASES DAY_HOUR
+		DAY_MICROSECOND DAY_MINUTE DAY_SECOND DEC DECIMAL DECLARE DEFAULT DELAYED DELETE
+		DENSE_RANK DESC DESCRIBE DETERMINISTIC DISTINCT DISTINCTROW DIV DOUBLE DROP DUAL
+		EACH ELSE ELSEIF EMPTY ENCLOSED ESCAPED EXCEPT EXISTS EXIT EXPLAIN FALSE FETCH
+		FIRST_VALUE FLOAT FLOAT4 FLOAT8 FOR FORCE FOREIGN FROM FULLTEXT FUNCTION
+		GENERATED GET GRANT GROUP GROUPING GROUPS HAVING HIGH_PRIORITY HOUR_MICROSECOND
+		HOUR_MINUTE HOUR_SECOND IF IGNORE IN INDEX INFILE INNER INOUT INSENSITIVE INSERT
+		INT INT1 INT2 INT3 INT4 INT8 INTEGER INTERSECT INTERVAL INTO IO_AFTER_GTIDS
+		IO_BEFORE_GTIDS IS ITERATE JOIN JSON_TABLE KEY KEYS KILL LAG LAST_VALUE LATERAL
+		LEAD LEADING LEAVE LEFT LIKE LIMIT LINEAR LINES LOAD LOCALTIME LOCALTIMESTAMP
+		LOCK LONG LONGBLOB LONGTEXT LOOP LOW_PRIORITY MASTER_BIND
+		MASTER_SSL_VERIFY_SERVER_CERT MATCH MAXVALUE MEDIUMBLOB MEDIUMINT MEDIUMTEXT
+		MIDDLEINT MINUTE_MICROSECOND MINUTE_SECOND MOD MODIFIES NATURAL NOT
+		NO_WRITE_TO_BINLOG NTH_VALUE NTILE NULL NUMERIC OF ON OPTIMIZE OPTIMIZER_COSTS
+		OPTION OPTIONALLY OR ORDER OUT OUTER OUTFILE OVER PARTITION PERCENT_RANK
+		PRECISION PRIMARY PROCEDURE PURGE RANGE RANK READ READS READ_WRITE REAL
+		RECURSIVE REFERENCES REGEXP RELEASE RENAME REPEAT REPLACE REQUIRE RESIGNAL
+		RESTRICT RETURN REVOKE RIGHT RLIKE ROW ROWS ROW_NUMBER SCHEMA SCHEMAS
+		SECOND_MICROSECOND SELECT SENSITIVE SEPARATOR SET SHOW SIGNAL SMALLINT SPATIAL
+		SPECIFIC SQL SQLEXCEPTION SQLSTATE SQLWARNING SQL_BIG_RESULT
+		SQL_CALC_FOUND_ROWS SQL_SMALL_RESULT SSL STARTING STORED STRAIGHT_JOIN SYSTEM
+		TABLE TERMINATED THEN TINYBLOB TINYINT TINYTEXT TO TRAILING TRIGGER TRUE UNDO
+		UNION UNIQUE UNLOCK UNSIGNED UPDATE USAGE USE USING UTC_DATE UTC_TIME
+		UTC_TIMESTAMP VALUES VARBINARY VARCHAR VARCHARACTER VARYING VIRTUAL WHEN WHERE
+		WHILE WINDOW WITH WRITE XOR YEAR_MONTH ZEROFILL`),
+}
+
+// Raw is trusted SQL. Passed as arg, it replaces its placeholder as is,
+// e.g. for dynamic ORDER BY expressions:
+//
+//	db.Query(&rows, "SELECT * FROM x ORDER BY ?", sqlpro.Raw("lower(name)"))
+//
+// Never build Raw from user input. Plain strings are always bound.
+type Raw string
+
+// Ident is an identifier. Passed as arg, it is escaped like the arg for
+// an "@" placeholder, also when used with a "?" placeholder.
+type Ident string
+
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// CheckIdentifier returns an error wrapping ErrInvalidIdentifier if name
+// is empty, contains a NUL byte, exceeds the identifier length of the
+// driver or is a reserved word of the driver.
+func (db *DB) CheckIdentifier(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w Empty identifier.", ErrInvalidIdentifier)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("%w %q contains NUL.", ErrInvalidIdentifier, name)
+	}
+	if maxLen := maxIdentifierLength[db.Driver]; maxLen > 0 && len(name) > maxLen {
+		return fmt.Errorf("%w %q exceeds %d bytes and would be truncated by %s.", ErrInvalidIdentifier, name, maxLen, db.Driver)
+	}
+	if reservedWords[db.Driver][strings.ToUpper(name)] {
+		return fmt.Errorf("%w %q is a reserved word in %s.", ErrInvalidIdentifier, name, db.Driver)
+	}
+	return nil
+}
+
+// checkIdentifiers checks the given table and column names if
+// StrictIdentifiers is set
+func (db *DB) checkIdentifiers(table string, cols []string) error {
+	if !db.StrictIdentifiers {
+		return nil
+	}
+	for _, name := range append([]string{table}, cols...) {
+		err := db.CheckIdentifier(name)
+		if err != nil {
+			return db.debugError(err)
+		}
+	}
+	return nil
+}
+
+// warnIdentifier reports a warning if StrictIdentifiers is set and
+// name is no valid identifier
+func (db *DB) warnIdentifier(name string) {
+	if !db.StrictIdentifiers {
+		return
+	}
+	err := db.CheckIdentifier(name)
+	if err != nil {
+		db.warn(WarningIdentifier, "", "%s", err)
+	}
+}
@@ -0,0 +1,62 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// sqliteReadOnlyDSN reports if dsn opens the SQLite database read-only
+// using the URI parameters "mode=ro" or "immutable=1". SQLite only applies
+// URI parameters to "file:" URIs, so dsn is prefixed if needed.
+func sqliteReadOnlyDSN(dsn string) (string, bool) {
+	_, query, found := strings.Cut(dsn, "?")
+	if !found {
+		return dsn, false
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return dsn, false
+	}
+	if params.Get("mode") != "ro" && params.Get("immutable") != "1" {
+		return dsn, false
+	}
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	return dsn, true
+}
+
+// IntegrityCheck runs PRAGMA integrity_check, or the faster quick_check
+// if quick is set, on the SQLite database. It returns the problems found,
+// which is empty if the database is ok.
+func (db *DB) IntegrityCheck(ctx context.Context, quick bool) ([]string, error) {
+	if db.Driver != SQLITE3 {
+		return nil, fmt.Errorf("IntegrityCheck: Unsupported driver %q.", db.Driver)
+	}
+
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	var results []string
+	err := db.QueryContext(ctx, &results, pragma)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	for _, res := range results {
+		// a single "ok" row is returned for an intact database, a result
+		// can contain several problems separated by newline
+		for _, line := range strings.Split(res, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line == "ok" || strings.HasPrefix(line, "*** in database ") {
+				continue
+			}
+			problems = append(problems, line)
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,133 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+)
+
+// CheckpointJob describes a batch job processing all rows of a table in
+// primary key order. After each batch the last processed primary key is
+// stored in the checkpoint table, so an interrupted job resumes after the
+// last completed batch when it is run again.
+type CheckpointJob struct {
+	Name            string // unique name of the job, used as checkpoint key
+	Table           string
+	PrimaryKey      string // integer primary key column, defaults to "id"
+	BatchSize       int    // defaults to 1000
+	CheckpointTable string // defaults to "sqlpro_checkpoint", created if missing
+
+	// Process is called with the primary keys of each batch. It runs in the
+	// same transaction which stores the checkpoint, so the writes of Process
+	// and the checkpoint are committed together.
+	Process func(tx *DB, pks []int64) error
+}
+
+func (job *CheckpointJob) checkpointTable() string {
+	if job.CheckpointTable == "" {
+		return "sqlpro_checkpoint"
+	}
+	return job.CheckpointTable
+}
+
+// RunCheckpointJob runs the job starting after the stored checkpoint and
+// returns the number of rows processed. It needs to run on a non-transaction
+// handle, each batch uses its own transaction.
+func (db *DB) RunCheckpointJob(ctx context.Context, job CheckpointJob) (int64, error) {
+	var (
+		processed int64
+		lastPK    int64
+	)
+
+	if db.sqlTx != nil {
+		panic("sqlpro.DB.RunCheckpointJob: Unable to run a job on a Transaction.")
+	}
+	if job.Name == "" || job.Table == "" || job.Process == nil {
+		return 0, fmt.Errorf("RunCheckpointJob: Name, Table and Process are required.")
+	}
+	if job.PrimaryKey == "" {
+		job.PrimaryKey = "id"
+	}
+	if job.BatchSize <= 0 {
+		job.BatchSize = 1000
+	}
+
+	upsert, err := db.upsertClause([]string{"name"}, []string{"last_pk"})
+	if err != nil {
+		return 0, fmt.Errorf("RunCheckpointJob: %w", err)
+	}
+
+	nameType := "TEXT"
+	if db.Driver == MYSQL {
+		// MySQL needs a length for keys
+		nameType = "VARCHAR(255)"
+	}
+	err = db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS @ (name "+nameType+" PRIMARY KEY, last_pk BIGINT NOT NULL)", job.checkpointTable())
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.QueryContext(ctx, &lastPK, "SELECT last_pk FROM @ WHERE name = ?", job.checkpointTable(), job.Name)
+	if err != nil && err != ErrQueryReturnedZeroRows {
+		return 0, err
+	}
+
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return processed, err
+		}
+
+		pks := []int64{}
+		err = db.QueryContext(ctx, &pks, db.Paginate("SELECT @ FROM @ WHERE @ > ? ORDER BY @", Limit(int64(job.BatchSize))),
+			job.PrimaryKey, job.Table, job.PrimaryKey, lastPK, job.PrimaryKey)
+		if err != nil {
+			return processed, err
+		}
+		if len(pks) == 0 {
+			return processed, nil
+		}
+
+		err = db.runCheckpointBatch(ctx, job, upsert, pks)
+		if err != nil {
+			return processed, err
+		}
+
+		processed += int64(len(pks))
+		lastPK = pks[len(pks)-1]
+
+		if len(pks) < job.BatchSize {
+			return processed, nil
+		}
+	}
+}
+
+func (db *DB) runCheckpointBatch(ctx context.Context, job CheckpointJob, upsert string, pks []int64) error {
+	tx, err := db.BeginContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx.ActiveTX() {
+			tx.Rollback()
+		}
+	}()
+
+	err = job.Process(tx, pks)
+	if err != nil {
+		return fmt.Errorf("RunCheckpointJob %q: %w", job.Name, err)
+	}
+
+	err = tx.ExecContext(ctx, "INSERT INTO @ (name, last_pk) VALUES (?, ?) "+upsert,
+		job.checkpointTable(), job.Name, pks[len(pks)-1])
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetCheckpoint removes the checkpoint of the job, so that
+// the next run starts from the beginning
+func (db *DB) ResetCheckpoint(ctx context.Context, job CheckpointJob) error {
+	return db.ExecContext(ctx, "DELETE FROM @ WHERE name = ?", job.checkpointTable(), job.Name)
+}
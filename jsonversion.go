@@ -0,0 +1,94 @@
+package sqlpro
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// jsonEnvelope is the stored format of "json" fields of versioned types:
+//
+//	{"v": 2, "data": {...}}
+type jsonEnvelope struct {
+	V    int             `json:"v"`
+	Data json.RawMessage `json:"data"`
+}
+
+// JSONUpgrade upgrades the json data of a version to the next version
+type JSONUpgrade func(data json.RawMessage) (json.RawMessage, error)
+
+var (
+	jsonVersionsMtx sync.RWMutex
+	jsonVersions    = map[reflect.Type][]JSONUpgrade{}
+)
+
+// RegisterJSONVersion registers versioning for "json" fields of type t.
+// upgrades[n] upgrades the data of version n to version n+1, so the
+// current version is len(upgrades). Fields of type t (or *t) are written
+// as envelope {"v": <current version>, "data": <json of the value>}. On
+// scan, older versions are upgraded before unmarshalling, data without
+// envelope is version 0. Register versions during init, before using the
+// types in queries.
+func RegisterJSONVersion(t reflect.Type, upgrades ...JSONUpgrade) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	jsonVersionsMtx.Lock()
+	defer jsonVersionsMtx.Unlock()
+	jsonVersions[t] = upgrades
+}
+
+// getJSONUpgrades returns the upgrades registered for t and true if t is
+// versioned
+func getJSONUpgrades(t reflect.Type) ([]JSONUpgrade, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	jsonVersionsMtx.RLock()
+	defer jsonVersionsMtx.RUnlock()
+	upgrades, ok := jsonVersions[t]
+	return upgrades, ok
+}
+
+// wrapJSONVersion wraps data into the envelope if t is versioned
+func wrapJSONVersion(t reflect.Type, data []byte) ([]byte, error) {
+	upgrades, ok := getJSONUpgrades(t)
+	if !ok {
+		return data, nil
+	}
+	return json.Marshal(jsonEnvelope{V: len(upgrades), Data: data})
+}
+
+// upgradeJSONVersion unwraps the envelope of data if t is versioned and
+// upgrades the data to the current version
+func upgradeJSONVersion(t reflect.Type, data []byte) ([]byte, error) {
+	upgrades, ok := getJSONUpgrades(t)
+	if !ok {
+		return data, nil
+	}
+
+	version := 0
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) == nil && len(obj) == 2 && obj["v"] != nil && obj["data"] != nil {
+		var env jsonEnvelope
+		err := json.Unmarshal(data, &env)
+		if err != nil {
+			return nil, err
+		}
+		version = env.V
+		data = env.Data
+	}
+
+	if version < 0 || version > len(upgrades) {
+		return nil, fmt.Errorf("sqlpro: Unable to read version %d of %s, current version is %d.", version, t, len(upgrades))
+	}
+	for ; version < len(upgrades); version++ {
+		upgraded, err := upgrades[version](data)
+		if err != nil {
+			return nil, fmt.Errorf("sqlpro: Unable to upgrade %s from version %d: %w", t, version, err)
+		}
+		data = upgraded
+	}
+	return data, nil
+}
@@ -0,0 +1,78 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrStatementSkipped error = errors.New("Statement skipped by middleware.")
+
+// Middleware is called around every statement sent to the database. info
+// holds the SQL and args of the statement and its start time, next runs
+// it. A middleware can change info.SQL and info.Args before calling next,
+// after next returned, info.Stop and info.Err are set. The error returned
+// is passed on to the caller, a middleware can skip the statement by not
+// calling next. If it returns <nil> in that case, the caller receives
+// ErrStatementSkipped.
+type Middleware func(ctx context.Context, info *QueryInfo, next func() error) error
+
+// Use adds mw to the middlewares of the handle. Middlewares are called in
+// the order they are added, the first one is the outermost. Copies of the
+// handle made before the call are not affected.
+func (db *DB) Use(mw Middleware) {
+	// don't share the backing array with earlier copies
+	db.middlewares = append(db.middlewares[:len(db.middlewares):len(db.middlewares)], mw)
+}
+
+// runMiddlewares calls the middlewares around run
+func (db *DB) runMiddlewares(ctx context.Context, query string, args []interface{}, run func(query string, args []interface{}) error) error {
+	if len(db.middlewares) == 0 {
+		return run(query, args)
+	}
+	info := &QueryInfo{SQL: query, Args: args, Start: db.now()}
+	ran := false
+	var call func(idx int) error
+	call = func(idx int) error {
+		if idx == len(db.middlewares) {
+			ran = true
+			info.Err = run(info.SQL, info.Args)
+			info.Stop = db.now()
+			return info.Err
+		}
+		return db.middlewares[idx](ctx, info, func() error {
+			return call(idx + 1)
+		})
+	}
+	err := call(0)
+	if err == nil && !ran {
+		return ErrStatementSkipped
+	}
+	return err
+}
+
+// queryContext runs the query through the middlewares
+func (db *DB) queryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := db.runMiddlewares(ctx, query, args, func(query string, args []interface{}) (err error) {
+		rows, err = db.queryStmt(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			closeRows(rows)
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+// execContextStmt runs the statement through the middlewares
+func (db *DB) execContextStmt(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := db.runMiddlewares(ctx, query, args, func(query string, args []interface{}) (err error) {
+		res, err = db.execStmt(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
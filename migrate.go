@@ -0,0 +1,248 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// CopySwapSpec describes an online migration of a table: a shadow table is
+// created, all rows are copied over in batches, optionally transformed, and
+// finally the shadow table replaces the original table. The original table is
+// kept as "<table>_old".
+//
+// Writes happening during the copy are picked up using the UpdatedAt column,
+// rows with an UpdatedAt not older than the last copy round are copied again.
+// Rows deleted during the copy are not detected. Without UpdatedAt, writes
+// during the copy are lost, so the table must not be written to.
+type CopySwapSpec struct {
+	Table string
+
+	// CreateShadow is the CREATE TABLE statement for the shadow table, its
+	// name is passed as argument for the "@" placeholder.
+	CreateShadow string
+
+	Columns       []string // columns to copy, must include the PrimaryKey
+	ShadowColumns []string // columns written into the shadow table, defaults to Columns
+	PrimaryKey    string   // primary key used for paging, defaults to "id"
+	UpdatedAt     string   // optional column holding the last modification
+	BatchSize     int      // defaults to 1000
+
+	// Transform maps a row of Columns to a row of ShadowColumns, it can be <nil>
+	Transform func(row []interface{}) ([]interface{}, error)
+
+	// Progress is called after each copied batch, it can be <nil>
+	Progress func(copied int64)
+}
+
+// copySwapMaxRounds limits the number of catch up rounds before
+// the final catch up runs in the swap transaction
+const copySwapMaxRounds = 10
+
+// CopySwap runs the online migration described by spec. It needs
+// to run on a non-transaction handle.
+func (db *DB) CopySwap(ctx context.Context, spec CopySwapSpec) error {
+	var (
+		copied int64
+		mark   interface{}
+		lastPK interface{}
+	)
+
+	if db.sqlTx != nil {
+		panic("sqlpro.DB.CopySwap: Unable to call CopySwap on a Transaction.")
+	}
+	if spec.Table == "" || spec.CreateShadow == "" || len(spec.Columns) == 0 {
+		return fmt.Errorf("CopySwap: Table, CreateShadow and Columns are required.")
+	}
+	if spec.PrimaryKey == "" {
+		spec.PrimaryKey = "id"
+	}
+	if spec.BatchSize <= 0 {
+		spec.BatchSize = 1000
+	}
+	if len(spec.ShadowColumns) == 0 {
+		spec.ShadowColumns = spec.Columns
+	}
+
+	pkIdx := -1
+	for idx, col := range spec.Columns {
+		if col == spec.PrimaryKey {
+			pkIdx = idx
+		}
+	}
+	if pkIdx == -1 {
+		return fmt.Errorf("CopySwap: Columns need to include the primary key %q.", spec.PrimaryKey)
+	}
+
+	shadow := spec.Table + "_shadow"
+
+	err := db.ExecContext(ctx, spec.CreateShadow, shadow)
+	if err != nil {
+		return err
+	}
+
+	if spec.UpdatedAt != "" {
+		err = db.QueryContext(ctx, &mark, "SELECT max(@) FROM @", spec.UpdatedAt, spec.Table)
+		if err != nil {
+			return err
+		}
+	}
+
+	selectCols := db.escJoin(spec.Columns)
+	batch := Limit(int64(spec.BatchSize))
+	for {
+		rows := [][]interface{}{}
+		if lastPK == nil {
+			err = db.QueryContext(ctx, &rows, db.Paginate("SELECT "+selectCols+" FROM @ ORDER BY @", batch),
+				spec.Table, spec.PrimaryKey)
+		} else {
+			err = db.QueryContext(ctx, &rows, db.Paginate("SELECT "+selectCols+" FROM @ WHERE @ > ? ORDER BY @", batch),
+				spec.Table, spec.PrimaryKey, lastPK, spec.PrimaryKey)
+		}
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		err = db.copySwapRows(ctx, db, spec, shadow, rows)
+		if err != nil {
+			return err
+		}
+
+		copied += int64(len(rows))
+		lastPK = rows[len(rows)-1][pkIdx]
+
+		if spec.Progress != nil {
+			spec.Progress(copied)
+		}
+		if len(rows) < spec.BatchSize {
+			break
+		}
+	}
+
+	if spec.UpdatedAt != "" {
+		for i := 0; i < copySwapMaxRounds; i++ {
+			var n int
+			mark, n, err = db.copySwapCatchUp(ctx, db, spec, shadow, pkIdx, mark)
+			if err != nil {
+				return err
+			}
+			if n < spec.BatchSize {
+				break
+			}
+		}
+	}
+
+	tx, err := db.BeginContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx.ActiveTX() {
+			tx.Rollback()
+		}
+	}()
+
+	if spec.UpdatedAt != "" {
+		if db.Driver == POSTGRES {
+			err = tx.ExecContext(ctx, "LOCK TABLE @ IN EXCLUSIVE MODE", spec.Table)
+			if err != nil {
+				return err
+			}
+		}
+		_, _, err = tx.copySwapCatchUp(ctx, tx, spec, shadow, pkIdx, mark)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.ExecContext(ctx, "ALTER TABLE @ RENAME TO @", spec.Table, spec.Table+"_old")
+	if err != nil {
+		return err
+	}
+	err = tx.ExecContext(ctx, "ALTER TABLE @ RENAME TO @", shadow, spec.Table)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// copySwapCatchUp copies the rows modified since mark into the shadow table,
+// it returns the new mark and the number of rows copied.
+func (db *DB) copySwapCatchUp(ctx context.Context, tx *DB, spec CopySwapSpec, shadow string, pkIdx int, mark interface{}) (interface{}, int, error) {
+	var (
+		newMark interface{}
+		err     error
+	)
+
+	err = tx.QueryContext(ctx, &newMark, "SELECT max(@) FROM @", spec.UpdatedAt, spec.Table)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows := [][]interface{}{}
+	if mark == nil {
+		err = tx.QueryContext(ctx, &rows, "SELECT "+db.escJoin(spec.Columns)+" FROM @ WHERE @ IS NOT NULL",
+			spec.Table, spec.UpdatedAt)
+	} else {
+		err = tx.QueryContext(ctx, &rows, "SELECT "+db.escJoin(spec.Columns)+" FROM @ WHERE @ >= ?",
+			spec.Table, spec.UpdatedAt, mark)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rows) == 0 {
+		return newMark, 0, nil
+	}
+
+	pks := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		pks = append(pks, row[pkIdx])
+	}
+	err = tx.ExecContext(ctx, "DELETE FROM @ WHERE @ IN ?", shadow, spec.PrimaryKey, pks)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = db.copySwapRows(ctx, tx, spec, shadow, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newMark, len(rows), nil
+}
+
+// copySwapRows transforms the rows and writes them into the shadow table
+func (db *DB) copySwapRows(ctx context.Context, tx *DB, spec CopySwapSpec, shadow string, rows [][]interface{}) error {
+	var err error
+
+	if spec.Transform != nil {
+		for idx, row := range rows {
+			rows[idx], err = spec.Transform(row)
+			if err != nil {
+				return fmt.Errorf("CopySwap: Transform failed: %w", err)
+			}
+		}
+	}
+
+	idx := 0
+	_, err = tx.CopyFromContext(ctx, shadow, spec.ShadowColumns, func() ([]interface{}, bool) {
+		if idx >= len(rows) {
+			return nil, false
+		}
+		idx++
+		return rows[idx-1], true
+	})
+	return err
+}
+
+// escJoin escapes the identifiers and joins them with ","
+func (db *DB) escJoin(idents []string) string {
+	escaped := make([]string, 0, len(idents))
+	for _, ident := range idents {
+		escaped = append(escaped, db.Esc(ident))
+	}
+	return strings.Join(escaped, ",")
+}
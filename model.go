@@ -0,0 +1,31 @@
+package sqlpro
+
+import (
+	"reflect"
+)
+
+// ReadOnlyModel is implemented by structs which must not be written, e.g.
+// because they are backed by a view. Insert, Update, Save and the other
+// writing methods fail with ErrReadOnlyModel for them, scanning into them
+// fails for unmapped columns as with StrictScan.
+//
+//	type userStats struct { ... }
+//
+//	func (userStats) ReadOnlyModel() {}
+type ReadOnlyModel interface {
+	ReadOnlyModel()
+}
+
+var readOnlyModelType = reflect.TypeOf((*ReadOnlyModel)(nil)).Elem()
+
+// isReadOnlyModel returns true if the struct type t, which may be wrapped in
+// pointers and slices, implements ReadOnlyModel
+func isReadOnlyModel(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		if t.Implements(readOnlyModelType) {
+			return true
+		}
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && (t.Implements(readOnlyModelType) || reflect.PointerTo(t).Implements(readOnlyModelType))
+}
@@ -0,0 +1,106 @@
+package sqlpro
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Null represents a nullable column of type T, without the need to use a
+// pointer. It works like sql.Null[T], but additionally scans times stored
+// as text (SQLite) and marshals to JSON as the value or null.
+type Null[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NewNull returns a valid Null for v
+func NewNull[T any](v T) Null[T] {
+	return Null[T]{V: v, Valid: true}
+}
+
+// Scan implements the sql.Scanner interface
+func (n *Null[T]) Scan(value interface{}) error {
+	if value == nil {
+		var zero T
+		n.V, n.Valid = zero, false
+		return nil
+	}
+	if t, ok := any(&n.V).(*time.Time); ok {
+		nt := NullTime{}
+		err := nt.Scan(value)
+		if err != nil {
+			return err
+		}
+		*t, n.Valid = nt.Time, nt.Valid
+		return nil
+	}
+	sn := sql.Null[T]{}
+	err := sn.Scan(value)
+	if err != nil {
+		return err
+	}
+	n.V, n.Valid = sn.V, sn.Valid
+	return nil
+}
+
+// Value implements the driver.Valuer interface
+func (n Null[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(n.V)
+}
+
+// MarshalJSON renders the value or null
+func (n Null[T]) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.V)
+}
+
+// UnmarshalJSON sets the value, null sets Valid to false
+func (n *Null[T]) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		var zero T
+		n.V, n.Valid = zero, false
+		return nil
+	}
+	err := json.Unmarshal(data, &n.V)
+	if err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// isNullType returns true for the sql.Null* types and Null[T]
+func isNullType(t reflect.Type) bool {
+	if !strings.HasPrefix(t.Name(), "Null") {
+		return false
+	}
+	switch t.PkgPath() {
+	case "database/sql":
+		return true
+	case reflect.TypeOf(Null[int]{}).PkgPath():
+		return strings.HasPrefix(t.Name(), "Null[")
+	}
+	return false
+}
+
+// unwrapNull returns the value of the sql.Null* or Null[T] v, <nil> if it
+// is not valid. Other values are returned unchanged.
+func unwrapNull(v interface{}) (interface{}, error) {
+	if v == nil || !isNullType(reflect.TypeOf(v)) {
+		return v, nil
+	}
+	vr, ok := v.(driver.Valuer)
+	if !ok {
+		return v, nil
+	}
+	return vr.Value()
+}
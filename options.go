@@ -0,0 +1,80 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Option configures the handle returned by Open and OpenDB
+type Option func(db *DB)
+
+// WithMaxOpenConns sets the maximum number of open connections of the
+// pool, see sql.DB.SetMaxOpenConns
+func WithMaxOpenConns(n int) Option {
+	return func(db *DB) {
+		db.sqlDB.SetMaxOpenConns(n)
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections of the
+// pool, see sql.DB.SetMaxIdleConns
+func WithMaxIdleConns(n int) Option {
+	return func(db *DB) {
+		db.sqlDB.SetMaxIdleConns(n)
+		db.maxIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime sets the maximum time a connection is reused, see
+// sql.DB.SetConnMaxLifetime
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(db *DB) {
+		db.sqlDB.SetConnMaxLifetime(d)
+	}
+}
+
+// WithConnMaxIdleTime sets the maximum time a connection may be idle, see
+// sql.DB.SetConnMaxIdleTime
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(db *DB) {
+		db.sqlDB.SetConnMaxIdleTime(d)
+	}
+}
+
+// WithDefaultQueryTimeout sets QueryTimeout
+func WithDefaultQueryTimeout(d time.Duration) Option {
+	return func(db *DB) {
+		db.QueryTimeout = d
+	}
+}
+
+// WithLogger sets Logger
+func WithLogger(logger *log.Logger) Option {
+	return func(db *DB) {
+		db.Logger = logger
+	}
+}
+
+// logf logs using the logger set by SetLogger or Logger
+func (db *DB) logf(format string, args ...interface{}) {
+	if db.slogger != nil {
+		db.slogger.Info(fmt.Sprintf(format, args...))
+		return
+	}
+	if db.Logger != nil {
+		db.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// queryTimeout applies QueryTimeout to ctx if it has no deadline. The
+// returned cancel func needs to be called after the statement is done.
+func (db *DB) queryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || db.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
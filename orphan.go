@@ -0,0 +1,90 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// ErrTxOrphan is passed to DB.TxOrphanHook for transactions rolled back
+// by RollbackOrphans
+var ErrTxOrphan error = errors.New("Orphaned transaction.")
+
+// openTxs tracks the open transactions of a DB and its copies
+type openTxs struct {
+	mtx sync.Mutex
+	txs map[*DB]*sql.Tx
+}
+
+func (ot *openTxs) add(tx *DB) {
+	ot.mtx.Lock()
+	defer ot.mtx.Unlock()
+	ot.txs[tx] = tx.sqlTx
+}
+
+// remove returns the sql.Tx of tx and true if tx was still open
+func (ot *openTxs) remove(tx *DB) (*sql.Tx, bool) {
+	ot.mtx.Lock()
+	defer ot.mtx.Unlock()
+	sqlTx, ok := ot.txs[tx]
+	delete(ot.txs, tx)
+	return sqlTx, ok
+}
+
+// trackTx registers tx as open and rolls it back as soon as ctx, the
+// context passed to Begin, is done
+func (db *DB) trackTx(ctx context.Context, tx *DB) {
+	db.openTxs.add(tx)
+	tx.txStopOrphan = context.AfterFunc(ctx, func() {
+		tx.rollbackOrphan(ctx.Err())
+	})
+}
+
+// untrackTx unregisters tx, this is called by Commit and Rollback
+func (db *DB) untrackTx() {
+	if db.txStopOrphan != nil {
+		db.txStopOrphan()
+		db.txStopOrphan = nil
+	}
+	db.openTxs.remove(db)
+}
+
+// rollbackOrphan rolls back tx if it is still open, after calling the
+// TxOrphanHook. Further use of the transaction fails with sql.ErrTxDone.
+func (db *DB) rollbackOrphan(cause error) bool {
+	sqlTx, ok := db.openTxs.remove(db)
+	if !ok {
+		return false
+	}
+	if db.TxOrphanHook != nil {
+		db.TxOrphanHook(db, cause)
+	}
+	err := sqlTx.Rollback()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		db.logf("%s Rollback of orphaned transaction failed: %s", db, err)
+	}
+	return true
+}
+
+// RollbackOrphans rolls back all open transactions started on db or its
+// copies and returns their number. Use this on shutdown or after a request
+// to clean up transactions which were neither committed nor rolled back.
+// Transactions are rolled back automatically when the context passed to
+// BeginContext is done.
+func (db *DB) RollbackOrphans() int {
+	db.openTxs.mtx.Lock()
+	txs := make([]*DB, 0, len(db.openTxs.txs))
+	for tx := range db.openTxs.txs {
+		txs = append(txs, tx)
+	}
+	db.openTxs.mtx.Unlock()
+
+	n := 0
+	for _, tx := range txs {
+		if tx.rollbackOrphan(ErrTxOrphan) {
+			n++
+		}
+	}
+	return n
+}
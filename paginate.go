@@ -0,0 +1,57 @@
+package sqlpro
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PageOption is an option of Paginate
+type PageOption func(p *page)
+
+type page struct {
+	limit  int64 // -1 for no limit
+	offset int64
+}
+
+// Limit limits the number of rows returned to n
+func Limit(n int64) PageOption {
+	return func(p *page) {
+		p.limit = n
+	}
+}
+
+// Offset skips the first n rows
+func Offset(n int64) PageOption {
+	return func(p *page) {
+		p.offset = n
+	}
+}
+
+// Paginate appends the LIMIT and OFFSET clauses given by opts to query
+// in the syntax of the dialect of the handle. A trailing ";" of query is removed. Without
+// options query is returned unchanged.
+func (db *DB) Paginate(query string, opts ...PageOption) string {
+	p := page{limit: -1}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if p.limit < 0 && p.offset <= 0 {
+		return query
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	return db.dialect().Paginate(query, p.limit, p.offset)
+}
+
+// selectTop adds TOP n to the SELECT query, after DISTINCT if present
+func selectTop(query string, n int64) string {
+	top := " TOP " + strconv.FormatInt(n, 10)
+	upper := strings.ToUpper(query)
+	for _, prefix := range []string{"SELECT DISTINCT", "SELECT"} {
+		if strings.HasPrefix(upper, prefix) {
+			return query[:len(prefix)] + top + query[len(prefix):]
+		}
+	}
+	// no simple SELECT, wrap it
+	return "SELECT" + top + " * FROM (" + query + ") AS t"
+}
@@ -0,0 +1,64 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// pgxDriver is the name the pgx stdlib package registers its driver with
+const pgxDriver = "pgx"
+
+// OpenPgx opens the Postgres database dsn with the pgx driver, so values
+// use the native types of pgx. Register the driver by importing
+// github.com/jackc/pgx/v5/stdlib. To use an existing pgxpool.Pool, wrap
+// it with OpenDB:
+//
+//	db, err := sqlpro.OpenDB("postgres", stdlib.OpenDBFromPool(pool))
+//
+// The handle uses pgx through database/sql. The COPY protocol of pgx is not
+// available this way, so CopyFrom returns an error on pgx handles.
+func OpenPgx(ctx context.Context, dsn string, opts ...Option) (*DB, error) {
+	if !slices.Contains(sql.Drivers(), pgxDriver) {
+		return nil, fmt.Errorf(`OpenPgx: Driver "%s" not registered, import github.com/jackc/pgx/v5/stdlib.`, pgxDriver)
+	}
+	conn, err := sql.Open(pgxDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.PingContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	wrapper, err := OpenDB(POSTGRES, conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	wrapper.DSN = dsn
+	return wrapper, nil
+}
+
+// isPgx returns true if conn uses the pgx driver
+func isPgx(conn *sql.DB) bool {
+	t := reflect.TypeOf(conn.Driver())
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.HasPrefix(t.PkgPath(), "github.com/jackc/pgx/")
+}
+
+// sqlState returns the SQLSTATE of a Postgres error returned by lib/pq or
+// pgx
+func sqlState(err error) (string, bool) {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState(), true
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// PivotSpec describes the pivot table built by Pivot
+type PivotSpec struct {
+	Table  string
+	RowKey string // column whose values become the rows
+	ColKey string // column whose distinct values become the columns
+	Value  string // column aggregated per row and column
+	Agg    string // "sum" (default), "count", "min", "max" or "avg"
+
+	Where string        // optional condition, e.g. "year = ?"
+	Args  []interface{} // args for the placeholders in Where
+}
+
+// PivotRow is a row of a PivotResult. Values holds one aggregated value per
+// entry of PivotResult.Columns, <nil> if no rows matched.
+type PivotRow struct {
+	Key    interface{}   `json:"key"`
+	Values []interface{} `json:"values"`
+}
+
+// PivotResult is filled by Pivot
+type PivotResult struct {
+	Columns []string   `json:"columns"` // the distinct values of PivotSpec.ColKey
+	Rows    []PivotRow `json:"rows"`
+}
+
+var pivotAggs = map[string]bool{
+	"sum":   true,
+	"count": true,
+	"min":   true,
+	"max":   true,
+	"avg":   true,
+}
+
+// Pivot fills out with the pivot table described by spec. The distinct
+// values of spec.ColKey are read first, then one aggregated CASE column per
+// value is selected, grouped by spec.RowKey. Identifiers are escaped and the
+// column values are bound as args. NULL column keys are ignored. []byte
+// values are returned as string.
+func (db *DB) Pivot(ctx context.Context, out *PivotResult, spec PivotSpec) error {
+	agg := strings.ToLower(spec.Agg)
+	if agg == "" {
+		agg = "sum"
+	}
+	if !pivotAggs[agg] {
+		return fmt.Errorf("Pivot: Unsupported aggregate %q.", spec.Agg)
+	}
+	if spec.Table == "" || spec.RowKey == "" || spec.ColKey == "" || spec.Value == "" {
+		return fmt.Errorf("Pivot: Table, RowKey, ColKey and Value are required.")
+	}
+
+	where := "@ IS NOT NULL"
+	if spec.Where != "" {
+		where += " AND (" + spec.Where + ")"
+	}
+
+	var keys []string
+	args := append([]interface{}{spec.ColKey, spec.Table, spec.ColKey}, spec.Args...)
+	err := db.QueryContext(ctx, &keys, "SELECT DISTINCT @ FROM @ WHERE "+where+" ORDER BY 1", args...)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	args = []interface{}{spec.RowKey}
+	sb.WriteString("SELECT @")
+	for _, key := range keys {
+		sb.WriteString(", " + agg + "(CASE WHEN @ = ? THEN @ END)")
+		args = append(args, spec.ColKey, key, spec.Value)
+	}
+	sb.WriteString(" FROM @")
+	args = append(args, spec.Table)
+	if spec.Where != "" {
+		sb.WriteString(" WHERE " + spec.Where)
+		args = append(args, spec.Args...)
+	}
+	sb.WriteString(" GROUP BY 1 ORDER BY 1")
+
+	var rows *sql.Rows
+	err = db.QueryContext(ctx, &rows, sb.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	out.Columns = keys
+	out.Rows = []PivotRow{}
+	for rows.Next() {
+		values := make([]interface{}, len(keys)+1)
+		ptrs := make([]interface{}, len(values))
+		for idx := range values {
+			ptrs[idx] = &values[idx]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return db.debugError(err)
+		}
+		for idx, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[idx] = string(b)
+			}
+		}
+		out.Rows = append(out.Rows, PivotRow{Key: values[0], Values: values[1:]})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return db.debugError(err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sqlpro
+
+import (
+	"reflect"
+	"sync"
+)
+
+// RowAllocator provides the structs rows are scanned into, for slices of
+// pointers to structs, Rows with pointer types and <nil> pointer targets.
+// Use it to recycle rows when scanning many small rows, see RowPool.
+type RowAllocator interface {
+	// NewRow returns a pointer to a zeroed value of type t, or <nil> to
+	// allocate a new value
+	NewRow(t reflect.Type) interface{}
+}
+
+// RowPool is a RowAllocator recycling values of type T using a sync.Pool.
+// Rows handed back using Put must not be used by the caller afterwards.
+type RowPool[T any] struct {
+	pool sync.Pool
+}
+
+func (p *RowPool[T]) NewRow(t reflect.Type) interface{} {
+	if t != reflect.TypeFor[T]() {
+		return nil
+	}
+	if row, ok := p.pool.Get().(*T); ok {
+		return row
+	}
+	return new(T)
+}
+
+// Put zeroes rows and returns them to the pool
+func (p *RowPool[T]) Put(rows ...*T) {
+	var zero T
+	for _, row := range rows {
+		*row = zero
+		p.pool.Put(row)
+	}
+}
+
+// WithRowAllocator returns a copy which scans into rows provided by alloc
+func (db *DB) WithRowAllocator(alloc RowAllocator) *DB {
+	newDB := *db
+	newDB.rowAllocator = alloc
+	return &newDB
+}
+
+// newRow returns a pointer to a new value of type t, provided by the
+// Allocator if set
+func (s *Scanner) newRow(t reflect.Type) reflect.Value {
+	if s.Allocator != nil {
+		if row := s.Allocator.NewRow(t); row != nil {
+			return reflect.ValueOf(row)
+		}
+	}
+	return reflect.New(t)
+}
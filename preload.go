@@ -0,0 +1,238 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// relation describes a field tagged with hasmany or belongsto, e.g.
+//
+//	Comments []Comment `db:"-,hasmany=comments,fk=post_id"`
+//	Author   *User     `db:"-,belongsto=users,fk=author_id"`
+//
+// For hasmany, fk is the column of the child table referencing the primary
+// key of the parent. For belongsto, fk is the column of the parent
+// referencing the primary key of the child.
+type relation struct {
+	field     reflect.StructField
+	table     string
+	fk        string
+	belongsTo bool
+}
+
+// getRelation returns the relation for the field name of struct type t
+func getRelation(t reflect.Type, name string) (*relation, error) {
+	field, ok := t.FieldByName(name)
+	if !ok {
+		return nil, fmt.Errorf("Preload: Unknown field %q in %s.", name, t)
+	}
+
+	rel := relation{field: field}
+	for _, p := range strings.Split(field.Tag.Get("db"), ",")[1:] {
+		switch {
+		case strings.HasPrefix(p, "hasmany="):
+			rel.table = strings.TrimPrefix(p, "hasmany=")
+		case strings.HasPrefix(p, "belongsto="):
+			rel.table = strings.TrimPrefix(p, "belongsto=")
+			rel.belongsTo = true
+		case strings.HasPrefix(p, "fk="):
+			rel.fk = strings.TrimPrefix(p, "fk=")
+		}
+	}
+	if rel.table == "" || rel.fk == "" {
+		return nil, fmt.Errorf("Preload: Field %q in %s needs hasmany= or belongsto= and fk= in the db tag.", name, t)
+	}
+	return &rel, nil
+}
+
+// relationKey returns a comparable key for a primary or foreign key value
+func relationKey(v reflect.Value) (string, bool) {
+	v = reflect.Indirect(v)
+	if !v.IsValid() || v.IsZero() {
+		return "", false
+	}
+	return fmt.Sprint(v.Interface()), true
+}
+
+func (db *DB) Preload(target interface{}, relations ...string) error {
+	return db.PreloadContext(context.Background(), target, relations...)
+}
+
+// PreloadContext loads the given relations (field names) for target, which
+// needs to be a pointer to a struct or a slice of structs. One query is run
+// per relation, the children are set into the parents.
+func (db *DB) PreloadContext(ctx context.Context, target interface{}, relations ...string) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr {
+		panic(fmt.Errorf("Preload: non-pointer %T", target))
+	}
+	rv = rv.Elem()
+
+	parents := []reflect.Value{}
+	switch rv.Kind() {
+	case reflect.Struct:
+		parents = append(parents, rv)
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			parent := reflect.Indirect(rv.Index(i))
+			if parent.IsValid() {
+				parents = append(parents, parent)
+			}
+		}
+	default:
+		panic(fmt.Errorf("Preload: need pointer to struct or slice of structs, got %T", target))
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+
+	parentType := parents[0].Type()
+	if parentType.Kind() != reflect.Struct {
+		panic(fmt.Errorf("Preload: need pointer to struct or slice of structs, got %T", target))
+	}
+
+	for _, name := range relations {
+		rel, err := getRelation(parentType, name)
+		if err != nil {
+			return err
+		}
+		if rel.belongsTo {
+			err = db.preloadBelongsTo(ctx, parents, rel)
+		} else {
+			err = db.preloadHasMany(ctx, parents, rel)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadChildren queries all rows of the child table where col is in keys
+func (db *DB) loadChildren(ctx context.Context, rel *relation, childType reflect.Type, col string, keys []interface{}) (reflect.Value, error) {
+	children := reflect.New(reflect.SliceOf(childType))
+	if len(keys) == 0 {
+		return children.Elem(), nil
+	}
+	err := db.QueryContext(ctx, children.Interface(), "SELECT * FROM @ WHERE @ IN ?", rel.table, col, keys)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return children.Elem(), nil
+}
+
+func (db *DB) preloadHasMany(ctx context.Context, parents []reflect.Value, rel *relation) error {
+	if rel.field.Type.Kind() != reflect.Slice {
+		panic(fmt.Errorf("Preload: hasmany field %q needs to be a slice", rel.field.Name))
+	}
+	childType := rel.field.Type.Elem()
+	childStruct := childType
+	if childStruct.Kind() == reflect.Ptr {
+		childStruct = childStruct.Elem()
+	}
+
+	pk := getStructInfo(parents[0].Type()).onlyPrimaryKey()
+	if pk == nil {
+		return fmt.Errorf("Preload: %s needs exactly one primary key.", parents[0].Type())
+	}
+	fkInfo, ok := getStructInfo(childStruct)[rel.fk]
+	if !ok {
+		return fmt.Errorf("Preload: %s has no column %q.", childStruct, rel.fk)
+	}
+
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, parent := range parents {
+		key, ok := relationKey(pk.fieldValue(parent))
+		if ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, reflect.Indirect(pk.fieldValue(parent)).Interface())
+		}
+	}
+
+	children, err := db.loadChildren(ctx, rel, childType, rel.fk, keys)
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string]reflect.Value{}
+	for i := 0; i < children.Len(); i++ {
+		child := children.Index(i)
+		key, ok := relationKey(fkInfo.fieldValue(reflect.Indirect(child)))
+		if !ok {
+			continue
+		}
+		list, ok := byKey[key]
+		if !ok {
+			list = reflect.MakeSlice(rel.field.Type, 0, 1)
+		}
+		byKey[key] = reflect.Append(list, child)
+	}
+
+	for _, parent := range parents {
+		list := reflect.MakeSlice(rel.field.Type, 0, 0)
+		if key, ok := relationKey(pk.fieldValue(parent)); ok {
+			if l, ok := byKey[key]; ok {
+				list = l
+			}
+		}
+		parent.FieldByIndex(rel.field.Index).Set(list)
+	}
+	return nil
+}
+
+func (db *DB) preloadBelongsTo(ctx context.Context, parents []reflect.Value, rel *relation) error {
+	childType := rel.field.Type
+	childStruct := childType
+	if childStruct.Kind() == reflect.Ptr {
+		childStruct = childStruct.Elem()
+	}
+	if childStruct.Kind() != reflect.Struct {
+		panic(fmt.Errorf("Preload: belongsto field %q needs to be a struct", rel.field.Name))
+	}
+
+	fkInfo, ok := getStructInfo(parents[0].Type())[rel.fk]
+	if !ok {
+		return fmt.Errorf("Preload: %s has no column %q.", parents[0].Type(), rel.fk)
+	}
+	pk := getStructInfo(childStruct).onlyPrimaryKey()
+	if pk == nil {
+		return fmt.Errorf("Preload: %s needs exactly one primary key.", childStruct)
+	}
+
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, parent := range parents {
+		key, ok := relationKey(fkInfo.fieldValue(parent))
+		if ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, reflect.Indirect(fkInfo.fieldValue(parent)).Interface())
+		}
+	}
+
+	children, err := db.loadChildren(ctx, rel, childType, pk.dbName, keys)
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string]reflect.Value{}
+	for i := 0; i < children.Len(); i++ {
+		child := children.Index(i)
+		if key, ok := relationKey(pk.fieldValue(reflect.Indirect(child))); ok {
+			byKey[key] = child
+		}
+	}
+
+	for _, parent := range parents {
+		fieldV := parent.FieldByIndex(rel.field.Index)
+		fieldV.Set(reflect.Zero(childType))
+		if key, ok := relationKey(fkInfo.fieldValue(parent)); ok {
+			if child, ok := byKey[key]; ok {
+				fieldV.Set(child)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+	"unicode/utf8"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// QueryResult is the result of InspectQuery
+type QueryResult struct {
+	SQL     string // the SQL with placeholders replaced
+	Args    []interface{}
+	Columns []string
+	Rows    [][]string // the first InspectMaxRows rows
+	Nulls   [][]bool   // true for the cells of Rows which are NULL
+	More    int        // number of rows not included in Rows
+	Took    time.Duration
+}
+
+// RenderOptions control how a QueryResult is rendered
+type RenderOptions struct {
+	Vertical bool   // render one block per record, like psql \x
+	MaxWidth int    // truncate cells longer than this (in runes), 0 for no limit
+	Null     string // text for NULL cells, defaults to "NULL"
+}
+
+// Render writes the result as table into w
+func (qr *QueryResult) Render(w io.Writer) {
+	qr.RenderWith(w, RenderOptions{})
+}
+
+// RenderWith writes the result into w using the given options
+func (qr *QueryResult) RenderWith(w io.Writer, opts RenderOptions) {
+	rows := qr.cells(opts)
+
+	caption := "Took: " + qr.Took.String()
+	if qr.More > 0 {
+		caption = fmt.Sprintf("… %d more rows. %s", qr.More, caption)
+	}
+
+	if opts.Vertical {
+		colWidth := 0
+		for _, col := range qr.Columns {
+			colWidth = max(colWidth, utf8.RuneCountInString(col))
+		}
+		for idx, row := range rows {
+			fmt.Fprintf(w, "-[ RECORD %d ]-\n", idx+1)
+			for colIdx, cell := range row {
+				fmt.Fprintf(w, "%-*s | %s\n", colWidth, qr.Columns[colIdx], cell)
+			}
+		}
+		fmt.Fprintln(w, caption)
+		return
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(qr.Columns)
+	if opts.MaxWidth > 0 {
+		table.SetAutoWrapText(false)
+	}
+	table.AppendBulk(rows)
+	table.SetCaption(true, caption)
+	table.Render()
+}
+
+// cells returns the rows with NULLs and truncation applied
+func (qr *QueryResult) cells(opts RenderOptions) [][]string {
+	null := opts.Null
+	if null == "" {
+		null = "NULL"
+	}
+	rows := make([][]string, 0, len(qr.Rows))
+	for rowIdx, row := range qr.Rows {
+		cells := make([]string, 0, len(row))
+		for colIdx, cell := range row {
+			if rowIdx < len(qr.Nulls) && colIdx < len(qr.Nulls[rowIdx]) && qr.Nulls[rowIdx][colIdx] {
+				cells = append(cells, null)
+				continue
+			}
+			if opts.MaxWidth > 0 && utf8.RuneCountInString(cell) > opts.MaxWidth {
+				cell = string([]rune(cell)[:opts.MaxWidth]) + "…"
+			}
+			cells = append(cells, cell)
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+func (db *DB) InspectQuery(query string, args ...interface{}) (*QueryResult, error) {
+	return db.InspectQueryContext(context.Background(), query, args...)
+}
+
+// InspectQueryContext runs the query and returns the first InspectMaxRows rows
+// as strings. If ctx has no deadline, InspectQueryTimeout is applied.
+func (db *DB) InspectQueryContext(ctx context.Context, query string, args ...interface{}) (*QueryResult, error) {
+	query0, newArgs, err := db.replaceArgs(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	query0 = db.rewrite(query0)
+
+	if _, ok := ctx.Deadline(); !ok && db.InspectQueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.InspectQueryTimeout)
+		defer cancel()
+	}
+
+	qr := &QueryResult{SQL: query0, Args: newArgs, Rows: [][]string{}, Nulls: [][]bool{}}
+
+	start := db.now()
+	var rows *sql.Rows
+	rows, err = db.db.QueryContext(ctx, query0, newArgs...)
+	if err != nil {
+		return nil, db.sqlError(err, query0, newArgs)
+	}
+	defer rows.Close()
+
+	qr.Columns, err = rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	maxRows := db.inspectMaxRows()
+	for rows.Next() {
+		if maxRows > 0 && len(qr.Rows) >= maxRows {
+			qr.More++
+			continue
+		}
+		row := []*string{}
+		err = scanRow(reflect.ValueOf(&row).Elem(), rows, nil)
+		if err != nil {
+			return nil, err
+		}
+		cells := make([]string, len(row))
+		nulls := make([]bool, len(row))
+		for idx, cell := range row {
+			if cell == nil {
+				nulls[idx] = true
+			} else {
+				cells[idx] = *cell
+			}
+		}
+		qr.Rows = append(qr.Rows, cells)
+		qr.Nulls = append(qr.Nulls, nulls)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, db.sqlError(err, query0, newArgs)
+	}
+	qr.Took = db.now().Sub(start)
+
+	return qr, nil
+}
@@ -1,12 +1,24 @@
 package sqlpro
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -292,6 +304,55 @@ func TestTime(t *testing.T) {
 
 }
 
+func TestTimeUTCAudit(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dbUTC := *db
+	dbUTC.TimeUTC = true
+	dbUTC.TimeAudit = true
+
+	loc := time.FixedZone("test", 2*3600)
+	now := time.Now().In(loc)
+
+	tr := testRow{C: "timeutc", E: &now}
+	err := dbUTC.Insert("test", &tr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, buf.String())
+
+	var e time.Time
+	err = db.Query(&e, "SELECT e FROM test WHERE a = ?", tr.A)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, now.Equal(e))
+	_, offset := e.Zone()
+	assert.Equal(t, 0, offset)
+
+	// Simulate a column storing seconds without zone
+	err = db.Exec(`CREATE TABLE timeaudit(id INTEGER PRIMARY KEY, t DATETIME);
+		CREATE TRIGGER timeaudit_trunc AFTER INSERT ON timeaudit BEGIN
+			UPDATE timeaudit SET t = substr(NEW.t, 1, 19) WHERE id = NEW.id;
+		END`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	type timeAuditRow struct {
+		ID int64     `db:"id,pk,omitempty"`
+		T  time.Time `db:"t"`
+	}
+	dbAudit := *db
+	dbAudit.TimeAudit = true
+	err = dbAudit.Insert("timeaudit", &timeAuditRow{T: now})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, buf.String(), "sqlpro time audit")
+}
+
 func TestUpdate(t *testing.T) {
 	tr := &testRow{
 		A: 1,
@@ -688,6 +749,92 @@ func TestInsertBulk(t *testing.T) {
 	}
 }
 
+func TestInsertBulkCopyIn(t *testing.T) {
+	// runs against Postgres in sqlprotest, these checks need no server
+	err := db.InsertBulkCopyIn("test", testRow{})
+	assert.Error(t, err)
+	err = db.InsertBulkCopyIn("test", []testRow{})
+	assert.NoError(t, err)
+
+	ro := *db
+	ro.readOnly = true
+	err = ro.InsertBulkCopyIn("test", []testRow{{B: "x"}})
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestUpdateBulk(t *testing.T) {
+	rows := make([]*testRow, 0)
+	for i := 0; i < 10; i++ {
+		rows = append(rows, &testRow{
+			B: fmt.Sprintf("bulk %d", i+1),
+			C: "bulk",
+		})
+	}
+
+	err := db.Insert("test", rows)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for idx, row := range rows {
+		row.C = fmt.Sprintf("bulk updated %d", idx+1)
+		if idx%2 == 0 {
+			// omitempty drops "d", so these rows are sent in their own statement
+			row.D = float64(idx + 1)
+		}
+	}
+
+	err = db.UpdateBulk("test", rows)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	readBack := []*testRow{}
+	err = db.Query(&readBack, "SELECT * FROM test WHERE a IN ? ORDER BY a", []int64{rows[0].A, rows[1].A, rows[9].A})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, readBack, 3) {
+		return
+	}
+	assert.Equal(t, "bulk updated 1", readBack[0].C)
+	assert.Equal(t, float64(1), readBack[0].D)
+	assert.Equal(t, "bulk updated 2", readBack[1].C)
+	assert.Equal(t, float64(0), readBack[1].D)
+	assert.Equal(t, "bulk updated 10", readBack[2].C)
+
+	// rows which do not exist must make the rows affected check fail
+	err = db.UpdateBulk("test", []*testRow{{A: -1, C: "missing"}, {A: -2, C: "missing"}})
+	assert.ErrorIs(t, err, ErrMismatchedRowsAffected)
+}
+
+func TestCopyFrom(t *testing.T) {
+	i := 0
+	count, err := db.CopyFrom("test", []string{"b", "d"}, func() ([]interface{}, bool) {
+		if i >= 1200 {
+			return nil, false
+		}
+		i++
+		return []interface{}{"copy from", float64(i)}, true
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1200), count)
+
+	var sum float64
+	err = db.Query(&sum, "SELECT sum(d) FROM test WHERE b = 'copy from'")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, float64(1200*1201/2), sum)
+
+	_, err = db.CopyFrom("test", []string{"b", "d"}, func() ([]interface{}, bool) {
+		return []interface{}{"copy from"}, true
+	})
+	assert.Error(t, err)
+}
+
 func TestDelete(t *testing.T) {
 	err := db.Exec("DELETE FROM test WHERE a IN ?", []int64{-1, -2, -3})
 	if err != nil {
@@ -932,12 +1079,21 @@ func TestReplaceArgs(t *testing.T) {
 		{"ID IN '?'''", ifcArr{}, "ID IN '?'''", true, 0},
 		{"ID IN '??''' WHERE ?", ifcArr{int_args}, "ID IN '?''' WHERE (?,?,?,?)", false, 4},
 		{"ID IN ?", ifcArr{string_args}, "ID IN (?,?,?)", false, 3},
+		{"ORDER BY ?", ifcArr{Raw("lower(name)")}, "ORDER BY lower(name)", false, 0},
+		{"ORDER BY ?", ifcArr{"lower(name)"}, "ORDER BY ?", false, 1},
+		{"SELECT ? FROM @", ifcArr{Ident(`na"me`), Ident("test")}, `SELECT "na""me" FROM "test"`, false, 0},
 	})
 
 	db2.PlaceholderMode = DOLLAR
 
+	cond := Where().Eq("a", 1).In("b", int_args).Or(Where().IsNull("c"), Where().Gt("d", 5).Expr("e < ?", 7))
+
 	runPlaceholderTests(t, db2, []phTest{
 		{"ID IN ?", ifcArr{int_args}, "ID IN ($1,$2,$3,$4)", false, 4},
+		{"SELECT * FROM @ WHERE ? AND f = ?", ifcArr{"test", cond, "x"},
+			`SELECT * FROM "test" WHERE ("a" = $1 AND "b" IN ($2,$3,$4,$5) AND ("c" IS NULL OR ("d" > $6 AND (e < $7)))) AND f = $8`, false, 8},
+		{"WHERE ?", ifcArr{Where()}, "WHERE 1 = 1", false, 0},
+		{"WHERE ?", ifcArr{Where().In("a", []string{}).Not(Where().Eq("b", nil))}, `WHERE (1 = 0 AND NOT ("b" IS NULL))`, false, 0},
 	})
 
 }
@@ -1027,3 +1183,2858 @@ func TestEmbed(t *testing.T) {
 		return
 	}
 }
+
+func TestExplainTotalCost(t *testing.T) {
+	cost, err := explainTotalCost([]byte(`[{"Plan": {"Node Type": "Seq Scan", "Startup Cost": 0.00, "Total Cost": 1693.00}}]`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1693.0, cost)
+
+	_, err = explainTotalCost([]byte(`[]`))
+	assert.Error(t, err)
+}
+
+func TestExplainGuardSkipsDDL(t *testing.T) {
+	pg := *db
+	pg.Driver = POSTGRES
+	pg.ExplainGuard = &ExplainGuard{MaxCost: 1, Reject: true}
+
+	// SQLite can't EXPLAIN (FORMAT JSON), statements not explained pass
+	err := pg.Exec("CREATE TABLE IF NOT EXISTS guarded (a INTEGER)")
+	assert.NoError(t, err)
+	err = pg.Exec("DROP TABLE guarded")
+	assert.NoError(t, err)
+	err = pg.Exec("SELECT 1")
+	assert.ErrorContains(t, err, "EXPLAIN")
+
+	assert.True(t, explainable(" with t AS (SELECT 1) SELECT * FROM t"))
+	assert.True(t, explainable("SELECT\n1"))
+	assert.False(t, explainable("SET CONSTRAINTS ALL DEFERRED"))
+	assert.False(t, explainable("LOCK TABLE t"))
+	assert.False(t, explainable("EXPLAIN SELECT 1"))
+}
+
+func TestBackfill(t *testing.T) {
+	err := db.Exec("CREATE TABLE backfill(id INTEGER PRIMARY KEY, v INTEGER)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Exec("DROP TABLE backfill")
+
+	for i := 1; i <= 25; i++ {
+		err = db.Exec("INSERT INTO backfill (id) VALUES (?)", i)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	batches := 0
+	updated, err := db.Backfill(context.Background(), BackfillSpec{
+		Table:     "backfill",
+		Column:    "v",
+		BatchSize: 10,
+		Value: func(pk int64) (interface{}, error) {
+			if pk == 13 {
+				return nil, nil
+			}
+			return pk * 2, nil
+		},
+		Progress: func(lastPK, updated int64) {
+			batches++
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(24), updated)
+	assert.Equal(t, 3, batches)
+
+	var sum, nulls int64
+	err = db.Query(&sum, "SELECT sum(v) FROM backfill")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(25*26-26), sum)
+
+	err = db.Query(&nulls, "SELECT count(*) FROM backfill WHERE v IS NULL")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1), nulls)
+}
+
+func TestCopySwap(t *testing.T) {
+	err := db.Exec("CREATE TABLE copyswap(id INTEGER PRIMARY KEY, name TEXT, updated_at TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Exec("DROP TABLE IF EXISTS copyswap")
+	defer db.Exec("DROP TABLE IF EXISTS copyswap_old")
+
+	for i := 1; i <= 25; i++ {
+		err = db.Exec("INSERT INTO copyswap (id, name, updated_at) VALUES (?, ?, ?)", i, fmt.Sprintf("name %d", i), "2024-01-01")
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	var progress int64
+	err = db.CopySwap(context.Background(), CopySwapSpec{
+		Table:         "copyswap",
+		CreateShadow:  "CREATE TABLE @ (id INTEGER PRIMARY KEY, name TEXT, name_upper TEXT, updated_at TEXT)",
+		Columns:       []string{"id", "name", "updated_at"},
+		ShadowColumns: []string{"id", "name", "name_upper", "updated_at"},
+		UpdatedAt:     "updated_at",
+		BatchSize:     10,
+		Transform: func(row []interface{}) ([]interface{}, error) {
+			name := fmt.Sprint(row[1])
+			return []interface{}{row[0], name, strings.ToUpper(name), row[2]}, nil
+		},
+		Progress: func(copied int64) {
+			progress = copied
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(25), progress)
+
+	names := []string{}
+	err = db.Query(&names, "SELECT name_upper FROM copyswap ORDER BY id")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, names, 25) {
+		assert.Equal(t, "NAME 25", names[24])
+	}
+
+	var old int64
+	err = db.Query(&old, "SELECT count(*) FROM copyswap_old")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(25), old)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	db2 := *db
+	db2.StmtCacheSize = 2
+	defer db2.ClearStmtCache()
+
+	for i := 0; i < 3; i++ {
+		for _, query := range []string{
+			"SELECT a FROM test WHERE a = ?",
+			"SELECT a FROM test WHERE a >= ?",
+			"SELECT a FROM test WHERE a <= ?",
+		} {
+			var ids []int64
+			err := db2.Query(&ids, query, 1)
+			if !assert.NoError(t, err) {
+				return
+			}
+		}
+	}
+	assert.Equal(t, 2, db2.stmtCache.lru.Len())
+
+	err := db2.Exec("UPDATE test SET b = b WHERE a = ?", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tx, err := db2.Begin()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var a int64
+	err = tx.Query(&a, "SELECT a FROM test WHERE a = ?", 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), a)
+	}
+	tx.Rollback()
+
+	// evicted statements are not closed while in use
+	db2.StmtCacheSize = 1
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				var ids []int64
+				err := db2.Query(&ids, fmt.Sprintf("SELECT a FROM test WHERE a >= ? AND %d = %d", (w+i)%3, (w+i)%3), 1)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	db2.ClearStmtCache()
+	assert.Equal(t, 0, db2.stmtCache.lru.Len())
+
+	// statements prepared concurrently are cached once
+	db2.StmtCacheSize = 2
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ids []int64
+			assert.NoError(t, db2.Query(&ids, "SELECT a FROM test WHERE a = ?", 1))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, db2.stmtCache.lru.Len())
+	assert.Len(t, db2.stmtCache.stmts, 1)
+}
+
+func TestQueryEach(t *testing.T) {
+	var (
+		row   testRow
+		count int
+	)
+
+	err := db.QueryEach(&row, func() error {
+		if row.A <= 0 {
+			t.Errorf("row.A needs to be set.")
+		}
+		count++
+		return nil
+	}, "SELECT * FROM test WHERE a <= ?", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 5, count)
+
+	errStop := fmt.Errorf("stop")
+	count = 0
+	err = db.QueryEach(&row, func() error {
+		count++
+		return errStop
+	}, "SELECT * FROM test")
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, count)
+}
+
+type testRowAnonymize struct {
+	A int64   `db:"a,pk,omitempty"`
+	B string  `db:"b,anonymize=hash"`
+	C *string `db:"c,anonymize=fake"`
+	D float64 `db:"d,anonymize=null"`
+}
+
+func TestExportAnonymized(t *testing.T) {
+	var (
+		row testRowAnonymize
+		buf bytes.Buffer
+	)
+
+	err := db.ExportAnonymized(context.Background(), &buf, ExportCSV, &row,
+		"SELECT a, b, c, d, e FROM test WHERE b = ? AND d > 0 ORDER BY a LIMIT 1", "bar")
+	if !assert.NoError(t, err) {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.Equal(t, "a,b,c,d", lines[0])
+	cells := strings.Split(lines[1], ",")
+	assert.Equal(t, "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", cells[1])
+	assert.Equal(t, "c-d9298a10", cells[2])
+	assert.Equal(t, "0", cells[3])
+
+	buf.Reset()
+	err = db.ExportAnonymized(context.Background(), &buf, ExportNDJSON, &row,
+		"SELECT a, b FROM test ORDER BY a LIMIT 2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), 2)
+
+	// keys follow the query
+	buf.Reset()
+	err = db.ExportAnonymized(context.Background(), &buf, ExportNDJSON, &row,
+		"SELECT b, a FROM test WHERE b = ? AND d > 0 ORDER BY a LIMIT 1", "bar")
+	if assert.NoError(t, err) {
+		assert.True(t, strings.HasPrefix(buf.String(), `{"b":"fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9","a":`), buf.String())
+	}
+}
+
+func TestRows(t *testing.T) {
+	count := 0
+	for row, err := range Rows[*testRow](context.Background(), db, "SELECT * FROM test WHERE a <= ? ORDER BY a", 3) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		count++
+		assert.Equal(t, int64(count), row.A)
+	}
+	assert.Equal(t, 3, count)
+
+	count = 0
+	for range Rows[int64](context.Background(), db, "SELECT a FROM test") {
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, count)
+
+	for _, err := range Rows[testRow](context.Background(), db, "SELECT * FROM missing_table") {
+		assert.Error(t, err)
+	}
+}
+
+func TestDiffRows(t *testing.T) {
+	b, err := Open("sqlite3", "file:diffrows?mode=memory&cache=shared")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer b.Close()
+
+	for _, h := range []*DB{db, b} {
+		err = h.Exec("CREATE TABLE diffrows(id INTEGER PRIMARY KEY, name TEXT)")
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	defer db.Exec("DROP TABLE diffrows")
+
+	err = db.Exec("INSERT INTO diffrows (id, name) VALUES (1, 'one'), (2, 'two'), (3, 'three')")
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = b.Exec("INSERT INTO diffrows (id, name) VALUES (2, 'two'), (3, 'drei'), (4, 'vier')")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	diffs := []RowDiff{}
+	err = DiffRows(context.Background(), db, b, "diffrows", []string{"id"}, func(diff RowDiff) error {
+		diffs = append(diffs, diff)
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, diffs, 3) {
+		return
+	}
+	assert.Equal(t, DiffDeleted, diffs[0].Kind)
+	assert.Equal(t, []interface{}{float64(1)}, diffs[0].Key)
+	assert.Equal(t, DiffChanged, diffs[1].Kind)
+	assert.Equal(t, []string{"name"}, diffs[1].Columns)
+	assert.Equal(t, "drei", diffs[1].B["name"])
+	assert.Equal(t, DiffInserted, diffs[2].Kind)
+	assert.Equal(t, []interface{}{float64(4)}, diffs[2].Key)
+}
+
+func TestQueryAllOne(t *testing.T) {
+	rows, err := QueryAll[testRow](context.Background(), db, "SELECT * FROM test WHERE a IN ? ORDER BY a", []int64{1, 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, int64(2), rows[1].A)
+	}
+
+	count, err := QueryOne[int64](context.Background(), db, "SELECT count(*) FROM test WHERE a IN ?", []int64{1, 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(2), count)
+
+	row, err := QueryOne[*testRow](context.Background(), db, "SELECT * FROM test WHERE a = ?", -1)
+	assert.Equal(t, ErrQueryReturnedZeroRows, err)
+	assert.Nil(t, row)
+}
+
+func TestValidateConstraints(t *testing.T) {
+	type checkRow struct {
+		ID    int64   `db:"id,pk"`
+		D     float64 `db:"d" check:"d >= 0 AND d < 100"`
+		Name  *string `db:"name" check:"name <> 'root'"`
+		Min   int     `db:"min"`
+		Max   int     `db:"max" check:"max >= min"`
+		Other string  `db:"other" check:"length(other) > 2"`
+	}
+
+	root := "root"
+	for _, tc := range []struct {
+		row checkRow
+		ok  bool
+	}{
+		{checkRow{D: 5, Min: 1, Max: 2}, true},
+		{checkRow{D: -1}, false},
+		{checkRow{D: 100}, false},
+		{checkRow{Name: &root}, false},
+		{checkRow{Min: 3, Max: 2}, false},
+		{checkRow{Other: "x"}, true}, // not evaluated in Go
+	} {
+		err := db.ValidateConstraints(&tc.row)
+		if tc.ok {
+			assert.NoError(t, err, "%#v", tc.row)
+		} else {
+			assert.ErrorIs(t, err, ErrCheckConstraint, "%#v", tc.row)
+		}
+	}
+}
+
+func TestCheckClauses(t *testing.T) {
+	type checkRow struct {
+		ID   int64   `db:"id,pk,omitempty"`
+		D    float64 `db:"d" check:"d >= 0 AND d < 100"`
+		Name string  `db:"name" check:"length(name) > 2"`
+	}
+
+	clauses := db.CheckClauses(checkRow{})
+	assert.Equal(t, []string{"CHECK (d >= 0 AND d < 100)", "CHECK (length(name) > 2)"}, clauses)
+
+	err := db.Exec("CREATE TABLE checked (id INTEGER PRIMARY KEY, d REAL, name TEXT, " + strings.Join(clauses, ", ") + ")")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Exec("DROP TABLE checked")
+
+	assert.NoError(t, db.Insert("checked", &checkRow{D: 1, Name: "abc"}))
+	// not evaluated in Go, enforced by the database
+	assert.ErrorContains(t, db.Insert("checked", &checkRow{D: 1, Name: "x"}), "CHECK constraint failed")
+}
+
+func TestStrictScan(t *testing.T) {
+	type row struct {
+		A int64  `db:"a"`
+		B string `db:"b"`
+	}
+
+	var rows []row
+	err := db.Strict().Query(&rows, "SELECT a, b FROM test")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = db.Strict().Query(&rows, "SELECT a, b, c AS cc FROM test")
+	assert.ErrorIs(t, err, ErrUnmappedColumns)
+	assert.Contains(t, err.Error(), "cc")
+
+	// Non strict mode ignores unmapped columns
+	err = db.Query(&rows, "SELECT a, b, c AS cc FROM test")
+	assert.NoError(t, err)
+
+	var r row
+	err = db.Strict().QueryEach(&r, func() error { return nil }, "SELECT a, c FROM test")
+	assert.ErrorIs(t, err, ErrUnmappedColumns)
+
+	for _, err := range Rows[row](context.Background(), db.Strict(), "SELECT a, d FROM test") {
+		assert.ErrorIs(t, err, ErrUnmappedColumns)
+	}
+}
+
+func TestRequireAllFields(t *testing.T) {
+	type row struct {
+		A int64  `db:"a"`
+		B string `db:"b"`
+		C string `db:"c"`
+	}
+
+	var rows []row
+	err := db.RequireAllFields().Query(&rows, "SELECT a, b, c FROM test")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = db.RequireAllFields().Query(&rows, "SELECT a, b FROM test")
+	assert.ErrorIs(t, err, ErrMissingColumns)
+	assert.Contains(t, err.Error(), ": c")
+
+	err = db.Query(&rows, "SELECT a, b FROM test")
+	assert.NoError(t, err)
+}
+
+func TestStrictIdentifiers(t *testing.T) {
+	assert.NoError(t, db.CheckIdentifier("name"))
+	assert.ErrorIs(t, db.CheckIdentifier("order"), ErrInvalidIdentifier)
+	assert.ErrorIs(t, db.CheckIdentifier(""), ErrInvalidIdentifier)
+
+	pg := New(nil)
+	pg.Driver = POSTGRES
+	assert.ErrorIs(t, pg.CheckIdentifier(strings.Repeat("x", 64)), ErrInvalidIdentifier)
+	assert.NoError(t, pg.CheckIdentifier(strings.Repeat("x", 63)))
+
+	type reservedRow struct {
+		A     int64  `db:"a,pk,omitempty"`
+		Order string `db:"order"`
+	}
+	strict := *db
+	strict.StrictIdentifiers = true
+	err := strict.Insert("test", &reservedRow{Order: "x"})
+	assert.ErrorIs(t, err, ErrInvalidIdentifier)
+
+	err = strict.Insert("test", &testRow{C: "strict identifiers"})
+	assert.NoError(t, err)
+}
+
+func TestWithComment(t *testing.T) {
+	ctx := WithComment(context.Background(), "request_id", "a'b*/c")
+	ctx = WithComment(ctx, "action", "test")
+	assert.Equal(t, `/*action='test',request_id='a%27b%2A%2Fc'*/`, commentFromContext(ctx))
+	assert.Equal(t, "", commentFromContext(context.Background()))
+
+	var count int64
+	err := db.QueryContext(ctx, &count, "SELECT COUNT(*) FROM test WHERE a > ?", 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotZero(t, count)
+
+	err = db.ExecContext(ctx, "UPDATE test SET d = d WHERE a = ?", 1)
+	assert.NoError(t, err)
+}
+
+func TestScanPrefix(t *testing.T) {
+	err := db.Exec(`CREATE TABLE author(id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE book(id INTEGER PRIMARY KEY, title TEXT, author_id INTEGER)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type author struct {
+		ID   int64  `db:"id,pk,omitempty"`
+		Name string `db:"name"`
+	}
+	type book struct {
+		ID       int64  `db:"id,pk,omitempty"`
+		Title    string `db:"title"`
+		AuthorID int64  `db:"author_id"`
+	}
+	type bookWithAuthor struct {
+		ID     int64  `db:"id,pk"`
+		Title  string `db:"title"`
+		Author author `db:"author,prefix"`
+	}
+
+	a := author{Name: "Tolkien"}
+	err = db.Insert("author", &a)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b := book{Title: "The Hobbit", AuthorID: a.ID}
+	err = db.Insert("book", &b)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var books []bookWithAuthor
+	err = db.Query(&books, `SELECT b.id, b.title, a.id AS author_id, a.name AS author_name
+		FROM book b JOIN author a ON a.id = b.author_id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, books, 1) {
+		assert.Equal(t, b.ID, books[0].ID)
+		assert.Equal(t, "The Hobbit", books[0].Title)
+		assert.Equal(t, a.ID, books[0].Author.ID)
+		assert.Equal(t, "Tolkien", books[0].Author.Name)
+
+		// Nested fields are not written
+		err = db.Update("book", books[0])
+		assert.NoError(t, err)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	err := db.Exec(`CREATE TABLE preload_user(id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE preload_post(id INTEGER PRIMARY KEY, title TEXT, author_id INTEGER);
+		CREATE TABLE preload_comment(id INTEGER PRIMARY KEY, post_id INTEGER, body TEXT)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type user struct {
+		ID   int64  `db:"id,pk,omitempty"`
+		Name string `db:"name"`
+	}
+	type comment struct {
+		ID     int64  `db:"id,pk,omitempty"`
+		PostID int64  `db:"post_id"`
+		Body   string `db:"body"`
+	}
+	type post struct {
+		ID       int64      `db:"id,pk,omitempty"`
+		Title    string     `db:"title"`
+		AuthorID int64      `db:"author_id"`
+		Author   *user      `db:"-,belongsto=preload_user,fk=author_id"`
+		Comments []*comment `db:"-,hasmany=preload_comment,fk=post_id"`
+	}
+
+	u := user{Name: "Ann"}
+	if !assert.NoError(t, db.Insert("preload_user", &u)) {
+		return
+	}
+	posts := []*post{{Title: "one", AuthorID: u.ID}, {Title: "two", AuthorID: u.ID}, {Title: "three"}}
+	if !assert.NoError(t, db.Insert("preload_post", posts)) {
+		return
+	}
+	comments := []*comment{
+		{PostID: posts[0].ID, Body: "a"},
+		{PostID: posts[0].ID, Body: "b"},
+		{PostID: posts[1].ID, Body: "c"},
+	}
+	if !assert.NoError(t, db.InsertBulk("preload_comment", comments)) {
+		return
+	}
+
+	var loaded []post
+	err = db.Query(&loaded, "SELECT * FROM preload_post ORDER BY id")
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = db.Preload(&loaded, "Comments", "Author")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Len(t, loaded[0].Comments, 2)
+	assert.Len(t, loaded[1].Comments, 1)
+	assert.Len(t, loaded[2].Comments, 0)
+	assert.Equal(t, "c", loaded[1].Comments[0].Body)
+	if assert.NotNil(t, loaded[0].Author) {
+		assert.Equal(t, "Ann", loaded[0].Author.Name)
+	}
+	assert.Nil(t, loaded[2].Author)
+
+	err = db.Preload(&loaded, "Title")
+	assert.Error(t, err)
+}
+
+func TestWithFallback(t *testing.T) {
+	primary, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	primary.DB().Close()
+
+	var hookErr error
+	primary.FallbackHook = func(fallback *DB, err error) {
+		hookErr = err
+	}
+
+	var count int64
+	err = primary.Query(&count, "SELECT COUNT(*) FROM test")
+	assert.Error(t, err)
+
+	err = primary.WithFallback(db).Query(&count, "SELECT COUNT(*) FROM test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotZero(t, count)
+	assert.True(t, IsConnectionError(hookErr))
+
+	// writes are not replayed on the fallback
+	hookErr = nil
+	err = primary.WithFallback(db).Query(&count, "INSERT INTO test (b) VALUES (?) RETURNING a", "fallback")
+	assert.True(t, IsConnectionError(err))
+	assert.Nil(t, hookErr)
+
+	// Query errors are not retried
+	assert.False(t, IsConnectionError(db.Query(&count, "SELECT * FROM nonexisting")))
+	assert.False(t, IsConnectionError(context.DeadlineExceeded))
+	assert.False(t, IsConnectionError(fmt.Errorf("query: %w", context.Canceled)))
+}
+
+func TestNullTypes(t *testing.T) {
+	err := db.Exec("CREATE TABLE nulltypes(id INTEGER PRIMARY KEY, s TEXT, i INTEGER, f REAL, t DATETIME, ts TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type nullRow struct {
+		ID int64           `db:"id,pk,omitempty"`
+		S  sql.NullString  `db:"s"`
+		I  Null[int32]     `db:"i"`
+		F  sql.NullFloat64 `db:"f"`
+		T  sql.NullTime    `db:"t"`
+		TS Null[time.Time] `db:"ts"`
+	}
+
+	now := time.Now().Round(0)
+	rows := []*nullRow{
+		{S: sql.NullString{String: "x", Valid: true}, I: NewNull[int32](0), F: sql.NullFloat64{Float64: 1.5, Valid: true},
+			T: sql.NullTime{Time: now, Valid: true}, TS: NewNull(now)},
+		{},
+	}
+	if !assert.NoError(t, db.Insert("nulltypes", rows)) {
+		return
+	}
+	bulk := []nullRow{*rows[0], {}}
+	bulk[0].ID = 0
+	if !assert.NoError(t, db.InsertBulk("nulltypes", bulk)) {
+		return
+	}
+
+	var nulls int64
+	err = db.Query(&nulls, "SELECT COUNT(*) FROM nulltypes WHERE s IS NULL AND i IS NULL AND f IS NULL AND t IS NULL AND ts IS NULL")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(2), nulls)
+
+	var loaded []nullRow
+	err = db.Query(&loaded, "SELECT * FROM nulltypes ORDER BY id")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, loaded, 4) {
+		return
+	}
+	for _, idx := range []int{0, 2} {
+		assert.Equal(t, "x", loaded[idx].S.String)
+		assert.Equal(t, NewNull[int32](0), loaded[idx].I)
+		assert.Equal(t, 1.5, loaded[idx].F.Float64)
+		assert.True(t, loaded[idx].T.Valid)
+		assert.True(t, now.Equal(loaded[idx].T.Time))
+		assert.True(t, loaded[idx].TS.Valid)
+		assert.True(t, now.Equal(loaded[idx].TS.V))
+	}
+	for _, idx := range []int{1, 3} {
+		assert.False(t, loaded[idx].S.Valid)
+		assert.False(t, loaded[idx].I.Valid)
+		assert.False(t, loaded[idx].T.Valid)
+		assert.False(t, loaded[idx].TS.Valid)
+	}
+
+	data, err := json.Marshal(loaded[1].I)
+	assert.NoError(t, err)
+	assert.Equal(t, "null", string(data))
+}
+
+func TestRegisterConverter(t *testing.T) {
+	RegisterConverter(reflect.TypeOf(net.IP{}),
+		func(v interface{}) (interface{}, error) {
+			if len(v.(net.IP)) == 0 {
+				return nil, nil
+			}
+			return v.(net.IP).String(), nil
+		},
+		func(v interface{}, target reflect.Value) error {
+			switch s := v.(type) {
+			case nil:
+				target.Set(reflect.Zero(target.Type()))
+			case string:
+				target.Set(reflect.ValueOf(net.ParseIP(s)))
+			case []byte:
+				target.Set(reflect.ValueOf(net.ParseIP(string(s))))
+			default:
+				return fmt.Errorf("unable to convert %T to net.IP", v)
+			}
+			return nil
+		})
+
+	err := db.Exec("CREATE TABLE converter(id INTEGER PRIMARY KEY, ip TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type ipRow struct {
+		ID int64  `db:"id,pk,omitempty"`
+		IP net.IP `db:"ip"`
+	}
+
+	ip := net.ParseIP("192.168.1.1")
+	if !assert.NoError(t, db.Insert("converter", &ipRow{IP: ip})) {
+		return
+	}
+	if !assert.NoError(t, db.InsertBulk("converter", []ipRow{{IP: net.ParseIP("::1")}, {}})) {
+		return
+	}
+
+	var s string
+	err = db.Query(&s, "SELECT ip FROM converter WHERE ip = ?", ip)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "192.168.1.1", s)
+
+	var rows []ipRow
+	err = db.Query(&rows, "SELECT * FROM converter ORDER BY id")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var nulls int64
+	err = db.Query(&nulls, "SELECT COUNT(*) FROM converter WHERE ip IS NULL")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), nulls)
+
+	if assert.Len(t, rows, 3) {
+		assert.True(t, ip.Equal(rows[0].IP))
+		assert.True(t, net.ParseIP("::1").Equal(rows[1].IP))
+		assert.Nil(t, rows[2].IP)
+	}
+}
+
+func TestInspectQuery(t *testing.T) {
+	var count int
+	err := db.Query(&count, "SELECT COUNT(*) FROM test")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	dbI := *db
+	dbI.InspectMaxRows = 2
+	qr, err := dbI.InspectQuery("SELECT a, c FROM test WHERE a > ?", 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"a", "c"}, qr.Columns)
+	assert.Len(t, qr.Rows, 2)
+	assert.Equal(t, count-2, qr.More)
+
+	var buf bytes.Buffer
+	qr.Render(&buf)
+	assert.Contains(t, buf.String(), fmt.Sprintf("… %d more", count-2))
+
+	_, err = dbI.InspectQuery("SELECT a FROM test WHERE a > ?")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dbI.InspectQueryContext(ctx, "SELECT a FROM test")
+	assert.Error(t, err)
+}
+
+func TestArrayTag(t *testing.T) {
+	err := db.Exec("CREATE TABLE arraytag(id INTEGER PRIMARY KEY, tags TEXT, nums TEXT, floats TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type arrayRow struct {
+		ID     int64     `db:"id,pk,omitempty"`
+		Tags   []string  `db:"tags,array"`
+		Nums   []int64   `db:"nums,array"`
+		Floats []float64 `db:"floats,array"`
+	}
+
+	rows := []arrayRow{
+		{Tags: []string{"a", "b c", `"quoted"`}, Nums: []int64{1, 2, 3}, Floats: []float64{1.5}},
+		{},
+	}
+	if !assert.NoError(t, db.Insert("arraytag", rows)) {
+		return
+	}
+	if !assert.NoError(t, db.InsertBulk("arraytag", []arrayRow{{Tags: []string{"bulk"}}})) {
+		return
+	}
+
+	var loaded []arrayRow
+	err = db.Query(&loaded, "SELECT * FROM arraytag ORDER BY id")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, loaded, 3) {
+		assert.Equal(t, rows[0].Tags, loaded[0].Tags)
+		assert.Equal(t, rows[0].Nums, loaded[0].Nums)
+		assert.Equal(t, rows[0].Floats, loaded[0].Floats)
+		assert.Nil(t, loaded[1].Tags)
+		assert.Equal(t, []string{"bulk"}, loaded[2].Tags)
+	}
+}
+
+func TestRenderOptions(t *testing.T) {
+	qr, err := db.InspectQuery("SELECT 1 AS id, NULL AS n, '' AS e, 'abcdefghij' AS long")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, [][]bool{{false, true, false, false}}, qr.Nulls)
+
+	var buf bytes.Buffer
+	qr.RenderWith(&buf, RenderOptions{Vertical: true, MaxWidth: 4, Null: "<null>"})
+	out := buf.String()
+	assert.Contains(t, out, "-[ RECORD 1 ]-")
+	assert.Contains(t, out, "n    | <null>\n")
+	assert.Contains(t, out, "e    | \n")
+	assert.Contains(t, out, "long | abcd…\n")
+
+	buf.Reset()
+	qr.Render(&buf)
+	assert.Contains(t, buf.String(), "NULL")
+	assert.Contains(t, buf.String(), "abcdefghij")
+}
+
+func TestSample(t *testing.T) {
+	res, err := db.Sample(context.Background(), "test", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, res.Rows, 5)
+	if assert.NotEmpty(t, res.Columns) {
+		assert.Equal(t, "a", res.Columns[0].Name)
+		assert.Equal(t, "INTEGER", res.Columns[0].DatabaseType)
+	}
+	for _, row := range res.Rows {
+		assert.Contains(t, row, "c")
+	}
+
+	_, err = db.Sample(context.Background(), "test", 0)
+	assert.Error(t, err)
+}
+
+func TestQueryRow(t *testing.T) {
+	var (
+		a int64
+		c string
+		d sql.NullFloat64
+	)
+	err := db.QueryRow("SELECT a, c, d FROM test WHERE a IN ? ORDER BY a", []interface{}{[]int64{1, 2}}, &a, &c, &d)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1), a)
+
+	err = db.QueryRow("SELECT a FROM test WHERE a = ?", []interface{}{-1}, &a)
+	assert.Equal(t, ErrQueryReturnedZeroRows, err)
+}
+
+func TestDataDictionary(t *testing.T) {
+	RegisterTable("test", &testRow{})
+
+	dict, err := db.DataDictionary(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var table *DictionaryTable
+	for idx := range dict.Tables {
+		if dict.Tables[idx].Name == "test" {
+			table = &dict.Tables[idx]
+		}
+	}
+	if !assert.NotNil(t, table) {
+		return
+	}
+	assert.Equal(t, "sqlpro.testRow", table.GoType)
+	if assert.NotEmpty(t, table.Columns) {
+		col := table.Columns[0]
+		assert.Equal(t, "a", col.Name)
+		assert.Equal(t, "INTEGER", col.DatabaseType)
+		assert.True(t, col.PrimaryKey)
+		assert.Equal(t, "A", col.GoField)
+		assert.Equal(t, "int64", col.GoType)
+	}
+
+	_, err = json.Marshal(dict)
+	assert.NoError(t, err)
+}
+
+func TestSyncComments(t *testing.T) {
+	type commentRow struct {
+		A int64  `db:"a,pk,comment=The id"`
+		B string `db:"b,notnull,comment=Full name, as entered"`
+		C string `db:"c"`
+	}
+
+	info := getStructInfo(reflect.TypeOf(commentRow{}))
+	assert.Equal(t, "The id", info["a"].comment)
+	assert.True(t, info["a"].primaryKey)
+	assert.Equal(t, "Full name, as entered", info["b"].comment)
+	assert.True(t, info["b"].notNull)
+	assert.Equal(t, "", info["c"].comment)
+
+	// sqlite has no comments
+	assert.NoError(t, db.SyncComments("test", &commentRow{}))
+}
+
+func TestPivot(t *testing.T) {
+	err := db.Exec(`CREATE TABLE pivot(region TEXT, year INTEGER, amount INTEGER);
+		INSERT INTO pivot VALUES ('north', 2023, 1), ('north', 2023, 2), ('north', 2024, 5),
+			('south', 2024, 7), ('south', NULL, 100), ('it''s', 2022, 3)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var out PivotResult
+	err = db.Pivot(context.Background(), &out, PivotSpec{
+		Table:  "pivot",
+		RowKey: "region",
+		ColKey: "year",
+		Value:  "amount",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"2022", "2023", "2024"}, out.Columns)
+	if assert.Len(t, out.Rows, 3) {
+		assert.Equal(t, "it's", out.Rows[0].Key)
+		assert.Equal(t, []interface{}{nil, int64(3), int64(5)}, out.Rows[1].Values)
+		assert.Equal(t, []interface{}{nil, nil, int64(7)}, out.Rows[2].Values)
+	}
+
+	err = db.Pivot(context.Background(), &out, PivotSpec{
+		Table:  "pivot",
+		RowKey: "region",
+		ColKey: "year",
+		Value:  "amount",
+		Agg:    "count",
+		Where:  "region = ?",
+		Args:   []interface{}{"north"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"2023", "2024"}, out.Columns)
+	if assert.Len(t, out.Rows, 1) {
+		assert.Equal(t, []interface{}{int64(2), int64(1)}, out.Rows[0].Values)
+	}
+
+	err = db.Pivot(context.Background(), &out, PivotSpec{Table: "pivot", RowKey: "region", ColKey: "year", Value: "amount", Agg: "sum(1); --"})
+	assert.Error(t, err)
+}
+
+func TestGroupScan(t *testing.T) {
+	err := db.Exec(`CREATE TABLE groupscan(region TEXT, year INTEGER, amount INTEGER);
+		INSERT INTO groupscan VALUES ('north', 2023, 1), ('north', 2023, 2), ('south', 2024, 7)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type salesKey struct {
+		Region string `db:"region"`
+		Year   int    `db:"year"`
+	}
+	type salesSummary struct {
+		Key   salesKey `db:"group:"`
+		Count int      `db:"count"`
+		Total int      `db:"total"`
+	}
+	type salesSummaryPrefixed struct {
+		Key   salesKey `db:"group:k"`
+		Total int      `db:"total"`
+	}
+
+	var summaries []salesSummary
+	err = db.Query(&summaries, `SELECT region, year, count(*) AS count, sum(amount) AS total
+		FROM groupscan GROUP BY region, year ORDER BY region`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []salesSummary{
+		{Key: salesKey{"north", 2023}, Count: 2, Total: 3},
+		{Key: salesKey{"south", 2024}, Count: 1, Total: 7},
+	}, summaries)
+
+	var prefixed []salesSummaryPrefixed
+	err = db.Query(&prefixed, `SELECT region AS k_region, year AS k_year, sum(amount) AS total
+		FROM groupscan GROUP BY region, year ORDER BY region`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, prefixed, 2) {
+		assert.Equal(t, salesKey{"south", 2024}, prefixed[1].Key)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	var rows []testRow
+	err := db.Query(&rows, "SELECT * FROM test WHERE ? ORDER BY a", Where().In("a", []int64{1, 2}).Or(Where().Eq("a", 1), Where().NotNull("a")))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, rows, 2)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.now = fc.now.Add(time.Second)
+	return fc.now
+}
+
+func TestClockQueryHook(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var infos []QueryInfo
+
+	db2 := *db
+	db2.Clock = &fakeClock{now: start}
+	db2.QueryHook = func(ctx context.Context, qi QueryInfo) {
+		infos = append(infos, qi)
+	}
+
+	var a int64
+	err := db2.Query(&a, "SELECT a FROM test WHERE a = ?", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = db2.Query(&a, "SELECT nope FROM test")
+	assert.Error(t, err)
+
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, "SELECT a FROM test WHERE a = ?", infos[0].SQL)
+		assert.Equal(t, []interface{}{1}, infos[0].Args)
+		assert.Equal(t, start.Add(time.Second), infos[0].Start)
+		assert.Equal(t, start.Add(2*time.Second), infos[0].Stop)
+		assert.NoError(t, infos[0].Err)
+		assert.Error(t, infos[1].Err)
+	}
+
+	qr, err := db2.InspectQuery("SELECT a FROM test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Second, qr.Took)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	var rows *sql.Rows
+	err := db.Query(&rows, "SELECT a, c, 'x' AS unmapped FROM test ORDER BY a LIMIT 2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if !assert.NoError(t, err) {
+		return
+	}
+	scanner := NewScanner(reflect.TypeOf(testRow{}), cols)
+
+	var got []testRow
+	for rows.Next() {
+		var row testRow
+		err = scanner.Scan(&row, rows)
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, row)
+	}
+	assert.NoError(t, rows.Err())
+	if assert.Len(t, got, 2) {
+		assert.True(t, got[0].A < got[1].A)
+	}
+
+	assert.Panics(t, func() {
+		scanner.Scan(&testRowPtr{}, rows)
+	})
+}
+
+func TestNewUUID(t *testing.T) {
+	db2 := *db
+	db2.Rand = bytes.NewReader(bytes.Repeat([]byte{0xff}, 32))
+
+	id, err := db2.NewUUID()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "ffffffff-ffff-4fff-bfff-ffffffffffff", id)
+
+	id, err = db2.NewUUID()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "ffffffff-ffff-4fff-bfff-ffffffffffff", id)
+
+	// exhausted
+	_, err = db2.NewUUID()
+	assert.Error(t, err)
+
+	id, err = db.NewUUID()
+	if assert.NoError(t, err) {
+		assert.Len(t, id, 36)
+	}
+}
+
+type versionedDoc struct {
+	FullName string `json:"full_name"`
+}
+
+func TestJSONVersion(t *testing.T) {
+	RegisterJSONVersion(reflect.TypeOf(versionedDoc{}), func(data json.RawMessage) (json.RawMessage, error) {
+		var v0 struct {
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal(data, &v0)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(versionedDoc{FullName: v0.Name})
+	})
+
+	type docRow struct {
+		ID  int64         `db:"id,pk,omitempty"`
+		Doc *versionedDoc `db:"doc,json"`
+	}
+
+	err := db.Exec(`CREATE TABLE jsonversion(id INTEGER PRIMARY KEY, doc TEXT);
+		INSERT INTO jsonversion(doc) VALUES ('{"name":"old"}'), ('{"v":0,"data":{"name":"enveloped"}}'), ('{"v":7,"data":{}}')`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	row := docRow{Doc: &versionedDoc{FullName: "new"}}
+	err = db.Insert("jsonversion", &row)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var raw string
+	err = db.Query(&raw, "SELECT doc FROM jsonversion WHERE id = ?", row.ID)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"v":1,"data":{"full_name":"new"}}`, raw)
+	}
+
+	var rows []docRow
+	err = db.Query(&rows, "SELECT * FROM jsonversion WHERE id IN ? ORDER BY id", []int64{1, 2, row.ID})
+	if assert.NoError(t, err) && assert.Len(t, rows, 3) {
+		assert.Equal(t, "old", rows[0].Doc.FullName)
+		assert.Equal(t, "enveloped", rows[1].Doc.FullName)
+		assert.Equal(t, "new", rows[2].Doc.FullName)
+	}
+
+	err = db.Query(&rows, "SELECT * FROM jsonversion WHERE id = 3")
+	assert.Error(t, err)
+}
+
+func TestQueryFirst(t *testing.T) {
+	var row testRow
+	found, err := db.QueryFirst(context.Background(), &row, "SELECT * FROM test WHERE a = ?", 1)
+	if assert.NoError(t, err) {
+		assert.True(t, found)
+		assert.Equal(t, int64(1), row.A)
+	}
+
+	found, err = db.QueryFirst(context.Background(), &row, "SELECT * FROM test WHERE a = ?", -1)
+	if assert.NoError(t, err) {
+		assert.False(t, found)
+		assert.Equal(t, int64(1), row.A)
+	}
+
+	_, err = db.QueryFirst(context.Background(), &row, "SELECT nope FROM test")
+	assert.Error(t, err)
+
+	var rows []testRow
+	found, err = db.QueryFirst(context.Background(), &rows, "SELECT * FROM test WHERE a = ?", -1)
+	if assert.NoError(t, err) {
+		assert.False(t, found)
+	}
+	found, err = db.QueryFirst(context.Background(), &rows, "SELECT * FROM test WHERE a = ?", 1)
+	if assert.NoError(t, err) {
+		assert.True(t, found)
+		assert.Len(t, rows, 1)
+	}
+
+	var tbl Table
+	found, err = db.QueryFirst(context.Background(), &tbl, "SELECT * FROM test WHERE a = ?", -1)
+	if assert.NoError(t, err) {
+		assert.False(t, found)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	type money struct {
+		Amount   int64  `db:"amount"`
+		Currency string `db:"currency"`
+	}
+	type order struct {
+		ID    int64 `db:"id,pk,omitempty"`
+		Total money `db:"total,flatten"`
+	}
+
+	err := db.Exec("CREATE TABLE flatten(id INTEGER PRIMARY KEY, amount INTEGER, currency TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	o := order{Total: money{Amount: 1250, Currency: "EUR"}}
+	err = db.Insert("flatten", &o)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	o.Total.Currency = "USD"
+	err = db.Update("flatten", &o)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var o2 order
+	err = db.Query(&o2, "SELECT * FROM flatten WHERE id = ?", o.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, o, o2)
+	}
+}
+
+func TestTimeLayoutsDuration(t *testing.T) {
+	type durationRow struct {
+		ID      int64          `db:"id,pk,omitempty"`
+		T       time.Time      `db:"t"`
+		Timeout time.Duration  `db:"timeout,duration"`
+		Retry   *time.Duration `db:"retry,duration"`
+	}
+
+	err := db.Exec(`CREATE TABLE durations(id INTEGER PRIMARY KEY, t TEXT, timeout REAL, retry INTEGER);
+		INSERT INTO durations(t, timeout, retry) VALUES ('2024-01-02 03:04:05', 1.5, 3)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var row durationRow
+	err = db.Query(&row, "SELECT * FROM durations WHERE id = 1")
+	assert.Error(t, err)
+
+	db2 := *db
+	db2.TimeLayouts = []string{"2006-01-02 15:04:05"}
+	err = db2.Query(&row, "SELECT * FROM durations WHERE id = 1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), row.T)
+	assert.Equal(t, 1500*time.Millisecond, row.Timeout)
+	if assert.NotNil(t, row.Retry) {
+		assert.Equal(t, 3*time.Second, *row.Retry)
+	}
+
+	row2 := durationRow{T: row.T, Timeout: 250 * time.Millisecond}
+	err = db2.Insert("durations", &row2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var row3 durationRow
+	err = db2.Query(&row3, "SELECT * FROM durations WHERE id = ?", row2.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 250*time.Millisecond, row3.Timeout)
+		assert.Nil(t, row3.Retry)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	err := db.Exec("CREATE TABLE blobs(id INTEGER PRIMARY KEY, data BLOB)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = db.Exec("INSERT INTO blobs(id) VALUES (1), (2)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data := make([]byte, 2*blobChunkSize+100)
+	for idx := range data {
+		data[idx] = byte(idx % 251)
+	}
+
+	ctx := context.Background()
+	ref := BlobRef{Table: "blobs", Column: "data", PK: 1}
+	n, err := db.InsertBlob(ctx, ref, bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(len(data)), n)
+
+	var buf bytes.Buffer
+	n, err = db.ReadBlob(ctx, ref, &buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(data)), n)
+		assert.True(t, bytes.Equal(data, buf.Bytes()))
+	}
+
+	// NULL blob
+	buf.Reset()
+	n, err = db.ReadBlob(ctx, BlobRef{Table: "blobs", Column: "data", PK: 2}, &buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), n)
+	}
+
+	_, err = db.InsertBlob(ctx, BlobRef{Table: "blobs", Column: "data", PK: 3}, bytes.NewReader(data))
+	assert.Equal(t, ErrQueryReturnedZeroRows, err)
+}
+
+func TestLargeObject(t *testing.T) {
+	ctx := context.Background()
+
+	// large objects are Postgres only
+	_, err := db.CreateLargeObject(ctx)
+	assert.ErrorContains(t, err, "Unsupported driver")
+
+	pg := *db
+	pg.Driver = POSTGRES
+	_, err = pg.OpenLargeObject(ctx, 1, LargeObjectRead)
+	assert.ErrorContains(t, err, "need a transaction")
+
+	var _ io.ReadWriteSeeker = &LargeObject{}
+}
+
+func TestPrefixNilPointer(t *testing.T) {
+	err := db.Exec(`CREATE TABLE nilauthor(id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE nilbook(id INTEGER PRIMARY KEY, title TEXT, author_id INTEGER);
+		INSERT INTO nilauthor VALUES (1, 'Tolkien');
+		INSERT INTO nilbook VALUES (1, 'The Hobbit', 1), (2, 'Anonymous', NULL)`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	type author struct {
+		ID   int64  `db:"id,pk"`
+		Name string `db:"name"`
+	}
+	type bookWithAuthor struct {
+		ID     int64   `db:"id,pk"`
+		Title  string  `db:"title"`
+		Author *author `db:"author,prefix"`
+	}
+
+	var books []bookWithAuthor
+	err = db.Query(&books, `SELECT b.id, b.title, a.id AS author_id, a.name AS author_name
+		FROM nilbook b LEFT JOIN nilauthor a ON a.id = b.author_id ORDER BY b.id`)
+	if !assert.NoError(t, err) || !assert.Len(t, books, 2) {
+		return
+	}
+	if assert.NotNil(t, books[0].Author) {
+		assert.Equal(t, "Tolkien", books[0].Author.Name)
+	}
+	assert.Nil(t, books[1].Author)
+
+	// Nested fields are not written
+	err = db.Update("nilbook", books[1])
+	assert.NoError(t, err)
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	err := db.Exec("CREATE TABLE retention(id INTEGER PRIMARY KEY, created_at DATETIME)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for idx := 0; idx < 25; idx++ {
+		err = db.Exec("INSERT INTO retention(created_at) VALUES (?)", now.Add(-time.Duration(idx)*24*time.Hour))
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	db2 := *db
+	db2.Clock = &fakeClock{now: now.Add(-time.Second)}
+
+	batches := 0
+	deleted, err := db2.Retention(context.Background(), RetentionSpec{
+		Table:     "retention",
+		Column:    "created_at",
+		OlderThan: 10*24*time.Hour - time.Hour,
+		BatchSize: 4,
+		Progress: func(deleted int64) {
+			batches++
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(15), deleted)
+	assert.Equal(t, 4, batches)
+
+	var count int
+	err = db.Query(&count, "SELECT COUNT(*) FROM retention")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, count)
+	}
+}
+
+func TestExplainCall(t *testing.T) {
+	type row struct {
+		ID   int64  `db:"id,pk,omitempty"`
+		Name string `db:"name"`
+	}
+
+	stmts := ExplainCall(func(db *DB) {
+		db.Update("t", &row{ID: 5, Name: "henk"})
+		db.ExecTX(context.Background(), func(tx *DB) error {
+			return tx.Exec("DELETE FROM @ WHERE id IN ?", "t", []int64{1, 2})
+		})
+	})
+
+	assert.Equal(t, []Statement{
+		{SQL: `UPDATE "t" SET "name"=$1 WHERE "id"=$2`, Args: []interface{}{"henk", int64(5)}},
+		{SQL: "BEGIN"},
+		{SQL: `DELETE FROM "t" WHERE id IN ($1,$2)`, Args: []interface{}{int64(1), int64(2)}},
+		{SQL: "COMMIT"},
+	}, stmts)
+
+	stmts = ExplainCall(func(db *DB) {
+		db.CaptureCommitTokens = true
+		db.ExecTX(context.Background(), func(tx *DB) error {
+			return tx.Exec("DELETE FROM @", "t")
+		})
+	})
+	assert.Equal(t, Statement{SQL: "SELECT pg_current_wal_lsn()::text"}, stmts[len(stmts)-1])
+}
+
+func TestScanConcurrently(t *testing.T) {
+	type row struct {
+		ID int64 `db:"id,pk"`
+	}
+
+	err := db.Exec("CREATE TABLE concurrent(id INTEGER PRIMARY KEY)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for idx := 1; idx <= 50; idx++ {
+		err = db.Exec("INSERT INTO concurrent(id) VALUES (?)", idx)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	var (
+		mtx     sync.Mutex
+		sum     int64
+		cnt     int
+		workers = map[int]bool{}
+	)
+	err = db.ScanConcurrently(context.Background(), "SELECT * FROM concurrent WHERE id % 2 = ?", []interface{}{0}, 3,
+		func(worker int, rows *sql.Rows) error {
+			var chunk []row
+			err := Scan(&chunk, rows)
+			if err != nil {
+				return err
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			workers[worker] = true
+			for _, r := range chunk {
+				sum += r.ID
+				cnt++
+			}
+			return nil
+		})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 25, cnt)
+	assert.Equal(t, int64(650), sum)
+	assert.Len(t, workers, 3)
+
+	errStop := errors.New("stop")
+	err = db.ScanConcurrently(context.Background(), "SELECT id FROM concurrent", nil, 2,
+		func(worker int, rows *sql.Rows) error {
+			return errStop
+		})
+	assert.ErrorIs(t, err, errStop)
+
+	err = db.ExecTX(context.Background(), func(tx *DB) error {
+		return tx.ScanConcurrently(context.Background(), "SELECT id FROM concurrent", nil, 2,
+			func(worker int, rows *sql.Rows) error {
+				return nil
+			})
+	})
+	assert.Error(t, err)
+}
+
+func TestMaxPlaceholder(t *testing.T) {
+	db2, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db2.Close()
+	assert.Equal(t, 32766, db2.MaxPlaceholder)
+
+	sqlS, args, err := db2.replaceArgs("SELECT * FROM test WHERE b = ? AND a IN ?", "henk", []int64{1, 2, 3})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SELECT * FROM test WHERE b = ? AND a IN (?,?,?)", sqlS)
+		assert.Len(t, args, 4)
+	}
+
+	sqlS, args, err = db2.WithMaxPlaceholder(3).replaceArgs("SELECT * FROM test WHERE b = ? AND a IN ?", "henk", []int64{1, 2, 3})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SELECT * FROM test WHERE b = ? AND a IN (1,2,3)", sqlS)
+		assert.Len(t, args, 1)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ro := db.ReadOnly()
+
+	var count int
+	err := ro.Query(&count, "SELECT COUNT(*) FROM test")
+	assert.NoError(t, err)
+
+	err = ro.Exec("DELETE FROM test WHERE a = ?", -1)
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	err = ro.Insert("test", &testRow{B: "read-only"})
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	err = ro.ExecTX(context.Background(), func(tx *DB) error {
+		return tx.Exec("DELETE FROM test WHERE a = ?", -1)
+	})
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	// COPY runs in its own write transaction on Postgres
+	pg := *ro
+	pg.Driver = POSTGRES
+	_, err = pg.CopyFrom("test", []string{"b"}, func() ([]interface{}, bool) {
+		t.Error("next called on read-only handle")
+		return nil, false
+	})
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestQueryScalar(t *testing.T) {
+	ctx := context.Background()
+
+	n, err := db.QueryInt64(ctx, "SELECT COUNT(*) FROM test WHERE a <= ?", 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(3), n)
+	}
+
+	s, err := db.QueryString(ctx, "SELECT ?", "henk")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "henk", s)
+	}
+
+	s, err = db.QueryString(ctx, "SELECT NULL")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "", s)
+	}
+
+	b, err := db.QueryBool(ctx, "SELECT 1 = 1")
+	if assert.NoError(t, err) {
+		assert.True(t, b)
+	}
+
+	tm, err := db.QueryTime(ctx, "SELECT '2024-06-01T10:00:00Z'")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), tm.UTC())
+	}
+
+	_, err = db.QueryInt64(ctx, "SELECT a FROM test WHERE a = ?", -1)
+	assert.ErrorIs(t, err, ErrQueryReturnedZeroRows)
+}
+
+func TestStructInfoCache(t *testing.T) {
+	type row struct {
+		A int64  `db:"a"`
+		B string `db:"b"`
+	}
+
+	ResetStructInfoCache()
+	stats := GetStructInfoCacheStats()
+	assert.Equal(t, 0, stats.Types)
+
+	rt := reflect.TypeOf(row{})
+	getStructInfo(rt)
+	getStructInfo(rt)
+
+	stats = GetStructInfoCacheStats()
+	assert.Equal(t, 1, stats.Types)
+	assert.Equal(t, 2, stats.Fields)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	ResetStructInfoCache()
+	stats2 := GetStructInfoCacheStats()
+	assert.Equal(t, 0, stats2.Types)
+	assert.Equal(t, stats.Generation+1, stats2.Generation)
+}
+
+func TestPaginate(t *testing.T) {
+	assert.Equal(t, "SELECT a FROM test", db.Paginate("SELECT a FROM test"))
+	assert.Equal(t, "SELECT a FROM test LIMIT 10 OFFSET 20", db.Paginate("SELECT a FROM test;", Limit(10), Offset(20)))
+	assert.Equal(t, "SELECT a FROM test LIMIT -1 OFFSET 2", db.Paginate("SELECT a FROM test", Offset(2)))
+
+	pg := *db
+	pg.Driver = POSTGRES
+	assert.Equal(t, "SELECT a FROM test OFFSET 2", pg.Paginate("SELECT a FROM test", Offset(2)))
+
+	other := *db
+	other.Driver = "sqlserver"
+	assert.Equal(t, "SELECT a FROM test ORDER BY a OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY",
+		other.Paginate("SELECT a FROM test ORDER BY a", Limit(10), Offset(20)))
+
+	var as []int64
+	err := db.Query(&as, db.Paginate("SELECT a FROM test ORDER BY a", Limit(2), Offset(1)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int64{2, 3}, as)
+	}
+}
+
+func TestWarningHook(t *testing.T) {
+	type row struct {
+		A int64  `db:"a,pk"`
+		B string `db:"b,omitempty"`
+		C string `db:"c,omitempty"`
+	}
+
+	var warnings []Warning
+	db2 := db.WithMaxPlaceholder(2)
+	db2.WarningHook = func(w Warning) {
+		warnings = append(warnings, w)
+	}
+
+	var as []int64
+	err := db2.Query(&as, "SELECT a FROM test WHERE a IN ?", []int64{1, 2, 3})
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = db2.Update("test", &row{A: 1, B: "foo"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, warnings, 2) {
+		assert.Equal(t, WarningLiteralFallback, warnings[0].Kind)
+		assert.Equal(t, "SELECT a FROM test WHERE a IN ?", warnings[0].SQL)
+		assert.Equal(t, WarningOmitEmpty, warnings[1].Kind)
+		assert.Equal(t, "UPDATE test: empty columns not updated: c", warnings[1].Message)
+	}
+
+	type hiddenRow struct {
+		A int64  `db:"a"`
+		b string `db:"b"`
+	}
+	warnings = nil
+	var hidden []hiddenRow
+	err = db2.Query(&hidden, "SELECT a, b FROM test WHERE a = 1")
+	if assert.NoError(t, err) && assert.Len(t, warnings, 1) {
+		assert.Equal(t, WarningUnexported, warnings[0].Kind)
+		assert.Equal(t, "Skipping unexported fields of sqlpro.hiddenRow: b", warnings[0].Message)
+		assert.Equal(t, "", hidden[0].b)
+	}
+}
+
+func TestExecResult(t *testing.T) {
+	res, err := db.ExecResult(context.Background(), "INSERT INTO test (b) VALUES (?)", "result")
+	if !assert.NoError(t, err) {
+		return
+	}
+	id, err := res.LastInsertId()
+	if assert.NoError(t, err) {
+		assert.Greater(t, id, int64(0))
+	}
+	n, err := res.RowsAffected()
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), n)
+	}
+
+	_, err = db.ReadOnly().ExecResult(context.Background(), "DELETE FROM test WHERE a = ?", id)
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+// flakyConnector returns connections failing the first query with a
+// connection reset
+type flakyConnector struct {
+	queries atomic.Int64
+}
+
+func (fc *flakyConnector) Connect(context.Context) (driver.Conn, error) { return flakyConn{fc}, nil }
+func (fc *flakyConnector) Driver() driver.Driver                        { return nil }
+
+type flakyConn struct{ fc *flakyConnector }
+
+func (c flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c flakyConn) Close() error                        { return nil }
+func (c flakyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c flakyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.fc.queries.Add(1) == 1 {
+		return nil, syscall.ECONNRESET
+	}
+	return &oneRow{}, nil
+}
+
+type oneRow struct{ done bool }
+
+func (r *oneRow) Columns() []string { return []string{"n"} }
+func (r *oneRow) Close() error      { return nil }
+func (r *oneRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+func TestReadRetries(t *testing.T) {
+	fc := &flakyConnector{}
+	flaky := New(sql.OpenDB(fc))
+
+	var n int64
+	err := flaky.Query(&n, "SELECT 42")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(42), n)
+	}
+	assert.Equal(t, int64(2), fc.queries.Load())
+
+	fc.queries.Store(0)
+	flaky.ReadRetries = 0
+	err = flaky.Query(&n, "SELECT 42")
+	assert.True(t, IsConnectionError(err))
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	limited := db.MaxConcurrentQueries(1)
+	limited.ThrottleTimeout = 10 * time.Millisecond
+
+	release, err := limited.acquireSlot(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var count int
+	err = limited.Query(&count, "SELECT COUNT(*) FROM test")
+	assert.ErrorIs(t, err, ErrThrottled)
+
+	release()
+	err = limited.Query(&count, "SELECT COUNT(*) FROM test")
+	assert.NoError(t, err)
+
+	// callbacks can run statements on the same handle
+	var id int64
+	err = limited.QueryEach(&id, func() error {
+		assert.Equal(t, 0, limited.ThrottleStats().Running)
+		var n int
+		return limited.Query(&n, "SELECT COUNT(*) FROM test")
+	}, "SELECT a FROM test")
+	assert.NoError(t, err)
+	for _, err := range Rows[int64](context.Background(), limited, "SELECT a FROM test") {
+		if !assert.NoError(t, err) {
+			break
+		}
+		assert.NoError(t, limited.Query(&count, "SELECT COUNT(*) FROM test"))
+	}
+
+	var rows *sql.Rows
+	err = limited.Query(&rows, "SELECT a FROM test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, limited.ThrottleStats().Running)
+		rows.Close()
+	}
+
+	stats := limited.ThrottleStats()
+	assert.Equal(t, 1, stats.Limit)
+	assert.Equal(t, 0, stats.Running)
+	assert.Equal(t, uint64(1), stats.Throttled)
+	assert.GreaterOrEqual(t, stats.WaitTime, 10*time.Millisecond)
+}
+
+func TestMySQLDialect(t *testing.T) {
+	my := *db
+	my.Driver = MYSQL
+
+	assert.Equal(t, "`we``ird`", my.Esc("we`ird"))
+	assert.Equal(t, `'it''s \\ here'`, my.EscValue(`it's \ here`))
+	assert.Equal(t, "1", my.EscValueForInsert(true, nil))
+	assert.Equal(t, "'2024-06-01 08:00:00.5'",
+		my.EscValueForInsert(time.Date(2024, 6, 1, 10, 0, 0, 500000000, time.FixedZone("CEST", 7200)), nil))
+	upsert, err := my.upsertClause([]string{"a"}, []string{"b"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ON DUPLICATE KEY UPDATE `b` = VALUES(`b`)", upsert)
+	}
+	assert.Equal(t, "SELECT a FROM test LIMIT 18446744073709551615 OFFSET 5", my.Paginate("SELECT a FROM test", Offset(5)))
+	assert.Equal(t, "UPDATE `t` JOIN (SELECT 1 AS `id`,'x' AS `b`\nUNION ALL SELECT 2,'y') AS v ON `t`.`id`=v.`id` SET `t`.`b`=v.`b`",
+		my.dialect().UpdateFrom("`t`", []string{"id", "b"}, [][]string{{"1", "'x'"}, {"2", "'y'"}}, []string{"b"}, []string{"id"}))
+
+	upsert, err = db.upsertClause([]string{"a"}, []string{"b"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, `ON CONFLICT ("a") DO UPDATE SET "b" = excluded."b"`, upsert)
+	}
+}
+
+func TestMSSQLDialect(t *testing.T) {
+	ms := *db
+	ms.Driver = MSSQL
+	ms.PlaceholderMode = AT
+	ms.MaxPlaceholder = 2100
+
+	assert.Equal(t, "[we]]ird]", ms.Esc("we]ird"))
+	assert.Equal(t, "0", ms.EscValueForInsert(false, &fieldInfo{}))
+	assert.Equal(t, "UPDATE [t] SET [b]=v.[b] FROM [t] JOIN (VALUES (1,'x')) AS v([id],[b]) ON [t].[id]=v.[id]",
+		ms.dialect().UpdateFrom("[t]", []string{"id", "b"}, [][]string{{"1", "'x'"}}, []string{"b"}, []string{"id"}))
+
+	// no ON CONFLICT on SQL Server
+	_, err := ms.upsertClause([]string{"a"}, []string{"b"})
+	assert.Error(t, err)
+	_, err = ms.RunCheckpointJob(context.Background(), CheckpointJob{Name: "x", Table: "test", Process: func(tx *DB, pks []int64) error { return nil }})
+	assert.ErrorContains(t, err, "Not supported")
+
+	sqlS, args, err := ms.replaceArgs("SELECT * FROM @ WHERE a = ? AND b IN ?", "test", 1, []string{"x", "y"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SELECT * FROM [test] WHERE a = @p1 AND b IN (@p2,@p3)", sqlS)
+		assert.Len(t, args, 3)
+	}
+
+	type row struct {
+		B string `db:"b"`
+	}
+	values, info, err := ms.valuesFromStruct(row{B: "x"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sqlS, _, err = ms.insertClauseFromValues("test", values, info, "OUTPUT INSERTED.[a]")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "INSERT INTO [test] ([b]) OUTPUT INSERTED.[a] VALUES(?)", sqlS)
+	}
+
+	assert.Equal(t, "SELECT TOP 5 a FROM test", ms.Paginate("SELECT a FROM test", Limit(5)))
+	assert.Equal(t, "select distinct TOP 5 a FROM test", ms.Paginate("select distinct a FROM test", Limit(5)))
+	assert.Equal(t, "SELECT a FROM test ORDER BY a OFFSET 10 ROWS FETCH NEXT 5 ROWS ONLY",
+		ms.Paginate("SELECT a FROM test ORDER BY a", Limit(5), Offset(10)))
+}
+
+func TestSetDebug(t *testing.T) {
+	db2 := *db
+	db2.Debug = false
+	db2.DebugExec = false
+	db2.DebugQuery = false
+
+	db2.SetDebug(INSERT | QUERY)
+	assert.True(t, db2.debug(INSERT))
+	assert.True(t, db2.debug(QUERY))
+	assert.False(t, db2.debug(UPDATE))
+	assert.False(t, db2.debug(ERROR))
+
+	assert.Equal(t, DebugLevel(INSERT), execDebugLevel(" insert into test (b) VALUES (?)"))
+	assert.Equal(t, DebugLevel(UPDATE), execDebugLevel("UPDATE test SET b = ?"))
+	assert.Equal(t, DebugLevel(EXEC), execDebugLevel("DELETE FROM test"))
+
+	db2.SetDebug(0)
+	db2.DebugExec = true
+	assert.True(t, db2.debug(UPDATE))
+	assert.False(t, db2.debug(QUERY_DUMP))
+
+	db2.SetDebug(PANIC)
+	assert.Panics(t, func() {
+		db2.Query(&[]int64{}, "SELECT * FROM missing_table")
+	})
+}
+
+func TestScanTable(t *testing.T) {
+	var tbl Table
+	err := db.Query(&tbl, "SELECT c AS z, a, b FROM test WHERE a <= ? ORDER BY a", 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"z", "a", "b"}, tbl.Columns())
+	if assert.Len(t, tbl.Rows, 2) {
+		assert.Equal(t, "1", tbl.Rows[0][1])
+		assert.Equal(t, "1", tbl.Maps()[0]["a"])
+	}
+
+	var buf bytes.Buffer
+	err = tbl.WriteCSV(&buf)
+	if assert.NoError(t, err) {
+		assert.True(t, strings.HasPrefix(buf.String(), "z,a,b\n"))
+		assert.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), 3)
+	}
+}
+
+// blockingConnector returns connections blocking queries until release is
+// closed
+type blockingConnector struct {
+	queries atomic.Int64
+	release chan struct{}
+}
+
+func (bc *blockingConnector) Connect(context.Context) (driver.Conn, error) {
+	return blockingConn{bc}, nil
+}
+func (bc *blockingConnector) Driver() driver.Driver { return nil }
+
+type blockingConn struct{ bc *blockingConnector }
+
+func (c blockingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c blockingConn) Close() error                        { return nil }
+func (c blockingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c blockingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.bc.queries.Add(1)
+	<-c.bc.release
+	return &oneRow{}, nil
+}
+
+func TestSingleflight(t *testing.T) {
+	bc := &blockingConnector{release: make(chan struct{})}
+	sf := New(sql.OpenDB(bc)).Singleflight()
+
+	results := make([][]int64, 3)
+	var wg sync.WaitGroup
+	for idx := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := sf.Query(&results[idx], "SELECT n FROM config WHERE key = ?", "a")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// wait until the other callers wait for the first
+	for {
+		sf.flights.mtx.Lock()
+		dups := 0
+		for _, call := range sf.flights.calls {
+			dups = call.dups
+		}
+		sf.flights.mtx.Unlock()
+		if dups == len(results)-1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(bc.release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), bc.queries.Load())
+	for _, res := range results {
+		assert.Equal(t, []int64{42}, res)
+	}
+	results[0][0] = 1
+	assert.Equal(t, int64(42), results[1][0])
+
+	a, b := int64(1), int64(1)
+	var target []int64
+	keyA, ok := flightKey(&target, "SELECT", []interface{}{&a, []string{"x", "y"}})
+	assert.True(t, ok)
+	keyB, _ := flightKey(&target, "SELECT", []interface{}{&b, []string{"x", "y"}})
+	assert.Equal(t, keyA, keyB)
+	b = 2
+	keyB, _ = flightKey(&target, "SELECT", []interface{}{&b, []string{"x", "y"}})
+	assert.NotEqual(t, keyA, keyB)
+	keyB, _ = flightKey(&target, "SELECT", []interface{}{&a, []string{"x,y"}})
+	assert.NotEqual(t, keyA, keyB)
+	_, ok = flightKey(&target, "SELECT", []interface{}{struct{ p *int64 }{&a}})
+	assert.False(t, ok)
+}
+
+func TestEnsureRows(t *testing.T) {
+	type seedRow struct {
+		ID    int64  `db:"id,pk,omitempty"`
+		Code  string `db:"code"`
+		Label string `db:"label"`
+	}
+
+	err := db.Exec("CREATE TABLE seed (id INTEGER PRIMARY KEY, code TEXT UNIQUE, label TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	rows := []seedRow{{Code: "draft", Label: "Draft"}, {Code: "done", Label: "Done"}}
+
+	inserted, updated, err := db.EnsureRows(context.Background(), "seed", rows, []string{"code"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), inserted)
+	assert.Equal(t, int64(0), updated)
+
+	err = db.Exec("UPDATE seed SET label = 'Drifted' WHERE code = 'done'")
+	assert.NoError(t, err)
+
+	inserted, updated, err = db.EnsureRows(context.Background(), "seed", rows, []string{"code"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), inserted)
+	assert.Equal(t, int64(0), updated)
+
+	inserted, updated, err = db.EnsureRows(context.Background(), "seed", rows, []string{"code"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), inserted)
+	assert.Equal(t, int64(1), updated)
+
+	var label string
+	err = db.Query(&label, "SELECT label FROM seed WHERE code = 'done'")
+	assert.NoError(t, err)
+	assert.Equal(t, "Done", label)
+
+	inserted, updated, err = db.EnsureRows(context.Background(), "seed", rows, []string{"code"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), inserted)
+	assert.Equal(t, int64(0), updated)
+}
+
+type backtickDialect struct {
+	standardDialect
+}
+
+func (backtickDialect) Driver() string {
+	return "backtick"
+}
+
+func (backtickDialect) EscIdent(name string) string {
+	return "`" + name + "`"
+}
+
+func (backtickDialect) Paginate(query string, limit, offset int64) string {
+	return fmt.Sprintf("%s LIMIT %d, %d", query, offset, limit)
+}
+
+func TestRegisterDialect(t *testing.T) {
+	RegisterDialect("backtick", backtickDialect{})
+	assert.Panics(t, func() {
+		RegisterDialect("backtick", backtickDialect{})
+	})
+
+	bt := *db
+	bt.Driver = "backtick"
+	assert.Equal(t, "`a`", bt.Esc("a"))
+	assert.Equal(t, "SELECT a FROM test LIMIT 20, 10", bt.Paginate("SELECT a FROM test", Limit(10), Offset(20)))
+
+	_, err := Open("unknown", "")
+	assert.Error(t, err)
+}
+
+func TestOpenDB(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	mem, err := OpenDB("sqlite3", conn)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mem.Close()
+
+	assert.Equal(t, dbDriver(SQLITE3), mem.Driver)
+	assert.Greater(t, mem.MaxPlaceholder, 100)
+
+	var n int64
+	err = mem.Query(&n, "SELECT 1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = OpenDB("unknown", conn)
+	assert.Error(t, err)
+	assert.False(t, mem.pgx)
+}
+
+func TestOpenPgx(t *testing.T) {
+	// the pgx driver is not registered in the tests
+	_, err := OpenPgx(context.Background(), "postgres://localhost/test")
+	assert.ErrorContains(t, err, "jackc/pgx/v5/stdlib")
+
+	// no COPY for pgx handles
+	pgxDB := *db
+	pgxDB.Driver = POSTGRES
+	pgxDB.pgx = true
+	_, err = pgxDB.CopyFrom("test", []string{"a"}, func() ([]interface{}, bool) { return nil, false })
+	assert.ErrorContains(t, err, "pgx")
+
+	assert.True(t, IsRetryableTxError(fmt.Errorf("commit: %w", stateErr("40001"))))
+	assert.False(t, IsRetryableTxError(stateErr("23505")))
+}
+
+// stateErr is an error with a SQLSTATE like the errors of pgx
+type stateErr string
+
+func (e stateErr) Error() string    { return "ERROR (SQLSTATE " + string(e) + ")" }
+func (e stateErr) SQLState() string { return string(e) }
+
+func TestSQLiteKey(t *testing.T) {
+	dsn, key, ok, err := splitSQLiteKey("data.db?_busy_timeout=1000&_key=it's")
+	if assert.NoError(t, err) {
+		assert.True(t, ok)
+		assert.Equal(t, "data.db?_busy_timeout=1000", dsn)
+		assert.Equal(t, "it's", key)
+	}
+
+	// plain SQLite ignores PRAGMA key and rekey
+	enc, err := Open("sqlite3", filepath.Join(t.TempDir(), "enc.db")+"?_key=secret")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer enc.Close()
+
+	err = enc.Exec("CREATE TABLE secret (a TEXT)")
+	assert.NoError(t, err)
+	err = enc.Rekey(context.Background(), "new'secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "new'secret", enc.sqliteConn.key)
+
+	var n int64
+	err = enc.Query(&n, "SELECT count(*) FROM secret")
+	assert.NoError(t, err)
+
+	assert.Error(t, db.Rekey(context.Background(), "x"))
+
+	opt, err := Open("sqlite3", filepath.Join(t.TempDir(), "opt.db"), WithSQLiteKey("secret"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer opt.Close()
+	assert.Equal(t, "secret", opt.sqliteConn.getKey())
+	assert.NoError(t, opt.Rekey(context.Background(), "other"))
+}
+
+func TestDuckDBDialect(t *testing.T) {
+	duck := *db
+	duck.Driver = DUCKDB
+
+	assert.Equal(t, `"we""ird"`, duck.Esc(`we"ird`))
+	assert.Equal(t, "TRUE", duck.EscValueForInsert(true, nil))
+	assert.Equal(t, "SELECT a FROM test OFFSET 5", duck.Paginate("SELECT a FROM test", Offset(5)))
+	upsert, err := duck.upsertClause([]string{"a"}, []string{"b"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, `ON CONFLICT ("a") DO UPDATE SET "b" = excluded."b"`, upsert)
+	}
+	assert.True(t, lookupDialect("duckdb").Returning())
+}
+
+// fakeAppender records the appended rows
+type fakeAppender struct {
+	table  string
+	rows   [][]driver.Value
+	closed bool
+}
+
+func (fa *fakeAppender) AppendRow(args ...driver.Value) error {
+	fa.rows = append(fa.rows, append([]driver.Value(nil), args...))
+	return nil
+}
+
+func (fa *fakeAppender) Close() error {
+	fa.closed = true
+	return nil
+}
+
+func TestDuckDBAppender(t *testing.T) {
+	duck := *db
+	duck.Driver = DUCKDB
+
+	var app *fakeAppender
+	WithAppender(func(conn driver.Conn, schema, table string) (Appender, error) {
+		app = &fakeAppender{table: table}
+		return app, nil
+	})(&duck)
+
+	err := duck.Exec("CREATE TABLE appended (a INTEGER, b TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer duck.Exec("DROP TABLE appended")
+
+	type appendedRow struct {
+		A int64   `db:"a"`
+		B *string `db:"b"`
+	}
+	b := "b"
+	err = duck.InsertBulk("appended", []appendedRow{{A: 1, B: &b}, {A: 2}})
+	if assert.NoError(t, err) && assert.NotNil(t, app) {
+		assert.Equal(t, "appended", app.table)
+		assert.Equal(t, [][]driver.Value{{int64(1), "b"}, {int64(2), nil}}, app.rows)
+		assert.True(t, app.closed)
+	}
+
+	// rows missing a column are inserted
+	app = nil
+	type partialRow struct {
+		A int64 `db:"a"`
+	}
+	err = duck.InsertBulk("appended", []partialRow{{A: 3}})
+	assert.NoError(t, err)
+	assert.Nil(t, app)
+
+	var n int64
+	err = duck.Query(&n, "SELECT count(*) FROM appended")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestSQLiteReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baked.db")
+	rw, err := Open("sqlite3", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = rw.Exec("CREATE TABLE baked (a INTEGER)")
+	assert.NoError(t, err)
+	err = rw.Exec("INSERT INTO baked (a) VALUES (1)")
+	assert.NoError(t, err)
+	rw.Close()
+
+	for _, param := range []string{"mode=ro", "immutable=1"} {
+		ro, err := Open("sqlite3", path+"?"+param)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var n int64
+		err = ro.Query(&n, "SELECT count(*) FROM baked")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+		assert.ErrorIs(t, ro.Exec("INSERT INTO baked (a) VALUES (2)"), ErrReadOnly)
+
+		problems, err := ro.IntegrityCheck(context.Background(), param == "mode=ro")
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+		ro.Close()
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	var buf bytes.Buffer
+	opt, err := Open("sqlite3", ":memory:",
+		WithMaxOpenConns(1),
+		WithConnMaxLifetime(time.Minute),
+		WithDefaultQueryTimeout(20*time.Millisecond),
+		WithLogger(log.New(&buf, "", 0)),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer opt.Close()
+
+	assert.Equal(t, 1, opt.DB().Stats().MaxOpenConnections)
+
+	opt.SetDebug(EXEC)
+	err = opt.Exec("CREATE TABLE opts (a INTEGER)")
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "CREATE TABLE opts")
+
+	var n int64
+	err = opt.Query(&n, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err = opt.QueryContext(ctx, &n, "SELECT 1")
+	assert.NoError(t, err)
+}
+
+func TestOpenCluster(t *testing.T) {
+	dir := t.TempDir()
+	primaryDSN := filepath.Join(dir, "primary.db")
+	replicaDSN := filepath.Join(dir, "replica.db")
+	for _, dsn := range []string{primaryDSN, replicaDSN} {
+		setup, err := Open("sqlite3", dsn)
+		if !assert.NoError(t, err) {
+			return
+		}
+		err = setup.Exec("CREATE TABLE node (name TEXT)")
+		assert.NoError(t, err)
+		err = setup.Exec("INSERT INTO node (name) VALUES (?)", filepath.Base(dsn))
+		assert.NoError(t, err)
+		setup.Close()
+	}
+
+	cl, err := OpenCluster("sqlite3", primaryDSN, []string{replicaDSN})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cl.Close()
+
+	var name string
+	err = cl.Query(&name, "SELECT name FROM node")
+	assert.NoError(t, err)
+	assert.Equal(t, "replica.db", name)
+
+	err = cl.Primary().Query(&name, "SELECT name FROM node")
+	assert.NoError(t, err)
+	assert.Equal(t, "primary.db", name)
+
+	cl.ApplyConfig(Config{InspectMaxRows: 7})
+	assert.Equal(t, 7, cl.replicas.replicas[0].inspectMaxRows())
+	cl.ResetConfig()
+
+	// the replicas use the settings of the handle
+	var buf bytes.Buffer
+	logged := *cl
+	logged.Logger = log.New(&buf, "", 0)
+	logged.SetDebug(QUERY)
+	err = logged.Query(&name, "SELECT name FROM node")
+	assert.NoError(t, err)
+	assert.Equal(t, "replica.db", name)
+	assert.Contains(t, buf.String(), "SELECT name FROM node")
+
+	assert.True(t, replicaQuery("SELECT * FROM job WHERE status = 'open'"))
+	assert.False(t, replicaQuery("SELECT * FROM job FOR UPDATE SKIP LOCKED"))
+	assert.False(t, replicaQuery("select * from job for no key update"))
+	assert.False(t, replicaQuery("SELECT nextval('job_id_seq')"))
+	assert.False(t, replicaQuery("SELECT pg_try_advisory_lock(1)"))
+	assert.False(t, replicaQuery("SELECT * INTO copy FROM job"))
+
+	tx, err := cl.BeginRead()
+	if assert.NoError(t, err) {
+		err = tx.Query(&name, "SELECT name FROM node")
+		assert.NoError(t, err)
+		assert.Equal(t, "replica.db", name)
+		assert.NoError(t, tx.Rollback())
+	}
+
+	err = cl.ExecTX(context.Background(), func(tx *DB) error {
+		return tx.Query(&name, "SELECT name FROM node")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "primary.db", name)
+
+	// a failing replica is skipped
+	cl.replicas.replicas[0].sqlDB.Close()
+	err = cl.Query(&name, "SELECT name FROM node")
+	assert.NoError(t, err)
+	assert.Equal(t, "primary.db", name)
+	assert.False(t, cl.replicas.downUntil[0].IsZero())
+}
+
+func TestDSN(t *testing.T) {
+	dsn := PostgresDSN{Host: "db", Port: 5433, DB: "app", User: "me", Password: "p@ss/word", SSLMode: "disable"}
+	assert.Equal(t, "postgres://me:p%40ss%2Fword@db:5433/app?sslmode=disable", dsn.String())
+
+	assert.Equal(t, "postgres://me:xxxxx@db:5433/app?sslmode=disable", RedactDSN(dsn.String()))
+	assert.Equal(t, "host=db password=xxxxx dbname=app", RedactDSN(`host=db password='it\'s' dbname=app`))
+	assert.Equal(t, "data.db?_busy_timeout=1000&_key=xxxxx", RedactDSN("data.db?_key=secret&_busy_timeout=1000"))
+	assert.Equal(t, "./test.db?_foreign_keys=1", RedactDSN("./test.db?_foreign_keys=1"))
+
+	enc := *db
+	enc.DSN = "postgres://me:secret@db/app"
+	assert.NotContains(t, enc.SafeDSN(), "secret")
+}
+
+func TestSQLitePragmas(t *testing.T) {
+	lite, err := Open("sqlite3", filepath.Join(t.TempDir(), "pragmas.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer lite.Close()
+
+	err = lite.SQLitePragmas(Pragmas{JournalMode: "wal", BusyTimeout: 2 * time.Second, ForeignKeys: true, Synchronous: "normal"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		journalMode string
+		n           int64
+	)
+	assert.NoError(t, lite.Query(&journalMode, "PRAGMA journal_mode"))
+	assert.Equal(t, "wal", journalMode)
+	assert.NoError(t, lite.Query(&n, "PRAGMA busy_timeout"))
+	assert.Equal(t, int64(2000), n)
+	assert.NoError(t, lite.Query(&n, "PRAGMA foreign_keys"))
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, lite.Query(&n, "PRAGMA synchronous"))
+	assert.Equal(t, int64(1), n)
+
+	assert.Error(t, lite.SQLitePragmas(Pragmas{JournalMode: "wal; DROP TABLE x"}))
+}
+
+func TestApplyConfig(t *testing.T) {
+	h, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer h.Close()
+
+	var warnings []Warning
+	h.WarningHook = func(w Warning) {
+		warnings = append(warnings, w)
+	}
+	ro := h.ReadOnly()
+
+	cfg := h.CurrentConfig()
+	assert.Equal(t, 100, cfg.InspectMaxRows)
+	assert.False(t, ro.debug(EXEC))
+
+	cfg.Debug = EXEC
+	cfg.SlowQueryThreshold = time.Nanosecond
+	cfg.InspectMaxRows = 1
+	h.ApplyConfig(cfg)
+
+	assert.True(t, ro.debug(EXEC))
+	assert.Equal(t, 1, ro.inspectMaxRows())
+
+	var n int64
+	err = ro.Query(&n, "SELECT 1")
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, warnings) {
+		assert.Equal(t, WarningSlowQuery, warnings[0].Kind)
+		assert.Equal(t, "SELECT 1", warnings[0].SQL)
+	}
+
+	// zero fields keep the current values
+	h.TxRetry.Max = 5
+	h.ResetConfig()
+	assert.False(t, ro.debug(EXEC))
+	h.ApplyConfig(Config{Debug: QUERY})
+	cfg = h.CurrentConfig()
+	assert.Equal(t, DebugLevel(QUERY), cfg.Debug)
+	assert.Equal(t, 5, cfg.TxRetry.Max)
+	assert.Equal(t, 100, cfg.InspectMaxRows)
+	h.ApplyConfig(Config{InspectMaxRows: 2})
+	cfg = h.CurrentConfig()
+	assert.Equal(t, DebugLevel(QUERY), cfg.Debug)
+	assert.Equal(t, 2, cfg.InspectMaxRows)
+}
+
+func TestWithSchema(t *testing.T) {
+	h, err := Open("sqlite3", ":memory:", WithMaxOpenConns(1))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer h.Close()
+
+	// SQLite calls attached databases schema
+	err = h.Exec("ATTACH ':memory:' AS tenant_42")
+	assert.NoError(t, err)
+	err = h.Exec("CREATE TABLE tenant_42.items (id INTEGER PRIMARY KEY, name TEXT)")
+	assert.NoError(t, err)
+
+	type item struct {
+		ID   int64  `db:"id,pk,omitempty"`
+		Name string `db:"name"`
+	}
+	tenant := h.WithSchema("tenant_42")
+	it := item{Name: "a"}
+	err = tenant.Insert("items", &it)
+	assert.NoError(t, err)
+	it.Name = "b"
+	err = tenant.Update("items", it)
+	assert.NoError(t, err)
+	err = tenant.InsertBulk("items", []item{{Name: "c"}})
+	assert.NoError(t, err)
+
+	var names []string
+	err = h.Query(&names, "SELECT name FROM tenant_42.items ORDER BY id")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, names)
+}
+
+func TestEscQualified(t *testing.T) {
+	assert.Equal(t, `"public"."my""table"`, db.EscQualified(`public.my"table`))
+	assert.Equal(t, `"test"`, db.EscQualified("test"))
+
+	my := *db
+	my.Driver = MYSQL
+	assert.Equal(t, "`app`.`test`", my.EscQualified("app.test"))
+
+	ms := *db
+	ms.Driver = MSSQL
+	assert.Equal(t, "[dbo].[test]", ms.EscQualified("dbo.test"))
+
+	assert.Equal(t, `"other"."test"`, db.WithSchema("tenant").escTable("other.test"))
+	assert.Equal(t, `"tenant"."test"`, db.WithSchema("tenant").escTable("test"))
+
+	// Insert accepts qualified names, "main" is the default schema of SQLite
+	err := db.Insert("main.test", &testRow{B: "qualified"})
+	assert.NoError(t, err)
+}
+
+type countingAllocator struct {
+	pool  RowPool[testRow]
+	calls int
+}
+
+func (ca *countingAllocator) NewRow(t reflect.Type) interface{} {
+	ca.calls++
+	return ca.pool.NewRow(t)
+}
+
+func TestRowAllocator(t *testing.T) {
+	alloc := &countingAllocator{}
+	pooled := db.WithRowAllocator(alloc)
+
+	var rows []*testRow
+	err := pooled.Query(&rows, "SELECT a, b FROM test ORDER BY a LIMIT 3")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, rows, 3)
+	assert.Equal(t, 3, alloc.calls)
+
+	alloc.pool.Put(rows...)
+	assert.Equal(t, testRow{}, *rows[0])
+
+	for row, err := range Rows[*testRow](context.Background(), pooled, "SELECT a, b FROM test ORDER BY a LIMIT 2") {
+		assert.NoError(t, err)
+		assert.NotZero(t, row.A)
+	}
+	assert.Equal(t, 5, alloc.calls)
+
+	// other types are allocated normally
+	var other []*testRowPtr
+	err = pooled.Query(&other, "SELECT a FROM test LIMIT 1")
+	assert.NoError(t, err)
+	assert.Len(t, other, 1)
+}
+
+func TestUpdateWhere(t *testing.T) {
+	err := db.Exec("CREATE TABLE jobs (id INTEGER PRIMARY KEY, status TEXT, note TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 5; i++ {
+		err = db.Exec("INSERT INTO jobs (status) VALUES ('open')")
+		assert.NoError(t, err)
+	}
+
+	n, err := db.UpdateWhere(context.Background(), "jobs", map[string]interface{}{"status": "done"}, "id IN ?", []int64{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	type jobSet struct {
+		ID     int64  `db:"id,pk,omitempty"`
+		Status string `db:"status"`
+		Note   string `db:"note,omitempty"`
+	}
+	n, err = db.UpdateWhere(context.Background(), "jobs", jobSet{ID: 1, Status: "failed"}, "@ = ?", "status", "open")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	var statuses []string
+	err = db.Query(&statuses, "SELECT status FROM jobs ORDER BY id")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"done", "done", "done", "failed", "failed"}, statuses)
+
+	_, err = db.UpdateWhere(context.Background(), "jobs", map[string]interface{}{"status": "x"}, " ")
+	assert.Error(t, err)
+}
+
+func TestSetLogger(t *testing.T) {
+	sdb, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sdb.Close()
+
+	var buf bytes.Buffer
+	sdb.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	err = sdb.ExecTX(context.Background(), func(tx *DB) error {
+		err := tx.Exec("CREATE TABLE logged (id INTEGER PRIMARY KEY, name TEXT)")
+		if err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO logged (name) VALUES (?)", "a")
+	})
+	assert.NoError(t, err)
+
+	var names []string
+	err = sdb.Query(&names, "SELECT name FROM logged")
+	assert.NoError(t, err)
+
+	err = sdb.PrintQuery("SELECT name FROM logged")
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, `msg="sqlpro begin"`)
+	assert.Contains(t, out, `msg="sqlpro exec" sql="INSERT INTO logged (name) VALUES (?)" args=1`)
+	assert.Contains(t, out, "rows=1 driver=sqlite3 tx=")
+	assert.Contains(t, out, `msg="sqlpro commit"`)
+	assert.Contains(t, out, `msg="sqlpro query" sql="SELECT name FROM logged" args=0`)
+	assert.Contains(t, out, `msg="sqlpro print"`)
+}
+
+func TestDeleteWhere(t *testing.T) {
+	err := db.Exec("CREATE TABLE purge (id INTEGER PRIMARY KEY, status TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, status := range []string{"open", "done", "done", "open", "done"} {
+		err = db.Exec("INSERT INTO purge (status) VALUES (?)", status)
+		assert.NoError(t, err)
+	}
+
+	for _, where := range []string{"", " ", "1=1", "( 1 = 1 )", "TRUE", "'a' = 'a'", "id=id"} {
+		_, err = db.DeleteWhere(context.Background(), "purge", where, DeleteOptions{})
+		assert.Error(t, err, where)
+	}
+
+	n, err := db.DeleteWhere(context.Background(), "purge", "id = ?", DeleteOptions{}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	var ids []int64
+	n, err = db.DeleteWhere(context.Background(), "purge", "status = ?", DeleteOptions{Returning: &ids}, "done")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.ElementsMatch(t, []int64{2, 3, 5}, ids)
+
+	// only the deleted rows are counted, the DELETE runs once with QUERY_DUMP
+	dump := *db
+	dump.DebugQuery = true
+	deletes := 0
+	dump.QueryHook = func(ctx context.Context, info QueryInfo) {
+		if strings.HasPrefix(info.SQL, "DELETE") {
+			deletes++
+		}
+	}
+	ids = []int64{99}
+	n, err = dump.DeleteWhere(context.Background(), "purge", "id = ?", DeleteOptions{Returning: &ids}, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []int64{99, 4}, ids)
+	assert.Equal(t, 1, deletes)
+
+	err = db.Exec("INSERT INTO purge (status) VALUES (?)", "open")
+	assert.NoError(t, err)
+
+	n, err = db.DeleteWhere(context.Background(), "purge", "1=1", DeleteOptions{AllowFullTable: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = db.DeleteWhere(context.Background(), "purge", "id = ?", DeleteOptions{Returning: ids}, 1)
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	mdb, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mdb.Close()
+
+	var calls []string
+	mdb.Use(func(ctx context.Context, info *QueryInfo, next func() error) error {
+		calls = append(calls, "outer "+info.SQL)
+		err := next()
+		if !info.Stop.IsZero() {
+			// the statement was run
+			assert.Equal(t, err, info.Err)
+			assert.False(t, info.Stop.Before(info.Start))
+		}
+		return err
+	})
+	mdb.Use(func(ctx context.Context, info *QueryInfo, next func() error) error {
+		if strings.HasPrefix(info.SQL, "DROP") {
+			return errors.New("no drops")
+		}
+		info.SQL = strings.Replace(info.SQL, "wrong", "logged", 1)
+		return next()
+	})
+
+	err = mdb.Exec("CREATE TABLE logged (id INTEGER PRIMARY KEY)")
+	assert.NoError(t, err)
+	err = mdb.Exec("INSERT INTO wrong (id) VALUES (1)")
+	assert.NoError(t, err)
+
+	var ids []int64
+	err = mdb.Query(&ids, "SELECT id FROM wrong")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, ids)
+
+	err = mdb.Exec("DROP TABLE logged")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{
+		"outer CREATE TABLE logged (id INTEGER PRIMARY KEY)",
+		"outer INSERT INTO wrong (id) VALUES (1)",
+		"outer SELECT id FROM wrong",
+		"outer DROP TABLE logged",
+	}, calls)
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	mdb, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mdb.Close()
+
+	err = mdb.Exec("CREATE TABLE skipped (id INTEGER PRIMARY KEY)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	mdb.Use(func(ctx context.Context, info *QueryInfo, next func() error) error {
+		// skip without error
+		return nil
+	})
+
+	err = mdb.Exec("INSERT INTO skipped (id) VALUES (1)")
+	assert.ErrorIs(t, err, ErrStatementSkipped)
+	err = mdb.Insert("skipped", &struct {
+		ID int64 `db:"id,pk"`
+	}{ID: 2})
+	assert.ErrorIs(t, err, ErrStatementSkipped)
+
+	var ids []int64
+	err = mdb.Query(&ids, "SELECT id FROM skipped")
+	assert.ErrorIs(t, err, ErrStatementSkipped)
+	assert.Empty(t, ids)
+}
+
+func TestAddRewriter(t *testing.T) {
+	rdb, err := Open("sqlite3", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rdb.Close()
+
+	var statements []string
+	rdb.QueryHook = func(ctx context.Context, qi QueryInfo) {
+		statements = append(statements, qi.SQL)
+	}
+	rdb.AddRewriter(func(sql string) string {
+		return strings.ReplaceAll(sql, "tenant.", "tenant_1_")
+	})
+	rdb.AddRewriter(func(sql string) string {
+		return "/* app */ " + sql
+	})
+
+	err = rdb.Exec("CREATE TABLE tenant.items (id INTEGER PRIMARY KEY)")
+	assert.NoError(t, err)
+	err = rdb.Exec("INSERT INTO tenant.items (id) VALUES (?)", 1)
+	assert.NoError(t, err)
+	var ids []int64
+	err = rdb.Query(&ids, "SELECT id FROM tenant.items WHERE id IN ?", []int64{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, ids)
+
+	assert.Equal(t, []string{
+		"/* app */ CREATE TABLE tenant_1_items (id INTEGER PRIMARY KEY)",
+		"/* app */ INSERT INTO tenant_1_items (id) VALUES (?)",
+		"/* app */ SELECT id FROM tenant_1_items WHERE id IN (?,?)",
+	}, statements)
+}
+
+type jobView struct {
+	ID     int64  `db:"id,pk"`
+	Status string `db:"status"`
+}
+
+func (*jobView) ReadOnlyModel() {}
+
+func TestReadOnlyModel(t *testing.T) {
+	err := db.Exec("CREATE TABLE viewed (id INTEGER PRIMARY KEY, status TEXT, note TEXT)")
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = db.Exec("INSERT INTO viewed (id, status, note) VALUES (1, 'open', 'x')")
+	assert.NoError(t, err)
+
+	row := jobView{ID: 1, Status: "done"}
+	assert.ErrorIs(t, db.Insert("viewed", &row), ErrReadOnlyModel)
+	assert.ErrorIs(t, db.Update("viewed", row), ErrReadOnlyModel)
+	assert.ErrorIs(t, db.Save("viewed", &row), ErrReadOnlyModel)
+	assert.ErrorIs(t, db.InsertBulk("viewed", []jobView{row}), ErrReadOnlyModel)
+	assert.ErrorIs(t, db.UpdateBulk("viewed", []*jobView{&row}), ErrReadOnlyModel)
+	assert.ErrorIs(t, db.Insert("viewed", []interface{}{&row}), ErrReadOnlyModel)
+	_, err = db.UpdateWhere(context.Background(), "viewed", row, "id = ?", 1)
+	assert.ErrorIs(t, err, ErrReadOnlyModel)
+
+	var rows []jobView
+	err = db.Query(&rows, "SELECT * FROM viewed")
+	assert.ErrorIs(t, err, ErrUnmappedColumns)
+
+	err = db.Query(&rows, "SELECT id, status FROM viewed")
+	assert.NoError(t, err)
+	assert.Equal(t, []jobView{{ID: 1, Status: "open"}}, rows)
+}
+
+func TestBench(t *testing.T) {
+	bdb, err := Open("sqlite3", filepath.Join(t.TempDir(), "bench.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer bdb.Close()
+
+	for _, w := range StandardWorkloads("bench", 50) {
+		res, err := Bench(context.Background(), bdb, w)
+		if !assert.NoError(t, err, w.Name) {
+			continue
+		}
+		assert.Equal(t, 50, res.N)
+		assert.True(t, res.P50 <= res.P90 && res.P90 <= res.P99 && res.P99 <= res.Max, res.String())
+		assert.NotZero(t, res.AllocsPerOp)
+		t.Log(res)
+	}
+
+	var count int64
+	err = bdb.Query(&count, "SELECT COUNT(*) FROM bench")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), count)
+
+	_, err = Bench(context.Background(), bdb, Workload{Name: "failing", Op: func(ctx context.Context, db *DB, i int) error {
+		return db.Exec("SELECT * FROM missing")
+	}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RetentionSpec describes a cleanup of old rows. The rows are deleted in
+// batches, each in its own statement, with a pause in between, so no long
+// running locks are held on large tables.
+type RetentionSpec struct {
+	Table      string
+	Column     string        // time column, rows with a value before now - OlderThan are deleted
+	OlderThan  time.Duration // now is taken from DB.Clock
+	PrimaryKey string        // primary key column, defaults to "id"
+	BatchSize  int           // defaults to 1000
+	Pause      time.Duration // pause between the batches
+
+	// Progress is called after each batch, it can be <nil>
+	Progress func(deleted int64)
+}
+
+// Retention deletes the rows given in the spec and returns the number of
+// rows deleted.
+func (db *DB) Retention(ctx context.Context, spec RetentionSpec) (int64, error) {
+	var deleted int64
+
+	if spec.Table == "" || spec.Column == "" || spec.OlderThan <= 0 {
+		return 0, fmt.Errorf("Retention: Table, Column and OlderThan are required.")
+	}
+	if spec.PrimaryKey == "" {
+		spec.PrimaryKey = "id"
+	}
+	if spec.BatchSize <= 0 {
+		spec.BatchSize = 1000
+	}
+
+	cutoff := db.now().Add(-spec.OlderThan)
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return deleted, err
+		}
+
+		// the derived table lets MySQL use a limit in the subquery
+		batch := db.Paginate("SELECT @ FROM @ WHERE @ < ? ORDER BY @", Limit(int64(spec.BatchSize)))
+		n, _, err := db.execContext(ctx, "DELETE FROM @ WHERE @ IN (SELECT @ FROM ("+batch+") AS batch)",
+			spec.Table, spec.PrimaryKey, spec.PrimaryKey, spec.PrimaryKey, spec.Table, spec.Column, cutoff, spec.PrimaryKey)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+
+		if spec.Progress != nil {
+			spec.Progress(deleted)
+		}
+
+		if n < int64(spec.BatchSize) {
+			return deleted, nil
+		}
+
+		if spec.Pause > 0 {
+			timer := time.NewTimer(spec.Pause)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return deleted, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
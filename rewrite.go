@@ -0,0 +1,22 @@
+package sqlpro
+
+// Rewriter transforms the SQL of a statement, e.g. to add optimizer hints
+// or comments
+type Rewriter func(sql string) string
+
+// AddRewriter adds rw to the rewriters of the handle. Rewriters are applied
+// in the order they are added to every statement, after the placeholders
+// have been replaced. Copies of the handle made before the call are not
+// affected.
+func (db *DB) AddRewriter(rw Rewriter) {
+	// don't share the backing array with earlier copies
+	db.rewriters = append(db.rewriters[:len(db.rewriters):len(db.rewriters)], rw)
+}
+
+// rewrite applies the rewriters to sqlS
+func (db *DB) rewrite(sqlS string) string {
+	for _, rw := range db.rewriters {
+		sqlS = rw(sqlS)
+	}
+	return sqlS
+}
@@ -0,0 +1,88 @@
+package sqlpro
+
+import (
+	"context"
+	"iter"
+	"reflect"
+)
+
+// Rows runs the query and returns an iterator over the scanned rows. T can be
+// anything Scan accepts for a single row (struct, pointer to struct, scalar).
+// The rows are scanned lazily while ranging:
+//
+//	for row, err := range sqlpro.Rows[User](ctx, db, "SELECT * FROM user") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Errors are yielded once and end the iteration. Breaking out of the loop
+// closes the underlying rows.
+func Rows[T any](ctx context.Context, db *DB, query string, args ...interface{}) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		rows, err := db.queryRows(ctx, query, args...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		// the loop body may run statements, don't hold the slot
+		releaseRows(rows)
+		defer closeRows(rows)
+
+		err = db.checkScanColumns(reflect.TypeOf(zero), rows)
+		if err != nil {
+			yield(zero, db.debugError(err))
+			return
+		}
+
+		cols, err := rows.Columns()
+		if err != nil {
+			yield(zero, db.debugError(err))
+			return
+		}
+		scanner := db.newScanner(reflect.TypeOf(&zero).Elem(), cols)
+
+		for rows.Next() {
+			var row T
+			err = scanner.Scan(&row, rows)
+			if err != nil {
+				yield(zero, db.debugError(err))
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+
+		err = rows.Err()
+		if err != nil {
+			yield(zero, db.debugError(err))
+		}
+	}
+}
+
+// QueryAll runs the query and returns all rows scanned into a slice of T,
+// see Scan for the supported types.
+func QueryAll[T any](ctx context.Context, db *DB, query string, args ...interface{}) ([]T, error) {
+	rows := []T{}
+	err := db.QueryContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// QueryOne runs the query and returns the first row scanned into T. If the
+// query returns no rows, ErrQueryReturnedZeroRows is returned.
+func QueryOne[T any](ctx context.Context, db *DB, query string, args ...interface{}) (T, error) {
+	var row T
+	err := db.QueryContext(ctx, &row, query, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return row, nil
+}
@@ -0,0 +1,99 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SampleColumn describes a column of a SampleResult
+type SampleColumn struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"` // as reported by the driver, e.g. "TEXT" or "INT8"
+	Nullable     *bool  `json:"nullable"`      // <nil> if the driver does not report it
+}
+
+// SampleResult is returned by Sample
+type SampleResult struct {
+	Columns []SampleColumn           `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+}
+
+// sampleMinRows is the estimated table size below which Postgres samples
+// by sorting the whole table
+const sampleMinRows = 10000
+
+// Sample returns n random rows of table. On Postgres, large tables are
+// sampled using TABLESAMPLE BERNOULLI, so only a part of the table is
+// read. Other drivers use ORDER BY random(). []byte values are returned as
+// string.
+func (db *DB) Sample(ctx context.Context, table string, n int) (*SampleResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("Sample: n must be > 0, got %d.", n)
+	}
+
+	query := db.Paginate("SELECT * FROM @ ORDER BY random()", Limit(int64(n)))
+	args := []interface{}{table}
+
+	if db.Driver == POSTGRES {
+		var estimate float64
+		err := db.QueryContext(ctx, &estimate, "SELECT reltuples FROM pg_class WHERE oid = ?::regclass", db.escTable(table))
+		if err != nil {
+			return nil, err
+		}
+		if estimate > sampleMinRows {
+			// sample twice the needed rows to make up for the variance
+			percent := min(100, float64(2*n)*100/estimate)
+			query = db.Paginate("SELECT * FROM @ TABLESAMPLE BERNOULLI(?) ORDER BY random()", Limit(int64(n)))
+			args = []interface{}{table, percent}
+		}
+	}
+
+	var rows *sql.Rows
+	err := db.QueryContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SampleResult{Rows: []map[string]interface{}{}}
+	for _, ct := range colTypes {
+		col := SampleColumn{Name: ct.Name(), DatabaseType: ct.DatabaseTypeName()}
+		if nullable, ok := ct.Nullable(); ok {
+			col.Nullable = &nullable
+		}
+		result.Columns = append(result.Columns, col)
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(colTypes))
+		ptrs := make([]interface{}, len(colTypes))
+		for idx := range values {
+			ptrs[idx] = &values[idx]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, db.debugError(err)
+		}
+		row := make(map[string]interface{}, len(colTypes))
+		for idx, ct := range colTypes {
+			if b, ok := values[idx].([]byte); ok {
+				row[ct.Name()] = string(b)
+			} else {
+				row[ct.Name()] = values[idx]
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, db.debugError(err)
+	}
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The scalar helpers below scan the single column of the first row
+// directly, without the reflection used by Query. NULL returns the zero
+// value, no rows return ErrQueryReturnedZeroRows.
+
+// QueryInt64 returns the int64 selected by query
+func (db *DB) QueryInt64(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var v sql.NullInt64
+	err := db.QueryRowContext(ctx, query, args, &v)
+	return v.Int64, err
+}
+
+// QueryString returns the string selected by query
+func (db *DB) QueryString(ctx context.Context, query string, args ...interface{}) (string, error) {
+	var v sql.NullString
+	err := db.QueryRowContext(ctx, query, args, &v)
+	return v.String, err
+}
+
+// QueryBool returns the bool selected by query
+func (db *DB) QueryBool(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	var v sql.NullBool
+	err := db.QueryRowContext(ctx, query, args, &v)
+	return v.Bool, err
+}
+
+// QueryTime returns the time selected by query. Strings are parsed like
+// in Query, using DB.TimeLayouts.
+func (db *DB) QueryTime(ctx context.Context, query string, args ...interface{}) (time.Time, error) {
+	v := NullTime{layouts: db.TimeLayouts}
+	err := db.QueryRowContext(ctx, query, args, &v)
+	return v.Time, err
+}
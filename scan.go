@@ -2,11 +2,14 @@ package sqlpro
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -17,30 +20,104 @@ func (vs *voidScan) Scan(interface{}) error {
 	return nil
 }
 
+// Scanner scans rows with a fixed set of columns into values of one type.
+// The mapping of the columns to the struct fields is done once, so a
+// Scanner can be reused for streaming many rows or chunks of rows.
+type Scanner struct {
+	t        reflect.Type
+	cols     []string
+	fields   []*fieldInfo // per column for struct types, <nil> for unmapped columns
+	isStruct bool
+	nilPtrs  map[string][]int // column indexes per nested struct pointer, set <nil> if all are NULL
+
+	TimeLayouts []string     // accepted besides RFC3339 when scanning strings into time fields
+	Allocator   RowAllocator // provides the values for <nil> pointer targets, <nil> allocates new ones
+}
+
+// NewScanner returns a Scanner for values of type t (the type target points
+// to in Scanner.Scan), reading rows with the columns cols.
+func NewScanner(t reflect.Type, cols []string) *Scanner {
+	s := &Scanner{t: t, cols: cols}
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct && st != reflect.TypeOf(time.Time{}) {
+		s.isStruct = true
+		info := getStructInfo(st)
+		s.fields = make([]*fieldInfo, len(cols))
+		for idx, col := range cols {
+			s.fields[idx] = info[col]
+		}
+		for _, fi := range info {
+			if fi.path == nil {
+				continue
+			}
+			if f, _ := st.FieldByName(fi.path[0]); f.Type.Kind() == reflect.Ptr {
+				if s.nilPtrs == nil {
+					s.nilPtrs = map[string][]int{}
+				}
+				s.nilPtrs[fi.path[0]] = []int{}
+			}
+		}
+		for idx, fi := range s.fields {
+			if fi != nil && fi.path != nil && s.nilPtrs[fi.path[0]] != nil {
+				s.nilPtrs[fi.path[0]] = append(s.nilPtrs[fi.path[0]], idx)
+			}
+		}
+	}
+	return s
+}
+
+// Scan scans the current row of rows into target, which needs to be a
+// pointer to the type of the Scanner. rows.Next must have been called.
+func (s *Scanner) Scan(target interface{}, rows *sql.Rows) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != s.t {
+		panic(fmt.Errorf("Scanner.Scan: need *%s, got %T", s.t, target))
+	}
+	return s.scanRow(v.Elem(), rows)
+}
+
+// newScanner returns a Scanner using the TimeLayouts and the row allocator
+// of db
+func (db *DB) newScanner(t reflect.Type, cols []string) *Scanner {
+	s := NewScanner(t, cols)
+	s.TimeLayouts = db.TimeLayouts
+	s.Allocator = db.rowAllocator
+	return s
+}
+
+// scanRow scans one row into the given target
+func scanRow(target reflect.Value, rows *sql.Rows, timeLayouts []string) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	s := NewScanner(target.Type(), cols)
+	s.TimeLayouts = timeLayouts
+	return s.scanRow(target, rows)
+}
+
 // scanRow scans one row into the given target
-func scanRow(target reflect.Value, rows *sql.Rows) error {
+func (s *Scanner) scanRow(target reflect.Value, rows *sql.Rows) error {
 	var (
 		err             error
 		cols            []string
 		data            []interface{}
 		targetV, fieldV reflect.Value
-		info            structInfo
 		isSlice         bool
 		isStruct        bool
 	)
 
-	cols, err = rows.Columns()
-	if err != nil {
-		return err
-	}
-
+	cols = s.cols
 	data = make([]interface{}, len(cols))
 
 	if target.Kind() == reflect.Ptr {
 		if target.IsNil() {
 			// nil pointer
 			// if target.Type().Elem().Kind() == reflect.Struct {
-			target.Set(reflect.New(target.Type().Elem()))
+			target.Set(s.newRow(target.Type().Elem()))
 			// }
 		}
 		// log.Printf("Kind: %v", target.Elem().Kind())
@@ -55,8 +132,7 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 
 	switch targetV.Kind() {
 	case reflect.Struct:
-		info = getStructInfo(reflect.ValueOf(targetV.Interface()).Type())
-		isStruct = true
+		isStruct = s.isStruct
 	case reflect.Slice:
 		isSlice = true
 
@@ -78,29 +154,34 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 		}
 	}
 
-	switch targetV.Interface().(type) {
-	case time.Time, *time.Time:
-		isStruct = false
-	}
-
 	// if target.Kind() == reflect.Ptr {
 	// 	log.Printf("Target: %v %s %v %s", target.IsValid(), target.Type(), target.IsNil(), target.Type().Elem().Kind())
 	// }
 
 	nullValueByIdx := make(map[int]reflect.Value, 0)
+	convertByIdx := make(map[int]reflect.Value, 0)
 
-	for idx, col := range cols {
+	for idx := range cols {
 
 		skip := false
 
 		// logrus.Infof("%v %v %v %v", idx, col, isStruct, isSlice)
 
 		if isStruct {
-			finfo, ok := info[col]
-			if !ok {
+			finfo := s.fields[idx]
+			if finfo == nil {
 				skip = true
 			} else {
-				fieldV = targetV.FieldByName(finfo.name)
+				fieldV = finfo.fieldValueAlloc(targetV)
+				if finfo.isArray {
+					data[idx] = pq.Array(fieldV.Addr().Interface())
+					continue
+				}
+				if finfo.isDuration {
+					data[idx] = &nullDuration{}
+					nullValueByIdx[idx] = fieldV
+					continue
+				}
 				if finfo.isJson {
 					// log.Printf("Setting field to json: %v idx: %d", finfo.name, idx)
 					data[idx] = &NullJson{}
@@ -127,6 +208,12 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 
 		// log.Printf("NIL?: %v %s %T", fieldV.IsValid(), fieldV.Type(), fieldV.Interface())
 
+		if conv := getConverter(fieldV.Type()); conv != nil {
+			data[idx] = &rawScan{}
+			convertByIdx[idx] = fieldV
+			continue
+		}
+
 		// Init Null Scanners for some Pointer Types
 		switch fieldV.Interface().(type) { // FIXME: we could use reflect's Type here
 		case *json.RawMessage, json.RawMessage:
@@ -144,8 +231,8 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 		case *bool, bool:
 			data[idx] = &sql.NullBool{}
 			nullValueByIdx[idx] = fieldV
-		case time.Time, *time.Time:
-			data[idx] = &NullTime{}
+		case time.Time, *time.Time, sql.NullTime:
+			data[idx] = &NullTime{layouts: s.TimeLayouts}
 			nullValueByIdx[idx] = fieldV
 		default:
 			if fieldV.Kind() != reflect.Ptr {
@@ -165,16 +252,40 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 		return err
 	}
 
+	// Convert values of types with registered converters
+	for idx, fieldV := range convertByIdx {
+		err = getConverter(fieldV.Type()).fromDB(data[idx].(*rawScan).value, fieldV)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to convert column %q to %s", cols[idx], fieldV.Type())
+		}
+	}
+
 	// Read back data from Null scanners which we used above
 	for idx, fieldV := range nullValueByIdx {
 		switch v := data[idx].(type) {
+		case *nullDuration:
+			switch {
+			case !v.Valid:
+				fieldV.Set(reflect.Zero(fieldV.Type()))
+			case fieldV.Kind() == reflect.Ptr:
+				d := reflect.New(fieldV.Type().Elem())
+				d.Elem().SetInt(int64(v.Duration))
+				fieldV.Set(d)
+			default:
+				fieldV.SetInt(int64(v.Duration))
+			}
+			continue
 		case *NullJson:
 			if (*v).Valid {
+				jsonData, err := upgradeJSONVersion(fieldV.Type(), (*v).Data)
+				if err != nil {
+					return err
+				}
 				// unmarshal
 				newData := reflect.New(fieldV.Type())
-				err = json.Unmarshal((*v).Data, newData.Interface())
+				err = json.Unmarshal(jsonData, newData.Interface())
 				if err != nil {
-					return errors.Wrapf(err, "Error unmarshalling data: %q", string((*v).Data))
+					return errors.Wrapf(err, "Error unmarshalling data: %q", string(jsonData))
 				}
 				fieldV.Set(reflect.Indirect(reflect.Value(newData)))
 			} else {
@@ -288,25 +399,76 @@ func scanRow(target reflect.Value, rows *sql.Rows) error {
 			default:
 				panic("Unable to read back *time.Time.")
 			}
+		case sql.NullTime:
+			v := data[idx].(*NullTime)
+			fieldV.Set(reflect.ValueOf(sql.NullTime{Time: v.Time, Valid: v.Valid}))
 		default:
 			panic("Unable to read back null.")
 		}
 	}
+
+	// Reset nested struct pointers without values
+	for name, idxs := range s.nilPtrs {
+		allNull := true
+		for _, idx := range idxs {
+			if !scannedNull(data[idx]) {
+				allNull = false
+				break
+			}
+		}
+		if allNull {
+			ptrV := targetV.FieldByName(name)
+			ptrV.Set(reflect.Zero(ptrV.Type()))
+		}
+	}
 	return nil
 }
 
+// scannedNull returns true if the scan destination d received NULL
+func scannedNull(d interface{}) bool {
+	switch v := d.(type) {
+	case *voidScan:
+		return true
+	case *rawScan:
+		return v.value == nil
+	case *NullJson:
+		return !v.Valid
+	case *NullRawMessage:
+		return !v.Valid
+	case *NullTime:
+		return !v.Valid
+	case *nullDuration:
+		return !v.Valid
+	case driver.Valuer:
+		value, err := v.Value()
+		return err == nil && value == nil
+	}
+	rv := reflect.ValueOf(d)
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+		return rv.Elem().IsNil()
+	}
+	return false
+}
+
 // Scan reads data from the given rows into the target.
 //
 // *int64, *string, etc: First column of first row
 // *struct: First row
 // []int64, []*int64, []string, []*string: First column, all rows
 // []struct, []*struct: All columns, all rows
+// *Table: All columns as strings, all rows, with the column names
 //
 // The mapping into structs is done by analyzing the struct's tag names
 // and using the given "db" key for the mapping. The mapping works on
 // exported fields only. Use "-" as mapping name to ignore the field.
 //
 func Scan(target interface{}, rows *sql.Rows) error {
+	return scan(target, rows, nil, nil)
+}
+
+// scan is Scan accepting the given time layouts, see DB.TimeLayouts, and
+// the allocator for slices of pointers, see WithRowAllocator
+func scan(target interface{}, rows *sql.Rows, timeLayouts []string, alloc RowAllocator) error {
 	var (
 		targetValue reflect.Value
 		rowMode     bool
@@ -317,6 +479,16 @@ func Scan(target interface{}, rows *sql.Rows) error {
 		panic(fmt.Errorf("Scan: target must not be <nil>."))
 	}
 
+	if tbl, ok := target.(*Table); ok {
+		cols, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		tbl.columns = cols
+		tbl.Rows = nil
+		return scan(&tbl.Rows, rows, timeLayouts, nil)
+	}
+
 	v := reflect.ValueOf(target)
 	if v.Type().Kind() != reflect.Ptr {
 		panic(fmt.Errorf("Scan: non-pointer %v", v.Type()))
@@ -331,9 +503,11 @@ func Scan(target interface{}, rows *sql.Rows) error {
 		rowMode = true
 	}
 
+	var scanner *Scanner
+
 	for rows.Next() {
 		if rowMode {
-			err = scanRow(targetValue, rows)
+			err = scanRow(targetValue, rows, timeLayouts)
 			if err != nil {
 				return err
 			}
@@ -347,7 +521,17 @@ func Scan(target interface{}, rows *sql.Rows) error {
 		rowValues := reflect.MakeSlice(targetValue.Type(), 1, 1)
 		rowValue := rowValues.Index(0)
 
-		err = scanRow(rowValue, rows)
+		if scanner == nil {
+			cols, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			scanner = NewScanner(rowValue.Type(), cols)
+			scanner.TimeLayouts = timeLayouts
+			scanner.Allocator = alloc
+		}
+
+		err = scanner.scanRow(rowValue, rows)
 		if err != nil {
 			return err
 		}
@@ -364,3 +548,37 @@ func Scan(target interface{}, rows *sql.Rows) error {
 	return nil
 
 }
+
+// scanColumnsMismatch returns the columns of rows which are not mapped
+// to a field and the db names of the fields which receive no column, if
+// target is a struct or a slice of structs
+func scanColumnsMismatch(target reflect.Type, rows *sql.Rows) (unmapped []string, missing []string, err error) {
+	t := target
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(Table{}) {
+		return nil, nil, nil
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := getStructInfo(t)
+	colMap := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		colMap[col] = true
+		if !info.hasDbName(col) {
+			unmapped = append(unmapped, col)
+		}
+	}
+	for dbName := range info {
+		if !colMap[dbName] {
+			missing = append(missing, dbName)
+		}
+	}
+	sort.Strings(missing)
+	return unmapped, missing, nil
+}
@@ -0,0 +1,47 @@
+package sqlpro
+
+import (
+	"context"
+	"strings"
+)
+
+// WithSchema returns a copy which qualifies the table names of Insert,
+// InsertBulk, Update, UpdateBulk, Save and CopyFrom with schema, e.g. for
+// a schema per tenant. On Postgres, transactions started on the copy set
+// the search_path to schema, so unqualified names in SQL passed to Query
+// and Exec resolve to the schema, too. Outside of transactions, such
+// names are resolved using the search_path of the connection.
+func (db *DB) WithSchema(schema string) *DB {
+	newDB := *db
+	newDB.schema = schema
+	return &newDB
+}
+
+// EscQualified escapes the qualified name, e.g. "schema.table", by
+// escaping each part separated by "."
+func (db *DB) EscQualified(name string) string {
+	parts := strings.Split(name, ".")
+	for idx, part := range parts {
+		parts[idx] = db.Esc(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// escTable escapes the table, which can be qualified like "schema.table",
+// and qualifies it with the schema set by WithSchema otherwise
+func (db *DB) escTable(table string) string {
+	if db.schema == "" || strings.Contains(table, ".") {
+		return db.EscQualified(table)
+	}
+	return db.Esc(db.schema) + "." + db.Esc(table)
+}
+
+// setSearchPath sets the search_path of the transaction to the schema set
+// by WithSchema
+func (db *DB) setSearchPath(ctx context.Context) error {
+	if db.schema == "" || db.Driver != POSTGRES {
+		return nil
+	}
+	_, err := db.sqlTx.ExecContext(ctx, "SET LOCAL search_path TO "+db.Esc(db.schema))
+	return err
+}
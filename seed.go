@@ -0,0 +1,144 @@
+package sqlpro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnsureRows makes sure the rows (struct or slice of structs) exist in
+// table, e.g. for lookup tables filled at startup. Rows are matched by the
+// columns keyCols. Missing rows are inserted. If updateDrifted is set, the
+// columns of existing rows which differ from rows are updated. Columns
+// left out by omitempty are neither compared nor updated. All changes are
+// done in one transaction, see ExecTX. The numbers of inserted and updated
+// rows are returned.
+func (db *DB) EnsureRows(ctx context.Context, table string, rows interface{}, keyCols []string, updateDrifted bool) (inserted, updated int64, err error) {
+	if len(keyCols) == 0 {
+		return 0, 0, fmt.Errorf("EnsureRows: keyCols are required.")
+	}
+
+	rv, structMode, err := checkData(rows)
+	if err != nil {
+		return 0, 0, err
+	}
+	var items []reflect.Value
+	if structMode {
+		items = append(items, rv)
+	} else {
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, reflect.Indirect(rv.Index(i)))
+		}
+	}
+
+	err = db.ExecTX(ctx, func(tx *DB) error {
+		inserted, updated = 0, 0
+		for _, item := range items {
+			ins, upd, err := tx.ensureRow(ctx, table, item, keyCols, updateDrifted)
+			if err != nil {
+				return err
+			}
+			if ins {
+				inserted++
+			}
+			if upd {
+				updated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, nil
+}
+
+// ensureRow inserts or updates the single struct row
+func (db *DB) ensureRow(ctx context.Context, table string, row reflect.Value, keyCols []string, updateDrifted bool) (inserted, updated bool, err error) {
+	values, _, err := db.valuesFromStruct(row.Interface())
+	if err != nil {
+		return false, false, err
+	}
+
+	var (
+		where    strings.Builder
+		keyArgs  []interface{}
+		isKeyCol = map[string]bool{}
+	)
+	for idx, col := range keyCols {
+		value, ok := values[col]
+		if !ok {
+			return false, false, fmt.Errorf("EnsureRows: Key column %q has no value.", col)
+		}
+		if idx > 0 {
+			where.WriteString(" AND ")
+		}
+		where.WriteString(db.Esc(col))
+		where.WriteString(" = ?")
+		keyArgs = append(keyArgs, value)
+		isKeyCol[col] = true
+	}
+
+	existing := reflect.New(row.Type())
+	err = db.QueryContext(ctx, existing.Interface(), "SELECT * FROM @ WHERE "+where.String(), append([]interface{}{table}, keyArgs...)...)
+	if err == ErrQueryReturnedZeroRows {
+		err = db.InsertContext(ctx, table, row.Interface())
+		if err != nil {
+			return false, false, err
+		}
+		return true, false, nil
+	}
+	if err != nil || !updateDrifted {
+		return false, false, err
+	}
+
+	existingValues, _, err := db.valuesFromStruct(existing.Elem().Interface())
+	if err != nil {
+		return false, false, err
+	}
+	var drifted []string
+	for col, value := range values {
+		if !isKeyCol[col] && !sameValue(value, existingValues[col]) {
+			drifted = append(drifted, col)
+		}
+	}
+	if len(drifted) == 0 {
+		return false, false, nil
+	}
+	sort.Strings(drifted)
+
+	var (
+		set  strings.Builder
+		args []interface{}
+	)
+	for idx, col := range drifted {
+		if idx > 0 {
+			set.WriteString(", ")
+		}
+		set.WriteString(db.Esc(col))
+		set.WriteString(" = ?")
+		args = append(args, values[col])
+	}
+	err = db.ExecContext(ctx, "UPDATE @ SET "+set.String()+" WHERE "+where.String(), append(append([]interface{}{table}, args...), keyArgs...)...)
+	if err != nil {
+		return false, false, err
+	}
+	return false, true, nil
+}
+
+// sameValue compares two values returned by valuesFromStruct
+func sameValue(a, b interface{}) bool {
+	switch av := a.(type) {
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return ok && av.Equal(bv)
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && bytes.Equal(av, bv)
+	}
+	return reflect.DeepEqual(a, b)
+}
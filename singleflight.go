@@ -0,0 +1,183 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// flightGroup de-duplicates identical concurrent queries, see Singleflight
+type flightGroup struct {
+	mtx   sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg     sync.WaitGroup
+	result reflect.Value // pointer to the scanned target
+	err    error
+	dups   int // number of callers waiting for the result
+}
+
+// Singleflight returns a copy of db which runs identical concurrent SELECT
+// queries outside of transactions only once. Queries are identical if
+// their SQL, target type and the values of their args are equal. The callers waiting for the
+// running query receive a deep copy of its result, the target is
+// overwritten as a whole. The query runs with the ctx of the first caller.
+// Copies of the returned handle share the de-duplication.
+func (db *DB) Singleflight() *DB {
+	newDB := *db
+	newDB.flights = &flightGroup{calls: map[string]*flightCall{}}
+	return &newDB
+}
+
+// isSelect returns true if query is a SELECT
+func isSelect(query string) bool {
+	query = strings.TrimSpace(query)
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT")
+}
+
+// flightKey returns the key of identical queries. The args are compared
+// by their driver values, so pointers to equal values are identical. It
+// returns false if an arg has no driver value, such queries are not
+// de-duplicated.
+func flightKey(target interface{}, query string, args []interface{}) (string, bool) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%T\x00%s", target, query)
+	for _, arg := range args {
+		sb.WriteByte(0)
+		if !writeFlightArg(&sb, arg) {
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// writeFlightArg writes the driver value of arg, slices are written
+// element by element
+func writeFlightArg(sb *strings.Builder, arg interface{}) bool {
+	v, err := convertToDB(arg)
+	if err != nil {
+		return false
+	}
+	dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return false
+		}
+		fmt.Fprintf(sb, "%T[", v)
+		for i := 0; i < rv.Len(); i++ {
+			sb.WriteByte(',')
+			if !writeFlightArg(sb, rv.Index(i).Interface()) {
+				return false
+			}
+		}
+		sb.WriteByte(']')
+		return true
+	}
+	switch dv := dv.(type) {
+	case string, []byte:
+		fmt.Fprintf(sb, "%T:%q", dv, dv)
+	default:
+		// time.Time is formatted using its String method
+		fmt.Fprintf(sb, "%T:%v", dv, dv)
+	}
+	return true
+}
+
+// queryShared runs the query through the flightGroup of db
+func (db *DB) queryShared(ctx context.Context, target interface{}, query string, args ...interface{}) error {
+	key, ok := flightKey(target, query, args)
+	if !ok {
+		direct := *db
+		direct.flights = nil
+		return direct.QueryContext(ctx, target, query, args...)
+	}
+
+	fg := db.flights
+	fg.mtx.Lock()
+	call, ok := fg.calls[key]
+	if ok {
+		call.dups++
+	} else {
+		call = &flightCall{}
+		call.wg.Add(1)
+		fg.calls[key] = call
+	}
+	fg.mtx.Unlock()
+
+	if ok {
+		call.wg.Wait()
+	} else {
+		direct := *db
+		direct.flights = nil
+		call.result = reflect.New(reflect.TypeOf(target).Elem())
+		call.err = direct.QueryContext(ctx, call.result.Interface(), query, args...)
+
+		fg.mtx.Lock()
+		delete(fg.calls, key)
+		fg.mtx.Unlock()
+		call.wg.Done()
+	}
+
+	if call.err != nil {
+		return call.err
+	}
+	reflect.ValueOf(target).Elem().Set(deepCopy(call.result.Elem()))
+	return nil
+}
+
+// deepCopy returns a copy of v not sharing pointers, slices, maps or
+// interfaces with v. Unexported struct fields are copied shallow.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type().Elem())
+		n.Elem().Set(deepCopy(v.Elem()))
+		return n
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			n.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return n
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type()).Elem()
+		n.Set(deepCopy(v.Elem()))
+		return n
+	case reflect.Struct:
+		n := reflect.New(v.Type()).Elem()
+		n.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if n.Field(i).CanSet() {
+				n.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return n
+	default:
+		return v
+	}
+}
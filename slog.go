@@ -0,0 +1,66 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// txIDs numbers the transactions for log events
+var txIDs atomic.Uint64
+
+// SetLogger sets a structured logger. Statements, transactions and
+// PrintQuery are logged as events at debug level, all other output,
+// including warnings, goes to this logger instead of Logger. Copies of the
+// handle made before the call keep their logger.
+func (db *DB) SetLogger(logger *slog.Logger) {
+	db.slogger = logger
+}
+
+// logEvent logs a debug event with the driver and the transaction id
+func (db *DB) logEvent(ctx context.Context, msg string, attrs ...slog.Attr) {
+	if db.slogger == nil || !db.slogger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs = append(attrs, slog.String("driver", string(db.Driver)))
+	if db.txID > 0 {
+		attrs = append(attrs, slog.Uint64("tx", db.txID))
+	}
+	db.slogger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// logStatement logs a statement sent to the database, res is <nil> for
+// queries
+func (db *DB) logStatement(ctx context.Context, msg string, query string, args []interface{}, start time.Time, res sql.Result, err error) {
+	if db.slogger == nil || !db.slogger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("sql", query),
+		slog.Int("args", len(args)),
+		slog.Duration("duration", db.now().Sub(start)),
+	}
+	if res != nil {
+		if affected, err := res.RowsAffected(); err == nil {
+			attrs = append(attrs, slog.Int64("rows", affected))
+		}
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	db.logEvent(ctx, msg, attrs...)
+}
+
+// logTxEnd logs the commit or rollback of the transaction
+func (db *DB) logTxEnd(msg string, err error) {
+	if db.slogger == nil {
+		return
+	}
+	attrs := []slog.Attr{slog.Duration("duration", db.now().Sub(db.txStart))}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	db.logEvent(context.Background(), msg, attrs...)
+}
@@ -0,0 +1,50 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ExportSnapshot exports the snapshot of the transaction using
+// pg_export_snapshot and returns its id. Other transactions can import the
+// snapshot with BeginReadWithSnapshot while this transaction is open, so
+// e.g. parallel export workers see the same data. Postgres only.
+func (db *DB) ExportSnapshot(ctx context.Context) (string, error) {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.ExportSnapshot: Needs Transaction.")
+	}
+	if db.Driver != POSTGRES {
+		return "", fmt.Errorf("ExportSnapshot: Unsupported driver %q.", db.Driver)
+	}
+	var id string
+	err := db.QueryContext(ctx, &id, "SELECT pg_export_snapshot()")
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// BeginReadWithSnapshot starts a read-only REPEATABLE READ transaction
+// which sees the snapshot exported by ExportSnapshot. Postgres only.
+func (db *DB) BeginReadWithSnapshot(ctx context.Context, id string) (*DB, error) {
+	if db.Driver != POSTGRES {
+		return nil, fmt.Errorf("BeginReadWithSnapshot: Unsupported driver %q.", db.Driver)
+	}
+	if id == "" {
+		return nil, fmt.Errorf("BeginReadWithSnapshot: Snapshot id is required.")
+	}
+
+	// the snapshot was exported on the primary
+	tx, err := db.Primary().BeginContext(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	// this needs to be the first statement of the transaction
+	_, err = tx.sqlTx.ExecContext(ctx, "SET TRANSACTION SNAPSHOT "+db.EscValue(id))
+	if err != nil {
+		tx.Rollback()
+		return nil, db.debugError(fmt.Errorf("BeginReadWithSnapshot: %w", err))
+	}
+	return tx, nil
+}
@@ -0,0 +1,77 @@
+package sqlpro
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// sqliteKeyParam is the DSN parameter with the key of a SQLCipher
+// encrypted SQLite database, e.g. "data.db?_key=secret"
+const sqliteKeyParam = "_key"
+
+func quoteKey(key string) string {
+	return `'` + strings.ReplaceAll(key, `'`, `''`) + `'`
+}
+
+// splitSQLiteKey removes the key parameter from dsn. ok is false if dsn
+// has no key.
+func splitSQLiteKey(dsn string) (plainDSN, key string, ok bool, err error) {
+	path, query, found := strings.Cut(dsn, "?")
+	if !found {
+		return dsn, "", false, nil
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", false, fmt.Errorf("sqlpro.Open: Unable to parse DSN: %w", err)
+	}
+	if !params.Has(sqliteKeyParam) {
+		return dsn, "", false, nil
+	}
+	key = params.Get(sqliteKeyParam)
+	params.Del(sqliteKeyParam)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	return path, key, true, nil
+}
+
+// WithSQLiteKey sets the key of a SQLCipher encrypted SQLite database like
+// the "_key" DSN parameter, so the key can be kept out of the DSN. The
+// connection opened by Open is closed, as it does not use the key yet.
+// Other drivers ignore the key.
+func WithSQLiteKey(key string) Option {
+	return func(db *DB) {
+		if db.sqliteConn == nil {
+			return
+		}
+		db.sqliteConn.mtx.Lock()
+		db.sqliteConn.key = key
+		db.sqliteConn.mtx.Unlock()
+		db.closeIdleConns()
+	}
+}
+
+// Rekey changes the key of the SQLCipher database opened with the "_key"
+// DSN parameter or WithSQLiteKey, new connections use newKey. Idle
+// connections are closed as they still use the old key.
+func (db *DB) Rekey(ctx context.Context, newKey string) error {
+	if db.sqliteConn == nil || db.sqliteConn.getKey() == "" {
+		return fmt.Errorf("Rekey: Database was not opened with a key.")
+	}
+	if db.sqlTx != nil {
+		return fmt.Errorf("Rekey: Unable to rekey inside a transaction.")
+	}
+
+	err := db.ExecContext(ctx, "PRAGMA rekey = ?", Raw(quoteKey(newKey)))
+	if err != nil {
+		return err
+	}
+	db.sqliteConn.mtx.Lock()
+	db.sqliteConn.key = newKey
+	db.sqliteConn.mtx.Unlock()
+
+	db.closeIdleConns()
+	return nil
+}
@@ -0,0 +1,150 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqliteConnector opens connections of the sqlite3 driver and runs the
+// per connection setup, PRAGMA key for SQLCipher and the pragmas set by
+// SQLitePragmas, before the pool uses them
+type sqliteConnector struct {
+	drv driver.Driver
+	dsn string
+
+	mtx     sync.Mutex
+	key     string
+	pragmas []string
+}
+
+func (sc *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := sc.drv.Open(sc.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.mtx.Lock()
+	var setup []string
+	if sc.key != "" {
+		// the key needs to be set first
+		setup = append(setup, "PRAGMA key = "+quoteKey(sc.key))
+	}
+	setup = append(setup, sc.pragmas...)
+	sc.mtx.Unlock()
+
+	if len(setup) == 0 {
+		return conn, nil
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("sqlpro.Open: Driver %T does not support connection setup.", sc.drv)
+	}
+	for _, stmt := range setup {
+		_, err = execer.ExecContext(ctx, stmt, nil)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("sqlpro.Open: Connection setup failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (sc *sqliteConnector) Driver() driver.Driver {
+	return sc.drv
+}
+
+func (sc *sqliteConnector) getKey() string {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	return sc.key
+}
+
+// openSQLite opens the SQLite database dsn through a sqliteConnector. For
+// a key, the sqlite3 driver needs to be built against SQLCipher, plain
+// SQLite ignores it.
+func openSQLite(driverName, dsn, key string) (*sql.DB, *sqliteConnector, error) {
+	// sql.Open does not connect, it is used to look up the registered driver
+	lookup, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := &sqliteConnector{drv: lookup.Driver(), dsn: dsn, key: key}
+	lookup.Close()
+	return sql.OpenDB(sc), sc, nil
+}
+
+// Pragmas are the SQLite settings applied by SQLitePragmas, zero values
+// keep the setting of the DSN or the SQLite default
+type Pragmas struct {
+	JournalMode string        // e.g. "wal", stored in the database file
+	BusyTimeout time.Duration // wait for locks held by other connections
+	ForeignKeys bool          // enforce foreign key constraints
+	Synchronous string        // e.g. "NORMAL" or "FULL"
+}
+
+var (
+	journalModes = wordSet("DELETE TRUNCATE PERSIST MEMORY WAL OFF")
+	syncModes    = wordSet("OFF NORMAL FULL EXTRA 0 1 2 3")
+)
+
+// statements returns the PRAGMA statements for p
+func (p Pragmas) statements() ([]string, error) {
+	var stmts []string
+	if p.JournalMode != "" {
+		if !journalModes[strings.ToUpper(p.JournalMode)] {
+			return nil, fmt.Errorf("SQLitePragmas: Unknown journal mode %q.", p.JournalMode)
+		}
+		stmts = append(stmts, "PRAGMA journal_mode = "+strings.ToUpper(p.JournalMode))
+	}
+	if p.BusyTimeout > 0 {
+		stmts = append(stmts, "PRAGMA busy_timeout = "+strconv.FormatInt(p.BusyTimeout.Milliseconds(), 10))
+	}
+	if p.ForeignKeys {
+		stmts = append(stmts, "PRAGMA foreign_keys = ON")
+	}
+	if p.Synchronous != "" {
+		if !syncModes[strings.ToUpper(p.Synchronous)] {
+			return nil, fmt.Errorf("SQLitePragmas: Unknown synchronous mode %q.", p.Synchronous)
+		}
+		stmts = append(stmts, "PRAGMA synchronous = "+strings.ToUpper(p.Synchronous))
+	}
+	return stmts, nil
+}
+
+// SQLitePragmas applies p to every new connection of the handle, the
+// settings of a previous call are replaced. Idle connections are closed,
+// so call this right after Open, before connections are in use.
+func (db *DB) SQLitePragmas(p Pragmas) error {
+	if db.sqliteConn == nil {
+		return fmt.Errorf("SQLitePragmas: Database was not opened by Open with driver %q.", SQLITE3)
+	}
+	if db.sqlTx != nil {
+		return fmt.Errorf("SQLitePragmas: Unable to set pragmas inside a transaction.")
+	}
+	stmts, err := p.statements()
+	if err != nil {
+		return err
+	}
+
+	db.sqliteConn.mtx.Lock()
+	db.sqliteConn.pragmas = stmts
+	db.sqliteConn.mtx.Unlock()
+
+	db.closeIdleConns()
+	return nil
+}
+
+// closeIdleConns closes the idle connections of the pool, so connection
+// settings apply to all connections used afterwards
+func (db *DB) closeIdleConns() {
+	db.sqlDB.SetMaxIdleConns(0)
+	db.sqlDB.SetMaxIdleConns(db.maxIdleConns)
+}
@@ -0,0 +1,180 @@
+// Package sqlprotest provides disposable Postgres databases for tests of
+// code using sqlpro.
+package sqlprotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/programmfabrik/sqlpro"
+)
+
+// PostgresEnv is the environment variable with the DSN of the Postgres
+// server used by OpenPostgres
+const PostgresEnv = "SQLPRO_TEST_POSTGRES"
+
+// defaultImage is the container image started by OpenPostgres
+const defaultImage = "postgres:16-alpine"
+
+// containerPassword is the password of the started container
+const containerPassword = "sqlprotest"
+
+// Options configure OpenPostgres
+type Options struct {
+	DSN     string          // server to connect to, defaults to the environment variable PostgresEnv
+	Image   string          // container image started without DSN, defaults to postgres:16-alpine
+	Schema  string          // SQL run before the handle is returned, e.g. CREATE TABLE statements
+	Options []sqlpro.Option // passed to Open
+}
+
+// OpenPostgres returns a handle to a disposable schema on a Postgres
+// server for tests. The schema is created with a random name and set as
+// search_path for all connections, opts.Schema is applied to it. On test
+// cleanup, the schema is dropped and the handle is closed.
+//
+// Without a DSN, a Postgres container is started using docker and removed
+// on test cleanup. The test is skipped if docker is not installed. As each
+// call starts its own container, set PostgresEnv to run many tests against
+// one server.
+func OpenPostgres(t testing.TB, opts Options) *sqlpro.DB {
+	t.Helper()
+
+	dsn := opts.DSN
+	if dsn == "" {
+		dsn = os.Getenv(PostgresEnv)
+	}
+	if dsn == "" {
+		dsn = startContainer(t, opts.Image)
+	}
+
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		t.Fatalf("OpenPostgres: %s", err)
+	}
+	schema := "sqlpro_test_" + hex.EncodeToString(suffix)
+
+	admin, err := sqlpro.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("OpenPostgres: %s", err)
+	}
+	err = admin.Exec("CREATE SCHEMA @", schema)
+	if err != nil {
+		admin.Close()
+		t.Fatalf("OpenPostgres: %s", err)
+	}
+	t.Cleanup(func() {
+		err := admin.Exec("DROP SCHEMA @ CASCADE", schema)
+		if err != nil {
+			t.Errorf("OpenPostgres: %s", err)
+		}
+		admin.Close()
+	})
+
+	db, err := sqlpro.Open("postgres", withSearchPath(dsn, schema), opts.Options...)
+	if err != nil {
+		t.Fatalf("OpenPostgres: %s", err)
+	}
+	// registered after the schema cleanup, so it runs before it
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	if opts.Schema != "" {
+		err = db.Exec(opts.Schema)
+		if err != nil {
+			t.Fatalf("OpenPostgres: Applying schema failed: %s", err)
+		}
+	}
+	return db
+}
+
+// startContainer starts a Postgres container and returns its DSN once the
+// server accepts connections
+func startContainer(t testing.TB, image string) string {
+	t.Helper()
+
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		t.Skipf("OpenPostgres: Set %s or install docker to run tests against Postgres.", PostgresEnv)
+	}
+	if image == "" {
+		image = defaultImage
+	}
+
+	out, err := exec.Command(docker, "run", "--detach", "--rm",
+		"--env", "POSTGRES_PASSWORD="+containerPassword,
+		"--publish", "127.0.0.1::5432",
+		image).Output()
+	if err != nil {
+		t.Fatalf("OpenPostgres: Starting container failed: %s", commandError(err))
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		err := exec.Command(docker, "rm", "--force", id).Run()
+		if err != nil {
+			t.Errorf("OpenPostgres: Removing container failed: %s", commandError(err))
+		}
+	})
+
+	out, err = exec.Command(docker, "port", id, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("OpenPostgres: Reading container port failed: %s", commandError(err))
+	}
+	// the first line is the IPv4 address
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	dsn := (&url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword("postgres", containerPassword),
+		Host:     addr,
+		Path:     "/postgres",
+		RawQuery: "sslmode=disable",
+	}).String()
+
+	// the server only listens on TCP once its initialization is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for {
+		db, err := sqlpro.Open("postgres", dsn)
+		if err == nil {
+			db.Close()
+			return dsn
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("OpenPostgres: Container not ready: %s", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// commandError adds the stderr output of a failed command to err
+func commandError(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return err.Error() + ": " + strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return err.Error()
+}
+
+// withSearchPath adds the search_path connection parameter to the URL or
+// key=value dsn for lib/pq
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
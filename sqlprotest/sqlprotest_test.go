@@ -0,0 +1,61 @@
+package sqlprotest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenPostgres(t *testing.T) {
+	assert.Equal(t, "postgres://u@localhost/db?search_path=s&sslmode=disable",
+		withSearchPath("postgres://u@localhost/db?sslmode=disable", "s"))
+	assert.Equal(t, "host=localhost dbname=db search_path=s", withSearchPath("host=localhost dbname=db", "s"))
+
+	pg := OpenPostgres(t, Options{Schema: "CREATE TABLE t (a INTEGER)"})
+	err := pg.Exec("INSERT INTO t (a) VALUES (1)")
+	assert.NoError(t, err)
+}
+
+func TestInsertBulkCopyIn(t *testing.T) {
+	type row struct {
+		A int64   `db:"a"`
+		B string  `db:"b"`
+		C *string `db:"c"`
+	}
+
+	pg := OpenPostgres(t, Options{Schema: "CREATE TABLE copy_in (a INTEGER, b TEXT, c TEXT)"})
+
+	c := "x"
+	err := pg.InsertBulkCopyIn("copy_in", []row{{A: 1, B: "one", C: &c}, {A: 2, B: "two"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// columns are mapped by name, not by position
+	rows := []row{}
+	err = pg.Query(&rows, "SELECT c, b, a FROM copy_in ORDER BY a")
+	if assert.NoError(t, err) && assert.Len(t, rows, 2) {
+		assert.Equal(t, "one", rows[0].B)
+		assert.Equal(t, "x", *rows[0].C)
+		assert.Equal(t, "two", rows[1].B)
+		assert.Nil(t, rows[1].C)
+	}
+
+	// inside a transaction the rows are only visible after the commit
+	tx, err := pg.Begin()
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = tx.InsertBulkCopyIn("copy_in", []row{{A: 3, B: "three"}})
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+
+	var count int
+	err = pg.Query(&count, "SELECT COUNT(*) FROM copy_in")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, count)
+	}
+
+	err = pg.InsertBulkCopyIn("copy_in", row{A: 4})
+	assert.Error(t, err)
+}
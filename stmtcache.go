@@ -0,0 +1,228 @@
+package sqlpro
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+)
+
+// stmtCache is a LRU cache of prepared statements, prepared
+// on the sql.DB handle
+type stmtCache struct {
+	mtx   sync.Mutex
+	lru   *list.List // of *stmtCacheEntry, most recently used first
+	stmts map[string]*list.Element
+}
+
+type stmtCacheEntry struct {
+	query string
+	stmt  *sql.Stmt
+	// guarded by the mutex of the cache
+	users   int  // callers which got stmt and did not release it yet
+	evicted bool // removed from the cache, the last user closes stmt
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{
+		lru:   list.New(),
+		stmts: map[string]*list.Element{},
+	}
+}
+
+// get returns the prepared statement for query. If the statement is not
+// cached, it is prepared if sqlDB is set, otherwise get returns <nil>. The
+// returned func needs to be called once the statement is started, the
+// statement is not closed before.
+func (sc *stmtCache) get(ctx context.Context, sqlDB *sql.DB, query string, size int) (*sql.Stmt, func(), error) {
+	stmt, release := sc.use(query)
+	if stmt != nil || sqlDB == nil {
+		return stmt, release, nil
+	}
+
+	// prepare without the lock, so other queries are not blocked by the
+	// round trip
+	stmt, err := sqlDB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if el, ok := sc.stmts[query]; ok {
+		// prepared concurrently, use the cached one
+		closeStmt(stmt)
+		stmt, release := sc.useElement(el)
+		return stmt, release, nil
+	}
+
+	entry := &stmtCacheEntry{query: query, stmt: stmt, users: 1}
+	sc.stmts[query] = sc.lru.PushFront(entry)
+	for sc.lru.Len() > size {
+		last := sc.lru.Back()
+		sc.lru.Remove(last)
+		sc.evict(last.Value.(*stmtCacheEntry))
+	}
+	return stmt, func() { sc.release(entry) }, nil
+}
+
+// use returns the cached statement for query, <nil> if not cached
+func (sc *stmtCache) use(query string) (*sql.Stmt, func()) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	el, ok := sc.stmts[query]
+	if !ok {
+		return nil, nil
+	}
+	return sc.useElement(el)
+}
+
+// useElement marks the cached el as used, the caller holds the mutex
+func (sc *stmtCache) useElement(el *list.Element) (*sql.Stmt, func()) {
+	sc.lru.MoveToFront(el)
+	entry := el.Value.(*stmtCacheEntry)
+	entry.users++
+	return entry.stmt, func() { sc.release(entry) }
+}
+
+// release ends a use of entry returned by get
+func (sc *stmtCache) release(entry *stmtCacheEntry) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	entry.users--
+	if entry.evicted && entry.users == 0 {
+		closeStmt(entry.stmt)
+	}
+}
+
+// evict removes entry from the cache and closes its statement once it is
+// no longer used, the caller holds the mutex
+func (sc *stmtCache) evict(entry *stmtCacheEntry) {
+	delete(sc.stmts, entry.query)
+	entry.evicted = true
+	if entry.users == 0 {
+		closeStmt(entry.stmt)
+	}
+}
+
+// closeStmt closes stmt in the background, as sql.Stmt.Close waits for
+// open rows of the statement
+func closeStmt(stmt *sql.Stmt) {
+	go stmt.Close()
+}
+
+// clear closes and removes all statements
+func (sc *stmtCache) clear() {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	for _, el := range sc.stmts {
+		sc.evict(el.Value.(*stmtCacheEntry))
+	}
+	sc.lru.Init()
+	sc.stmts = map[string]*list.Element{}
+}
+
+// ClearStmtCache closes all cached prepared statements
+func (db *DB) ClearStmtCache() {
+	db.stmtCache.clear()
+}
+
+// cachedStmt returns a prepared statement for query if the statement cache is
+// enabled. Only statements with arguments are cached, statements with
+// literals only (like InsertBulk) are rarely repeated. Statements containing
+// ";" are not cached, as drivers only prepare the first statement.
+//
+// Transactions use statements from the cache, but do not prepare new ones, as
+// preparing on the sql.DB needs a second connection.
+//
+// The returned func needs to be called once the statement is started.
+func (db *DB) cachedStmt(ctx context.Context, query string, args []interface{}) (*sql.Stmt, func()) {
+	if db.StmtCacheSize <= 0 || db.sqlDB == nil || len(args) == 0 {
+		return nil, nil
+	}
+	if strings.Contains(strings.TrimRight(query, "; \t\n"), ";") {
+		return nil, nil
+	}
+	if db.sqlTx != nil {
+		stmt, release, _ := db.stmtCache.get(ctx, nil, query, db.StmtCacheSize)
+		if stmt == nil {
+			return nil, nil
+		}
+		// the transaction's statement keeps stmt open until it is closed
+		defer release()
+		return db.sqlTx.StmtContext(ctx, stmt), func() {}
+	}
+	stmt, release, err := db.stmtCache.get(ctx, db.sqlDB, query, db.StmtCacheSize)
+	if err != nil {
+		// let the unprepared execution report the error
+		return nil, nil
+	}
+	return stmt, release
+}
+
+// queryStmt runs the query using a cached prepared statement if available
+func (db *DB) queryStmt(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	start := db.now()
+	defer func() {
+		db.runHook(ctx, query, args, start, err)
+		db.checkSlowQuery(query, start)
+		db.txStats.add(false, start, db.now(), nil)
+		db.logStatement(ctx, "sqlpro query", query, args, start, nil, err)
+	}()
+
+	release, err := db.acquireSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rows != nil && db.throttle != nil {
+			// released by closeRows
+			holdSlot(rows, release)
+			return
+		}
+		release()
+	}()
+
+	if commented := withComment(ctx, query); commented != query {
+		// Statements with comments are unique, don't cache them
+		return db.db.QueryContext(ctx, commented, args...)
+	}
+	stmt, releaseStmt := db.cachedStmt(ctx, query, args)
+	if stmt != nil {
+		defer releaseStmt()
+		return stmt.QueryContext(ctx, args...)
+	}
+	return db.db.QueryContext(ctx, query, args...)
+}
+
+// execStmt runs the statement using a cached prepared statement if available
+func (db *DB) execStmt(ctx context.Context, query string, args ...interface{}) (res sql.Result, err error) {
+	start := db.now()
+	defer func() {
+		db.runHook(ctx, query, args, start, err)
+		db.checkSlowQuery(query, start)
+		db.txStats.add(true, start, db.now(), res)
+		db.logStatement(ctx, "sqlpro exec", query, args, start, res, err)
+	}()
+
+	release, err := db.acquireSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if commented := withComment(ctx, query); commented != query {
+		return db.db.ExecContext(ctx, commented, args...)
+	}
+	stmt, releaseStmt := db.cachedStmt(ctx, query, args)
+	if stmt != nil {
+		defer releaseStmt()
+		return stmt.ExecContext(ctx, args...)
+	}
+	return db.db.ExecContext(ctx, query, args...)
+}
@@ -0,0 +1,45 @@
+package sqlpro
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// Table is a scan target keeping the column names in the order of the
+// query, e.g. for CSV exports. NULL values are scanned as "".
+type Table struct {
+	columns []string
+	Rows    [][]string
+}
+
+// Columns returns the column names in the order of the query
+func (t *Table) Columns() []string {
+	return t.columns
+}
+
+// Maps returns the rows as maps of column name to value
+func (t *Table) Maps() []map[string]string {
+	maps := make([]map[string]string, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		m := make(map[string]string, len(t.columns))
+		for idx, col := range t.columns {
+			m[col] = row[idx]
+		}
+		maps = append(maps, m)
+	}
+	return maps
+}
+
+// WriteCSV writes the column names as header and the rows as CSV to w
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(t.columns)
+	if err != nil {
+		return err
+	}
+	err = cw.WriteAll(t.Rows)
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
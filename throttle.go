@@ -0,0 +1,128 @@
+package sqlpro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrThrottled is returned if a statement waited longer than
+// DB.ThrottleTimeout for a slot, see MaxConcurrentQueries
+var ErrThrottled error = errors.New("Too many concurrent queries.")
+
+// throttle limits the number of concurrently running statements
+type throttle struct {
+	slots     chan struct{}
+	waiting   atomic.Int64
+	throttled atomic.Uint64
+	waitNanos atomic.Int64
+}
+
+// ThrottleStats are the metrics of MaxConcurrentQueries
+type ThrottleStats struct {
+	Limit     int
+	Running   int
+	Waiting   int64
+	Throttled uint64        // number of statements which failed with ErrThrottled
+	WaitTime  time.Duration // cumulative time statements waited for a slot
+}
+
+// MaxConcurrentQueries returns a copy of db which runs at most n statements
+// at the same time, further statements wait for a slot up to
+// ThrottleTimeout. Copies of the returned handle, including its
+// transactions, share the limit. For queries the slot is held until the
+// rows are read and closed. Queries into **sql.Rows, QueryEach, Rows and
+// DiffRows release the slot when the rows are returned, as the caller's
+// code runs while the rows are open and may run statements on the same
+// handle, which would wait for the slot forever.
+func (db *DB) MaxConcurrentQueries(n int) *DB {
+	newDB := *db
+	if n > 0 {
+		newDB.throttle = &throttle{slots: make(chan struct{}, n)}
+	} else {
+		newDB.throttle = nil
+	}
+	return &newDB
+}
+
+// ThrottleStats returns the metrics of MaxConcurrentQueries, a zero
+// ThrottleStats without limit
+func (db *DB) ThrottleStats() ThrottleStats {
+	th := db.throttle
+	if th == nil {
+		return ThrottleStats{}
+	}
+	return ThrottleStats{
+		Limit:     cap(th.slots),
+		Running:   len(th.slots),
+		Waiting:   th.waiting.Load(),
+		Throttled: th.throttled.Load(),
+		WaitTime:  time.Duration(th.waitNanos.Load()),
+	}
+}
+
+// acquireSlot waits for a statement slot, the returned func releases it
+func (db *DB) acquireSlot(ctx context.Context) (release func(), err error) {
+	th := db.throttle
+	if th == nil {
+		return func() {}, nil
+	}
+	release = func() { <-th.slots }
+
+	select {
+	case th.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	th.waiting.Add(1)
+	start := time.Now()
+	defer func() {
+		th.waiting.Add(-1)
+		th.waitNanos.Add(int64(time.Since(start)))
+	}()
+
+	var timeout <-chan time.Time
+	if db.ThrottleTimeout > 0 {
+		timer := time.NewTimer(db.ThrottleTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case th.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		th.throttled.Add(1)
+		return nil, ErrThrottled
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// heldSlots maps the rows of throttled queries to the release of their
+// slot, see closeRows
+var heldSlots sync.Map
+
+// holdSlot keeps the slot of the query until rows are closed by closeRows
+func holdSlot(rows *sql.Rows, release func()) {
+	heldSlots.Store(rows, release)
+}
+
+// releaseRows releases the slot held by rows, if any
+func releaseRows(rows *sql.Rows) {
+	release, ok := heldSlots.LoadAndDelete(rows)
+	if ok {
+		release.(func())()
+	}
+}
+
+// closeRows closes rows and releases their slot
+func closeRows(rows *sql.Rows) error {
+	err := rows.Close()
+	releaseRows(rows)
+	return err
+}
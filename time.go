@@ -0,0 +1,113 @@
+package sqlpro
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// timeForWrite strips the monotonic clock reading from time values and
+// converts them to UTC if db.TimeUTC is set
+func (db *DB) timeForWrite(v interface{}) interface{} {
+	switch t := v.(type) {
+	case time.Time:
+		t = t.Round(0)
+		if db.TimeUTC {
+			t = t.UTC()
+		}
+		return t
+	case *time.Time:
+		if t == nil {
+			return v
+		}
+		t2 := t.Round(0)
+		if db.TimeUTC {
+			t2 = t2.UTC()
+		}
+		return &t2
+	}
+	return v
+}
+
+// auditTimes reads back all time columns written for the row with the
+// given primary key and logs a warning for every value which lost
+// precision or its timezone on the way through the database.
+func (db *DB) auditTimes(ctx context.Context, table string, values map[string]interface{}, info structInfo, pkValue interface{}) {
+	if !db.TimeAudit {
+		return
+	}
+
+	pk := info.onlyPrimaryKey()
+	if pk == nil || isZero(pkValue) {
+		return
+	}
+
+	for col, value := range values {
+		var written time.Time
+		switch t := value.(type) {
+		case time.Time:
+			written = t
+		case *time.Time:
+			if t == nil {
+				continue
+			}
+			written = *t
+		default:
+			continue
+		}
+
+		var readBack *time.Time
+		err := db.QueryContext(ctx, &readBack, "SELECT @ FROM @ WHERE @ = ?", col, table, pk.dbName, pkValue)
+		if err != nil {
+			db.warn(WarningTimeAudit, "", "%s.%s: unable to read back: %s", table, col, err)
+			continue
+		}
+		if readBack == nil {
+			db.warn(WarningTimeAudit, "", "%s.%s: wrote %s, read back NULL", table, col, written.Format(time.RFC3339Nano))
+			continue
+		}
+
+		_, writtenOffset := written.Zone()
+		_, readOffset := readBack.Zone()
+		switch {
+		case !readBack.Equal(written):
+			db.warn(WarningTimeAudit, "", "%s.%s: precision lost, wrote %s, read back %s",
+				table, col, written.Format(time.RFC3339Nano), readBack.Format(time.RFC3339Nano))
+		case writtenOffset != readOffset:
+			db.warn(WarningTimeAudit, "", "%s.%s: timezone lost, wrote %s, read back %s",
+				table, col, written.Format(time.RFC3339Nano), readBack.Format(time.RFC3339Nano))
+		}
+	}
+}
+
+// auditStructTimes runs auditTimes for a struct row
+func (db *DB) auditStructTimes(ctx context.Context, table string, row reflect.Value) {
+	if !db.TimeAudit {
+		return
+	}
+	if row.Kind() == reflect.Interface {
+		row = reflect.Indirect(row.Elem())
+	}
+	values, info, err := db.valuesFromStruct(row.Interface())
+	if err != nil {
+		return
+	}
+	pk := info.onlyPrimaryKey()
+	if pk == nil {
+		return
+	}
+	db.auditTimes(ctx, table, values, info, pk.fieldValue(row).Interface())
+}
+
+// auditPrimaryKey returns the primary key value of an inserted row, this
+// is the written value or the id returned by the insert
+func (db *DB) auditPrimaryKey(values map[string]interface{}, info structInfo, insertID int64) interface{} {
+	pk := info.onlyPrimaryKey()
+	if pk == nil {
+		return nil
+	}
+	if v, ok := values[pk.dbName]; ok && !isZero(v) {
+		return v
+	}
+	return insertID
+}
@@ -0,0 +1,86 @@
+package sqlpro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrWaitForTimeout error = errors.New("Timeout waiting for commit token.")
+
+// CommitToken identifies the database state after a commit. On Postgres
+// this is the WAL LSN, other drivers return an empty token.
+type CommitToken string
+
+// waitForInterval is the poll interval for WaitFor
+const waitForInterval = 10 * time.Millisecond
+
+// Token returns the commit token captured on Commit of a write transaction.
+// Pass it to WaitFor on a replica to read your own writes. Tokens are only
+// captured if CaptureCommitTokens is set or the handle was opened by
+// OpenCluster, as this costs a query per commit.
+func (db *DB) Token() CommitToken {
+	return db.commitToken
+}
+
+// captureCommitToken stores the current WAL position, it is called after
+// the commit of a write transaction
+func (db *DB) captureCommitToken() {
+	if db.Driver != POSTGRES || !db.txWriteMode || db.sqlDB == nil {
+		return
+	}
+	if !db.CaptureCommitTokens && db.replicas == nil {
+		return
+	}
+	var lsn string
+	err := db.sqlDB.QueryRow("SELECT pg_current_wal_lsn()::text").Scan(&lsn)
+	if err != nil {
+		if db.debug(EXEC) {
+			db.logf("%s unable to capture commit token: %s", db, err)
+		}
+		return
+	}
+	db.commitToken = CommitToken(lsn)
+}
+
+func (db *DB) WaitFor(token CommitToken, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return db.WaitForContext(ctx, token)
+}
+
+// WaitForContext blocks until the database has replayed past token, or
+// ctx is done. Use this on a replica handle after writing to the primary.
+// An empty token returns immediately. ErrWaitForTimeout is returned if the
+// context deadline is exceeded.
+func (db *DB) WaitForContext(ctx context.Context, token CommitToken) error {
+	if token == "" || db.Driver != POSTGRES {
+		return nil
+	}
+
+	ticker := time.NewTicker(waitForInterval)
+	defer ticker.Stop()
+
+	for {
+		var reached bool
+		// pg_last_wal_replay_lsn is NULL on the primary
+		err := db.QueryContext(ctx, &reached,
+			"SELECT COALESCE(pg_last_wal_replay_lsn(), pg_current_wal_lsn()) >= ?::pg_lsn", string(token))
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w %s", ErrWaitForTimeout, token)
+			}
+			return err
+		}
+		if reached {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w %s", ErrWaitForTimeout, token)
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,9 +3,19 @@ package sqlpro
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrAfterCommit is returned by Commit if funcs registered with
+// AfterCommitErr failed, the transaction was committed
+var ErrAfterCommit error = errors.New("After commit funcs failed.")
+
 // txBegin starts a new transaction, this panics if
 // the wrapper was not initialized using "Open"
 // it gets passed a flag which states if there will be any writes
@@ -21,10 +31,17 @@ func (db *DB) txBeginContext(ctx context.Context, topts *sql.TxOptions) (*DB, er
 		panic("sqlpro.DB.Begin: Unable to call Begin on a Transaction.")
 	}
 
-	db2 := *db
-
 	wMode := topts == nil || !topts.ReadOnly
 
+	if !wMode && db.replicas != nil {
+		tx, err := db.beginReplica(ctx, topts)
+		if tx != nil || err != nil {
+			return tx, err
+		}
+	}
+
+	db2 := *db
+
 	// In case of write mode tx for SQLITE driver There's the need to start it
 	// as immediate so it gets a lock Not implemented in driver, therefore this
 	// raw SQL workaround Lock, so we can safely do the sqlite3 ROLLBACK / BEGIN
@@ -43,6 +60,8 @@ func (db *DB) txBeginContext(ctx context.Context, topts *sql.TxOptions) (*DB, er
 
 	// Set flag so we know if to allow write operations
 	db2.txWriteMode = wMode
+	db2.txStart = db.now()
+	db2.txStats = &txStats{}
 
 	if wMode && db.Driver == SQLITE3 {
 		_, err = db2.sqlTx.ExecContext(ctx, "ROLLBACK; BEGIN IMMEDIATE")
@@ -55,13 +74,27 @@ func (db *DB) txBeginContext(ctx context.Context, topts *sql.TxOptions) (*DB, er
 
 	db2.db = db2.sqlTx
 
+	err = db2.setSearchPath(ctx)
+	if err != nil {
+		db2.sqlTx.Rollback()
+		return nil, err
+	}
+
+	db.trackTx(ctx, &db2)
+
+	if wMode && db.TxWatchdog.MaxDuration > 0 {
+		db2.txWatchdog = time.AfterFunc(db.TxWatchdog.MaxDuration, db2.txWatchdogFire(db2.sqlTx))
+	}
+
 	// debug.PrintStack()
 
 	// pflib.Pln("[%p] BEGIN #%d %s", db.sqlDB, db2.transID, aurora.Blue(fmt.Sprintf("%p", db2.sqlTx)))
 
-	if db.DebugExec || db.Debug {
-		log.Printf("%s BEGIN: %s sql.DB: %p", db, &db2, db.sqlDB)
+	if db.debug(EXEC) {
+		db.logf("%s BEGIN: %s sql.DB: %p", db, &db2, db.sqlDB)
 	}
+	db2.txID = txIDs.Add(1)
+	db2.logEvent(ctx, "sqlpro begin", slog.Bool("write", wMode))
 
 	return &db2, nil
 }
@@ -86,8 +119,8 @@ func (db *DB) Commit() error {
 		panic("sqlpro.DB.Commit: Unable to call Commit without Transaction.")
 	}
 
-	if db.DebugExec || db.Debug {
-		log.Printf("%s COMMIT sql.DB: %p", db, db.sqlDB)
+	if db.debug(EXEC) {
+		db.logf("%s COMMIT sql.DB: %p", db, db.sqlDB)
 	}
 
 	// pflib.Pln("[%p] COMMIT #%d %s", db.sqlDB, db.transID, aurora.Blue(fmt.Sprintf("%p", db.sqlTx)))
@@ -96,15 +129,31 @@ func (db *DB) Commit() error {
 	// 	log.Printf("COMMIT WRITE #%d took %s", db.transID, time.Since(db.txStart))
 	// }
 
+	if db.LogTxStats {
+		db.logf("%s COMMIT %s", db, db.Stats())
+	}
+
+	db.stopTxWatchdog()
+	db.untrackTx()
 	err := db.sqlTx.Commit()
 	db.sqlTx = nil
+	db.logTxEnd("sqlpro commit", err)
 
 	if err != nil {
 		return err
 	}
 
+	db.captureCommitToken()
+
+	var errs []error
 	for _, f := range db.txAfterCommit {
-		f()
+		err = f(context.Background())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w %w", ErrAfterCommit, errors.Join(errs...))
 	}
 
 	return nil
@@ -115,8 +164,8 @@ func (db *DB) Rollback() error {
 		panic("sqlpro.DB.Rollback: Unable to call Rollback without Transaction.")
 	}
 
-	if db.DebugExec || db.Debug {
-		log.Printf("%s ROLLBACK", db)
+	if db.debug(EXEC) {
+		db.logf("%s ROLLBACK", db)
 	}
 
 	// debug.PrintStack()
@@ -126,8 +175,11 @@ func (db *DB) Rollback() error {
 	// 	log.Printf("ROLLBACK WRITE #%d took %s", db.transID, time.Since(db.txStart))
 	// }
 
+	db.stopTxWatchdog()
+	db.untrackTx()
 	err := db.sqlTx.Rollback()
 	db.sqlTx = nil
+	db.logTxEnd("sqlpro rollback", err)
 
 	if err != nil {
 		return err
@@ -155,10 +207,26 @@ func (db *DB) AfterTransaction(f func()) {
 	db.AfterRollback(f)
 }
 
+// AfterCommit registers f to run after a successful Commit. All funcs
+// registered with AfterCommit and AfterCommitErr run in the order of
+// registration (FIFO).
 func (db *DB) AfterCommit(f func()) {
 	if db.sqlTx == nil {
 		panic("sqlpro.DB.AfterCommit: Needs Transaction.")
 	}
+	db.txAfterCommit = append(db.txAfterCommit, func(context.Context) error {
+		f()
+		return nil
+	})
+}
+
+// AfterCommitErr is AfterCommit for funcs which can fail. All funcs run,
+// their errors are joined and returned by Commit wrapped in
+// ErrAfterCommit. The transaction is committed in that case.
+func (db *DB) AfterCommitErr(f func(ctx context.Context) error) {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.AfterCommitErr: Needs Transaction.")
+	}
 	db.txAfterCommit = append(db.txAfterCommit, f)
 }
 
@@ -172,3 +240,497 @@ func (db *DB) AfterRollback(f func()) {
 func (db *DB) IsWriteMode() bool {
 	return db.txWriteMode
 }
+
+// StartedAt returns the time the transaction was started, taken from
+// DB.Clock
+func (db *DB) StartedAt() time.Time {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.StartedAt: Needs Transaction.")
+	}
+	return db.txStart
+}
+
+// Age returns the time since the transaction was started
+func (db *DB) Age() time.Duration {
+	return db.now().Sub(db.StartedAt())
+}
+
+// TxStats are the statistics of a transaction, see Stats
+type TxStats struct {
+	Queries      int
+	Execs        int
+	RowsAffected int64
+	Duration     time.Duration // cumulative time of the statements, for queries until the rows are returned
+}
+
+func (ts TxStats) String() string {
+	return fmt.Sprintf("%d queries, %d execs, %d rows affected, took %s", ts.Queries, ts.Execs, ts.RowsAffected, ts.Duration)
+}
+
+type txStats struct {
+	mtx   sync.Mutex
+	stats TxStats
+}
+
+// add counts a statement, res is <nil> for queries
+func (ts *txStats) add(exec bool, start, stop time.Time, res sql.Result) {
+	if ts == nil {
+		return
+	}
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	if exec {
+		ts.stats.Execs++
+	} else {
+		ts.stats.Queries++
+	}
+	if res != nil {
+		n, err := res.RowsAffected()
+		if err == nil {
+			ts.stats.RowsAffected += n
+		}
+	}
+	ts.stats.Duration += stop.Sub(start)
+}
+
+// Stats returns the statistics of the statements run in the transaction.
+// Use this to find transactions running many small statements, which
+// should be bulked.
+func (db *DB) Stats() TxStats {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.Stats: Needs Transaction.")
+	}
+	db.txStats.mtx.Lock()
+	defer db.txStats.mtx.Unlock()
+	return db.txStats.stats
+}
+
+// TxWatchdog watches write transactions, see DB.TxWatchdog. Long running
+// write transactions block all other writers on SQLite.
+type TxWatchdog struct {
+	MaxDuration time.Duration // write transactions running longer are logged, 0 disables the watchdog
+	Rollback    bool          // if set, the transaction is rolled back, too
+}
+
+// txWatchdogFire returns the func run by the watchdog timer for sqlTx
+func (db *DB) txWatchdogFire(sqlTx *sql.Tx) func() {
+	return func() {
+		db.logf("%s Write transaction running longer than %s, started at %s.", db, db.TxWatchdog.MaxDuration, db.txStart)
+		if db.TxWatchdog.Rollback {
+			// further use of the transaction fails with sql.ErrTxDone
+			err := sqlTx.Rollback()
+			if err == nil {
+				db.logf("%s Write transaction rolled back by watchdog.", db)
+			}
+		}
+	}
+}
+
+func (db *DB) stopTxWatchdog() {
+	if db.txWatchdog != nil {
+		db.txWatchdog.Stop()
+		db.txWatchdog = nil
+	}
+}
+
+// savepointSeq numbers the savepoints set by ExecTX
+var savepointSeq atomic.Int64
+
+// Savepoint sets the savepoint name in the transaction
+func (db *DB) Savepoint(ctx context.Context, name string) error {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.Savepoint: Needs Transaction.")
+	}
+	return db.savepointStmt(ctx, "SAVEPOINT", name)
+}
+
+// RollbackTo rolls the transaction back to the savepoint name, the
+// savepoint stays set
+func (db *DB) RollbackTo(ctx context.Context, name string) error {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.RollbackTo: Needs Transaction.")
+	}
+	return db.savepointStmt(ctx, "ROLLBACK TO SAVEPOINT", name)
+}
+
+// ReleaseSavepoint removes the savepoint name, keeping the changes made
+// after it
+func (db *DB) ReleaseSavepoint(ctx context.Context, name string) error {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.ReleaseSavepoint: Needs Transaction.")
+	}
+	return db.savepointStmt(ctx, "RELEASE SAVEPOINT", name)
+}
+
+// savepointStmt runs the savepoint statement directly on the transaction.
+// It is no write, so it also works in read-only transactions.
+func (db *DB) savepointStmt(ctx context.Context, stmt, name string) error {
+	if db.debug(EXEC) {
+		db.logf("%s %s %s", db, stmt, name)
+	}
+	_, err := db.sqlTx.ExecContext(ctx, stmt+" "+db.Esc(name))
+	return err
+}
+
+// DeferConstraints defers the checks of the given constraints (all
+// deferrable constraints if none are given) until the end of the
+// transaction. On Postgres this uses SET CONSTRAINTS, on SQLite PRAGMA
+// defer_foreign_keys, which only supports deferring all foreign keys.
+// The returned restore func makes the constraints immediate again, which
+// checks the pending changes. Deferring ends with the transaction anyway.
+func (db *DB) DeferConstraints(ctx context.Context, names ...string) (restore func() error, err error) {
+	if db.sqlTx == nil {
+		panic("sqlpro.DB.DeferConstraints: Needs Transaction.")
+	}
+
+	switch db.Driver {
+	case POSTGRES:
+		constraints := "ALL"
+		if len(names) > 0 {
+			constraints = db.escJoin(names)
+		}
+		err = db.ExecContext(ctx, "SET CONSTRAINTS "+constraints+" DEFERRED")
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			return db.ExecContext(ctx, "SET CONSTRAINTS "+constraints+" IMMEDIATE")
+		}, nil
+	case SQLITE3:
+		if len(names) > 0 {
+			return nil, fmt.Errorf("DeferConstraints: %s can only defer all foreign keys.", db.Driver)
+		}
+		err = db.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON")
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			return db.ExecContext(ctx, "PRAGMA defer_foreign_keys = OFF")
+		}, nil
+	default:
+		return nil, fmt.Errorf("DeferConstraints: Unsupported driver %q.", db.Driver)
+	}
+}
+
+// TxRetry is the retry policy of ExecTX, see DB.TxRetry
+type TxRetry struct {
+	Max     int                           // number of retries, 0 disables retries
+	Backoff func(retry int) time.Duration // wait before the retry (1-based), can be <nil>
+}
+
+// IsRetryableTxError returns true if err is a transient transaction
+// error, i.e. a serialization failure (40001) or deadlock (40P01) on
+// Postgres and CockroachDB or a busy database on SQLite
+func IsRetryableTxError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if code, ok := sqlState(err); ok {
+		return code == "40001" || code == "40P01"
+	}
+	// avoid depending on the sqlite3 driver, this is SQLITE_BUSY
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// ExecTX runs fn inside a write transaction, which is committed if fn
+// returns <nil> and rolled back otherwise. If db is a transaction already,
+// fn runs inside a savepoint of it instead, which is released or rolled
+// back, so nested units of work compose.
+//
+// If the transaction fails with an error for which IsRetryableTxError is
+// true, it is rerun according to db.TxRetry. Nested calls are not retried
+// themselves, the error aborts the outer transaction.
+func (db *DB) ExecTX(ctx context.Context, fn func(tx *DB) error) error {
+	return db.ExecTXOptions(ctx, TxOptions{}, fn)
+}
+
+// TxOptions are the options of ExecTXOptions. The timeouts and Deferrable
+// are only supported by Postgres and ignored otherwise.
+type TxOptions struct {
+	Isolation        sql.IsolationLevel
+	ReadOnly         bool
+	Deferrable       bool          // only useful with ReadOnly and sql.LevelSerializable
+	LockTimeout      time.Duration // SET LOCAL lock_timeout, 0 keeps the default
+	StatementTimeout time.Duration // SET LOCAL statement_timeout, 0 keeps the default
+}
+
+// ExecTXOptions is ExecTX starting the transaction with opts. Nested calls
+// run in a savepoint of the outer transaction and ignore opts.
+func (db *DB) ExecTXOptions(ctx context.Context, opts TxOptions, fn func(tx *DB) error) error {
+	if db.sqlTx != nil {
+		return db.execSavepoint(ctx, fn)
+	}
+
+	for retry := 0; ; retry++ {
+		err := db.execTX(ctx, opts, fn)
+		if err == nil || retry >= db.txRetry().Max || !IsRetryableTxError(err) || errors.Is(err, ErrAfterCommit) {
+			return err
+		}
+		if db.Cockroach {
+			// retried inside the transaction by execCockroach
+			return err
+		}
+		if !db.txRetryWait(ctx, retry+1) {
+			return err
+		}
+	}
+}
+
+// txRetryWait waits the TxRetry.Backoff for retry and returns false if
+// the ctx is done before
+func (db *DB) txRetryWait(ctx context.Context, retry int) bool {
+	backoff := db.txRetry().Backoff
+	if backoff == nil {
+		return true
+	}
+	timer := time.NewTimer(backoff(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// cockroachRestart is the savepoint of the CockroachDB client-side
+// transaction retry protocol
+const cockroachRestart = "cockroach_restart"
+
+// execCockroach runs fn in the transaction db using the client-side retry
+// protocol of CockroachDB: fn runs after SAVEPOINT cockroach_restart, on a
+// retryable error the transaction is rolled back to the savepoint and fn
+// runs again, up to TxRetry.Max times. Funcs registered by failed runs of
+// fn with AfterCommit are dropped, those registered with AfterRollback run.
+func (db *DB) execCockroach(ctx context.Context, fn func(tx *DB) error) error {
+	_, err := db.sqlTx.ExecContext(ctx, "SAVEPOINT "+cockroachRestart)
+	if err != nil {
+		return err
+	}
+
+	for retry := 0; ; retry++ {
+		afterCommit, afterRollback := len(db.txAfterCommit), len(db.txAfterRollback)
+
+		err = fn(db)
+		if err == nil {
+			_, err = db.sqlTx.ExecContext(ctx, "RELEASE SAVEPOINT "+cockroachRestart)
+			if err == nil {
+				return db.Commit()
+			}
+		}
+		if retry >= db.txRetry().Max || !IsRetryableTxError(err) {
+			return err
+		}
+
+		_, rbErr := db.sqlTx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+cockroachRestart)
+		if rbErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %s)", err, rbErr)
+		}
+		for _, f := range db.txAfterRollback[afterRollback:] {
+			f()
+		}
+		db.txAfterCommit = db.txAfterCommit[:afterCommit]
+		db.txAfterRollback = db.txAfterRollback[:afterRollback]
+
+		if !db.txRetryWait(ctx, retry+1) {
+			return err
+		}
+	}
+}
+
+// execSavepoint runs fn inside a savepoint of the transaction db
+func (db *DB) execSavepoint(ctx context.Context, fn func(tx *DB) error) error {
+	name := fmt.Sprintf("sqlpro_sp_%d", savepointSeq.Add(1))
+	err := db.Savepoint(ctx, name)
+	if err != nil {
+		return err
+	}
+	afterCommit, afterRollback := len(db.txAfterCommit), len(db.txAfterRollback)
+	err = fn(db)
+	if err != nil {
+		rbErr := db.RollbackTo(ctx, name)
+		if rbErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %s)", err, rbErr)
+		}
+		// the work of fn is undone, as with execCockroach
+		for _, f := range db.txAfterRollback[afterRollback:] {
+			f()
+		}
+		db.txAfterCommit = db.txAfterCommit[:afterCommit]
+		db.txAfterRollback = db.txAfterRollback[:afterRollback]
+		return err
+	}
+	return db.ReleaseSavepoint(ctx, name)
+}
+
+// execTX runs fn inside a new write transaction
+func (db *DB) execTX(ctx context.Context, opts TxOptions, fn func(tx *DB) error) error {
+	tx, err := db.BeginContext(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx.ActiveTX() {
+			tx.Rollback()
+		}
+	}()
+
+	if db.Driver == POSTGRES {
+		// use the sql.Tx directly, these are allowed in read-only
+		// transactions
+		if opts.Deferrable {
+			_, err = tx.sqlTx.ExecContext(ctx, "SET TRANSACTION DEFERRABLE")
+			if err != nil {
+				return err
+			}
+		}
+		if opts.LockTimeout > 0 {
+			_, err = tx.sqlTx.ExecContext(ctx, fmt.Sprintf("SET LOCAL lock_timeout = %d", opts.LockTimeout.Milliseconds()))
+			if err != nil {
+				return err
+			}
+		}
+		if opts.StatementTimeout > 0 {
+			_, err = tx.sqlTx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", opts.StatementTimeout.Milliseconds()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if db.Cockroach {
+		return tx.execCockroach(ctx, fn)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshotPool keeps idle read-only transactions for SnapshotRead
+type snapshotPool struct {
+	mtx  sync.Mutex
+	idle []*snapshotTx
+}
+
+type snapshotTx struct {
+	tx      *DB
+	started time.Time
+	expire  *time.Timer // rolls back the idle snapshot once it expired
+}
+
+// get returns an idle snapshot not older than maxAge at now, expired
+// snapshots found on the way are rolled back
+func (sp *snapshotPool) get(now time.Time, maxAge time.Duration) *snapshotTx {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	for len(sp.idle) > 0 {
+		snap := sp.idle[len(sp.idle)-1]
+		sp.idle = sp.idle[:len(sp.idle)-1]
+		// a timer which already fired no longer finds snap in idle
+		snap.expire.Stop()
+		if now.Sub(snap.started) < maxAge {
+			return snap
+		}
+		snap.tx.Rollback()
+	}
+	return nil
+}
+
+// put returns the snapshot into the pool, or rolls it back if it expired
+// at now. Idle snapshots are rolled back once they expire, so they don't
+// keep a connection and hold back the cleanup of old row versions.
+func (sp *snapshotPool) put(snap *snapshotTx, now time.Time, maxAge time.Duration) {
+	left := maxAge - now.Sub(snap.started)
+	if left <= 0 {
+		snap.tx.Rollback()
+		return
+	}
+	sp.mtx.Lock()
+	sp.idle = append(sp.idle, snap)
+	snap.expire = time.AfterFunc(left, func() {
+		sp.expireSnapshot(snap)
+	})
+	sp.mtx.Unlock()
+}
+
+// expireSnapshot rolls back snap if it is still idle
+func (sp *snapshotPool) expireSnapshot(snap *snapshotTx) {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	for idx, idle := range sp.idle {
+		if idle == snap {
+			sp.idle = append(sp.idle[:idx], sp.idle[idx+1:]...)
+			snap.tx.Rollback()
+			return
+		}
+	}
+}
+
+// closeAll rolls back all idle snapshots
+func (sp *snapshotPool) closeAll() {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	for _, snap := range sp.idle {
+		snap.expire.Stop()
+		snap.tx.Rollback()
+	}
+	sp.idle = nil
+}
+
+// SnapshotRead runs fn inside a read-only transaction. The transaction is
+// taken from a pool of idle transactions which are not older than
+// SnapshotMaxAge, so callers within that window share the BEGIN/COMMIT
+// overhead. On Postgres the transactions run in REPEATABLE READ, so all
+// queries of a transaction see the same snapshot, which may be up to
+// SnapshotMaxAge old. Use BeginRead if you need a fresh snapshot.
+//
+// A transaction is only used by one fn at a time. If fn returns an error
+// the transaction is rolled back and not reused.
+func (db *DB) SnapshotRead(ctx context.Context, fn func(tx *DB) error) error {
+	if db.sqlTx != nil {
+		panic("sqlpro.DB.SnapshotRead: Unable to call SnapshotRead on a Transaction.")
+	}
+
+	snap := db.snapshotPool.get(db.now(), db.SnapshotMaxAge)
+	if snap == nil {
+		topts := &sql.TxOptions{ReadOnly: true}
+		if db.Driver == POSTGRES {
+			topts.Isolation = sql.LevelRepeatableRead
+		}
+		// The pooled transaction outlives ctx, the sql package would roll it
+		// back as soon as ctx is done.
+		tx, err := db.txBeginContext(context.Background(), topts)
+		if err != nil {
+			return err
+		}
+		// pooled transactions are no orphans
+		tx.untrackTx()
+		snap = &snapshotTx{tx: tx, started: db.now()}
+	}
+
+	pooled := false
+	defer func() {
+		// also rolls back if fn panics
+		if !pooled {
+			snap.tx.Rollback()
+		}
+	}()
+
+	err := ctx.Err()
+	if err == nil {
+		err = fn(snap.tx)
+	}
+	if err != nil {
+		return err
+	}
+
+	db.snapshotPool.put(snap, db.now(), db.SnapshotMaxAge)
+	pooled = true
+	return nil
+}
@@ -1,12 +1,15 @@
 package sqlpro
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"math/rand"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -192,3 +195,611 @@ func TestTwoConnections(t *testing.T) {
 	db2.Commit()
 
 }
+
+func TestSnapshotRead(t *testing.T) {
+	var tx1, tx2 *DB
+
+	err := db.SnapshotRead(context.Background(), func(tx *DB) error {
+		tx1 = tx
+		return readRow(tx)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = db.SnapshotRead(context.Background(), func(tx *DB) error {
+		tx2 = tx
+		return readRow(tx)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if tx1 != tx2 {
+		t.Errorf("Expected the snapshot transaction to be reused.")
+	}
+
+	err = db.SnapshotRead(context.Background(), func(tx *DB) error {
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Errorf("Expected error to be returned.")
+	}
+
+	err = db.SnapshotRead(context.Background(), func(tx *DB) error {
+		if tx == tx1 {
+			t.Errorf("Expected a new transaction after an error.")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// a panicking fn rolls back the transaction
+	var txPanic *DB
+	func() {
+		defer func() {
+			recover()
+		}()
+		db.SnapshotRead(context.Background(), func(tx *DB) error {
+			txPanic = tx
+			panic("failed")
+		})
+	}()
+	if txPanic == nil || txPanic.ActiveTX() {
+		t.Errorf("Expected the transaction to be rolled back after a panic.")
+	}
+
+	// idle snapshots expire without further calls
+	time.Sleep(2 * db.SnapshotMaxAge)
+	db.snapshotPool.mtx.Lock()
+	idle := len(db.snapshotPool.idle)
+	db.snapshotPool.mtx.Unlock()
+	if idle != 0 {
+		t.Errorf("Expected idle snapshots to expire, %d left.", idle)
+	}
+
+	db.snapshotPool.closeAll()
+}
+
+func TestWriteBatcher(t *testing.T) {
+	wb := db.NewWriteBatcher(20 * time.Millisecond)
+
+	rows := make([]*testRow, 0)
+	results := make([]error, 10)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		row := &testRow{B: "batched", C: fmt.Sprintf("batched %d", i)}
+		rows = append(rows, row)
+		wg.Add(1)
+		idx := i
+		wb.Insert("test", row, func(err error) {
+			results[idx] = err
+			wg.Done()
+		})
+	}
+
+	// this one fails, the others have to be retried one by one
+	err := wb.InsertWait(context.Background(), "missing_table", &testRow{B: "batched"})
+	if err == nil {
+		t.Errorf("Expected insert into missing table to fail.")
+	}
+
+	wg.Wait()
+	wb.Close()
+
+	for idx, row := range rows {
+		if results[idx] != nil {
+			t.Error(results[idx])
+		}
+		if row.A <= 0 {
+			t.Errorf("rows[%d].A needs to be set (pk).", idx)
+		}
+	}
+
+	var count int64
+	err = db.Query(&count, "SELECT count(*) FROM test WHERE b = 'batched'")
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 10 {
+		t.Errorf("Expected 10 batched rows, got %d.", count)
+	}
+}
+
+func TestCheckpointJob(t *testing.T) {
+	errStop := errors.New("stop")
+	seen := map[int64]bool{}
+	batches := 0
+
+	job := CheckpointJob{
+		Name:       "test",
+		Table:      "test",
+		PrimaryKey: "a",
+		BatchSize:  500,
+		Process: func(tx *DB, pks []int64) error {
+			batches++
+			if batches == 2 {
+				// simulate an interruption
+				return errStop
+			}
+			for _, pk := range pks {
+				if seen[pk] {
+					t.Errorf("Primary key %d processed twice.", pk)
+				}
+				seen[pk] = true
+			}
+			return nil
+		},
+	}
+	defer db.Exec("DROP TABLE sqlpro_checkpoint")
+
+	processed, err := db.RunCheckpointJob(context.Background(), job)
+	if !errors.Is(err, errStop) {
+		t.Errorf("Expected job to be interrupted: %v", err)
+	}
+	if processed != 500 {
+		t.Errorf("Expected 500 rows processed, got %d.", processed)
+	}
+
+	_, err = db.RunCheckpointJob(context.Background(), job)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var count int64
+	err = db.Query(&count, "SELECT count(*) FROM test")
+	if err != nil {
+		t.Error(err)
+	}
+	if int64(len(seen)) != count {
+		t.Errorf("Expected %d rows processed, got %d.", count, len(seen))
+	}
+
+	err = db.ResetCheckpoint(context.Background(), job)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCommitToken(t *testing.T) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Insert("test", &testRow{C: "token"})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SQLite has no replicas, the token is empty
+	if tx.Token() != "" {
+		t.Errorf("Expected empty token, got %q.", tx.Token())
+	}
+	err = db.WaitFor(tx.Token(), time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecTXSavepoint(t *testing.T) {
+	ctx := context.Background()
+
+	err := db.Exec("CREATE TABLE savepoint(id INTEGER PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hooks []string
+	err = db.ExecTX(ctx, func(tx *DB) error {
+		err := tx.Exec("INSERT INTO savepoint(name) VALUES ('outer')")
+		if err != nil {
+			return err
+		}
+		err = tx.ExecTX(ctx, func(tx *DB) error {
+			tx.AfterCommit(func() {
+				hooks = append(hooks, "inner failed commit")
+			})
+			tx.AfterRollback(func() {
+				hooks = append(hooks, "inner failed rollback")
+			})
+			err := tx.Exec("INSERT INTO savepoint(name) VALUES ('inner failed')")
+			if err != nil {
+				return err
+			}
+			return errors.New("failed")
+		})
+		if err == nil {
+			t.Errorf("Expected error to be returned.")
+		}
+		return tx.ExecTX(ctx, func(tx *DB) error {
+			return tx.Exec("INSERT INTO savepoint(name) VALUES ('inner')")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err = db.Query(&names, "SELECT name FROM savepoint ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[outer inner]" {
+		t.Errorf("Expected [outer inner], got %v", names)
+	}
+	if fmt.Sprint(hooks) != "[inner failed rollback]" {
+		t.Errorf("Expected [inner failed rollback], got %v", hooks)
+	}
+
+	// savepoints are no writes
+	err = db.ExecTXOptions(ctx, TxOptions{ReadOnly: true}, func(tx *DB) error {
+		return tx.ExecTX(ctx, func(tx *DB) error {
+			var count int
+			return tx.Query(&count, "SELECT COUNT(*) FROM savepoint")
+		})
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = db.ExecTX(ctx, func(tx *DB) error {
+		err := tx.Exec("INSERT INTO savepoint(name) VALUES ('rolled back')")
+		if err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Errorf("Expected error to be returned.")
+	}
+	var count int
+	err = db.Query(&count, "SELECT COUNT(*) FROM savepoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 rows, got %d", count)
+	}
+}
+
+func TestExecTXRetry(t *testing.T) {
+	ctx := context.Background()
+	busy := errors.New("database is locked")
+
+	db2 := *db
+	db2.TxRetry = TxRetry{Max: 2, Backoff: func(retry int) time.Duration {
+		return time.Duration(retry) * time.Millisecond
+	}}
+
+	calls := 0
+	err := db2.ExecTX(ctx, func(tx *DB) error {
+		calls++
+		if calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+
+	calls = 0
+	err = db2.ExecTX(ctx, func(tx *DB) error {
+		calls++
+		return busy
+	})
+	if err != busy {
+		t.Errorf("Expected busy error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+
+	calls = 0
+	err = db2.ExecTX(ctx, func(tx *DB) error {
+		calls++
+		return errors.New("failed")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected no retry for other errors, got %d calls", calls)
+	}
+}
+
+func TestDeferConstraints(t *testing.T) {
+	ctx := context.Background()
+
+	err := db.Exec(`CREATE TABLE defer_parent(id INTEGER PRIMARY KEY);
+		CREATE TABLE defer_child(id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES defer_parent(id))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ExecTX(ctx, func(tx *DB) error {
+		_, err := tx.DeferConstraints(ctx, "fk")
+		if err == nil {
+			t.Errorf("Expected error for named constraints on sqlite.")
+		}
+
+		restore, err := tx.DeferConstraints(ctx)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("INSERT INTO defer_child VALUES (1, 1)")
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("INSERT INTO defer_parent VALUES (1)")
+		if err != nil {
+			return err
+		}
+		return restore()
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = db.ExecTX(ctx, func(tx *DB) error {
+		return tx.Exec("INSERT INTO defer_child VALUES (2, 2)")
+	})
+	if err == nil {
+		t.Errorf("Expected foreign key error without deferring.")
+	}
+}
+
+func TestExecTXOptions(t *testing.T) {
+	ctx := context.Background()
+
+	err := db.ExecTXOptions(ctx, TxOptions{ReadOnly: true, StatementTimeout: time.Second}, func(tx *DB) error {
+		if tx.IsWriteMode() {
+			t.Errorf("Expected read-only transaction.")
+		}
+		return readRow(tx)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = db.ExecTXOptions(ctx, TxOptions{ReadOnly: true}, func(tx *DB) error {
+		return saveRow(tx, 1)
+	})
+	if err == nil {
+		t.Errorf("Expected write in read-only transaction to fail.")
+	}
+}
+
+func TestAfterCommitErr(t *testing.T) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []int{}
+	tx.AfterCommit(func() {
+		order = append(order, 1)
+	})
+	tx.AfterCommitErr(func(ctx context.Context) error {
+		order = append(order, 2)
+		return errors.New("cache invalidation failed")
+	})
+	tx.AfterCommitErr(func(ctx context.Context) error {
+		order = append(order, 3)
+		return nil
+	})
+
+	err = tx.Commit()
+	if !errors.Is(err, ErrAfterCommit) {
+		t.Errorf("Expected ErrAfterCommit, got %v", err)
+	}
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Errorf("Expected [1 2 3], got %v", order)
+	}
+}
+
+func TestTxWatchdog(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	db2 := *db
+	db2.Clock = &fakeClock{now: start.Add(-time.Second)}
+	db2.TxWatchdog = TxWatchdog{MaxDuration: 10 * time.Millisecond, Rollback: true}
+
+	tx, err := db2.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.StartedAt().Equal(start) {
+		t.Errorf("Expected start %s, got %s.", start, tx.StartedAt())
+	}
+	if tx.Age() != time.Second {
+		t.Errorf("Expected age 1s, got %s.", tx.Age())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	err = tx.Commit()
+	if !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("Expected transaction rolled back by watchdog, got: %v", err)
+	}
+}
+
+func TestRollbackOrphans(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		causes []error
+	)
+
+	db2 := *db
+	db2.TxOrphanHook = func(tx *DB, cause error) {
+		mtx.Lock()
+		causes = append(causes, cause)
+		mtx.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := db2.BeginContext(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// the rollback runs in its own goroutine
+	for idx := 0; idx < 100; idx++ {
+		mtx.Lock()
+		done := len(causes) > 0
+		mtx.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	err = tx.Commit()
+	if !errors.Is(err, sql.ErrTxDone) && !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected rolled back transaction, got: %v", err)
+	}
+
+	_, err = db2.BeginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := db2.RollbackOrphans(); n != 1 {
+		t.Errorf("Expected 1 orphan, got %d.", n)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(causes) != 2 || !errors.Is(causes[0], context.Canceled) || !errors.Is(causes[1], ErrTxOrphan) {
+		t.Errorf("Unexpected orphan causes: %v", causes)
+	}
+}
+
+func TestExecMulti(t *testing.T) {
+	ctx := context.Background()
+
+	err := db.Exec("CREATE TABLE multi(id INTEGER PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ExecMulti(ctx, []Stmt{
+		{SQL: "INSERT INTO multi(id, name) VALUES (?, ?)", Args: []interface{}{1, "henk"}, ExpectRows: 1},
+		{SQL: "INSERT INTO multi(id, name) VALUES (?, ?)", Args: []interface{}{2, "horst"}},
+		{SQL: "DELETE FROM multi WHERE id = ?", Args: []interface{}{3}, ExpectRows: ExpectNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ExecMulti(ctx, []Stmt{
+		{SQL: "UPDATE multi SET name = ? WHERE id = ?", Args: []interface{}{"torsten", 1}, ExpectRows: 1},
+		{SQL: "UPDATE multi SET name = ?", Args: []interface{}{"all"}, ExpectRows: 1},
+	})
+	if !errors.Is(err, ErrMismatchedRowsAffected) {
+		t.Errorf("Expected ErrMismatchedRowsAffected, got: %v", err)
+	}
+
+	var name string
+	err = db.Query(&name, "SELECT name FROM multi WHERE id = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "henk" {
+		t.Errorf("Expected the first update to be rolled back, got name %q.", name)
+	}
+}
+
+func TestTxStats(t *testing.T) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.Query(&count, "SELECT COUNT(*) FROM test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Exec("UPDATE test SET c = c WHERE a <= ?", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := tx.Stats()
+	if stats.Queries != 1 || stats.Execs != 1 || stats.RowsAffected != 2 {
+		t.Errorf("Unexpected stats: %s", stats)
+	}
+}
+
+func TestSnapshotExportUnsupported(t *testing.T) {
+	_, err := db.BeginReadWithSnapshot(context.Background(), "00000003-0000001B-1")
+	if err == nil {
+		t.Errorf("Expected BeginReadWithSnapshot to fail on sqlite.")
+	}
+
+	tx, err := db.BeginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExportSnapshot(context.Background())
+	if err == nil {
+		t.Errorf("Expected ExportSnapshot to fail on sqlite.")
+	}
+}
+
+func TestCockroachRetry(t *testing.T) {
+	ctx := context.Background()
+
+	err := db.Exec("CREATE TABLE cockroach(id INTEGER PRIMARY KEY)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db2 := *db
+	db2.Cockroach = true
+	db2.TxRetry = TxRetry{Max: 2}
+
+	runs := 0
+	committed := 0
+	err = db2.ExecTX(ctx, func(tx *DB) error {
+		runs++
+		tx.AfterCommit(func() {
+			committed++
+		})
+		err := tx.Exec("INSERT INTO cockroach(id) VALUES (?)", 1)
+		if err != nil {
+			return err
+		}
+		if runs == 1 {
+			return &pq.Error{Code: "40001", Message: "restart transaction"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 || committed != 1 {
+		t.Errorf("Expected 2 runs and 1 after commit func, got %d and %d.", runs, committed)
+	}
+
+	var count int
+	err = db.Query(&count, "SELECT COUNT(*) FROM cockroach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 row, got %d.", count)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,6 +18,10 @@ import (
 
 var ErrQueryReturnedZeroRows error = errors.New("Query returned 0 rows.")
 var ErrMismatchedRowsAffected error = errors.New("Mismatched rows affected.")
+var ErrUnmappedColumns error = errors.New("Unmapped columns.")
+var ErrMissingColumns error = errors.New("Missing columns.")
+var ErrReadOnly error = errors.New("Write using read-only handle.")
+var ErrReadOnlyModel error = errors.New("Write using read-only model.")
 
 // structInfo is a map to fieldInfo by db_name
 type structInfo map[string]*fieldInfo
@@ -54,6 +60,8 @@ func (si structInfo) onlyPrimaryKey() *fieldInfo {
 type NullTime struct {
 	Time  time.Time
 	Valid bool
+
+	layouts []string // accepted besides RFC3339 for string values, see DB.TimeLayouts
 }
 
 // Scan implements the Scanner interface.
@@ -70,6 +78,12 @@ func (ni *NullTime) Scan(value interface{}) error {
 		ni.Valid = true
 	case string:
 		ni.Time, err = time.Parse(time.RFC3339Nano, v)
+		for _, layout := range ni.layouts {
+			if err == nil {
+				break
+			}
+			ni.Time, err = time.Parse(layout, v)
+		}
 		if err != nil {
 			return errors.Wrap(err, "NullTime.Scan")
 		}
@@ -82,6 +96,23 @@ func (ni *NullTime) Scan(value interface{}) error {
 
 }
 
+// nullDuration scans a number of seconds into a time.Duration
+type nullDuration struct {
+	Duration time.Duration
+	Valid    bool
+}
+
+func (nd *nullDuration) Scan(value interface{}) error {
+	var f sql.NullFloat64
+	err := f.Scan(value)
+	if err != nil {
+		return errors.Wrap(err, "nullDuration.Scan")
+	}
+	nd.Duration = time.Duration(f.Float64 * float64(time.Second))
+	nd.Valid = f.Valid
+	return nil
+}
+
 type NullJson struct {
 	Data  []byte
 	Valid bool
@@ -148,8 +179,44 @@ type fieldInfo struct {
 	readOnly    bool
 	notNull     bool
 	isJson      bool
+	isArray     bool // written and scanned as Postgres array using pq.Array
+	isDuration  bool // time.Duration written and scanned as seconds
 	emptyValue  string
-	ptr         bool // set true if the field is a pointer
+	ptr         bool   // set true if the field is a pointer
+	anonymize   string // "hash", "null" or "fake", used by the anonymized export
+	check       string // check constraint from the "check" tag
+	comment     string // column comment, see SyncComments
+	checks      []checkTerm
+	path        []string // names of the nested structs containing the field, see "prefix" and "flatten"
+}
+
+// fieldValue returns the field of struct value v described by fi. If a
+// nested struct pointer is <nil>, the zero value of the field is returned.
+func (fi *fieldInfo) fieldValue(v reflect.Value) reflect.Value {
+	for _, name := range fi.path {
+		v = v.FieldByName(name)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Zero(fi.structField.Type)
+			}
+			v = v.Elem()
+		}
+	}
+	return v.FieldByName(fi.name)
+}
+
+// fieldValueAlloc is fieldValue allocating <nil> nested struct pointers
+func (fi *fieldInfo) fieldValueAlloc(v reflect.Value) reflect.Value {
+	for _, name := range fi.path {
+		v = v.FieldByName(name)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+	}
+	return v.FieldByName(fi.name)
 }
 
 // allowNull returns true if the given can store "null" values
@@ -166,9 +233,78 @@ func (fi *fieldInfo) allowNull() bool {
 	return false
 }
 
-// getStructInfo returns a per dbName to fieldInfo map
+// structInfoCache caches the structInfoCacheEntry per reflect.Type
+var (
+	structInfoCache       sync.Map
+	structInfoGeneration  atomic.Int64 // incremented by ResetStructInfoCache
+	structInfoCacheHits   atomic.Uint64
+	structInfoCacheMisses atomic.Uint64
+)
+
+// unexportedFields holds the names of the unexported fields with a "db" tag
+// per reflect.Type, these fields are skipped, see warnUnexported
+var unexportedFields sync.Map
+
+type structInfoCacheEntry struct {
+	generation int64
+	si         structInfo
+}
+
+// StructInfoCacheStats are the statistics of the global cache of struct
+// mappings, see GetStructInfoCacheStats
+type StructInfoCacheStats struct {
+	Types      int    // number of struct types cached
+	Fields     int    // number of fields of all cached types
+	Hits       uint64 // since the last reset
+	Misses     uint64 // since the last reset
+	Generation int64  // number of resets
+}
+
+// GetStructInfoCacheStats returns the statistics of the global cache of
+// struct mappings
+func GetStructInfoCacheStats() StructInfoCacheStats {
+	stats := StructInfoCacheStats{
+		Hits:       structInfoCacheHits.Load(),
+		Misses:     structInfoCacheMisses.Load(),
+		Generation: structInfoGeneration.Load(),
+	}
+	structInfoCache.Range(func(_, value any) bool {
+		stats.Types++
+		stats.Fields += len(value.(structInfoCacheEntry).si)
+		return true
+	})
+	return stats
+}
+
+// ResetStructInfoCache drops all cached struct mappings. Mappings cached
+// concurrently with the reset are not used anymore. Use this after
+// changing the mapping of types at runtime.
+func ResetStructInfoCache() {
+	structInfoGeneration.Add(1)
+	structInfoCache.Clear()
+	structInfoCacheHits.Store(0)
+	structInfoCacheMisses.Store(0)
+}
+
+// getStructInfo returns a per dbName to fieldInfo map. The result is cached
+// and shared, it must not be modified.
 func getStructInfo(t reflect.Type) structInfo {
+	generation := structInfoGeneration.Load()
+	cached, ok := structInfoCache.Load(t)
+	if ok && cached.(structInfoCacheEntry).generation == generation {
+		structInfoCacheHits.Add(1)
+		return cached.(structInfoCacheEntry).si
+	}
+	structInfoCacheMisses.Add(1)
+	si := buildStructInfo(t)
+	structInfoCache.Store(t, structInfoCacheEntry{generation: generation, si: si})
+	return si
+}
+
+// buildStructInfo reflects the struct type t
+func buildStructInfo(t reflect.Type) structInfo {
 	si := structInfo{}
+	skipped := []string{}
 
 	// Resolve anonymous fields
 	for i := 0; i < t.NumField(); i++ {
@@ -181,6 +317,9 @@ func getStructInfo(t reflect.Type) structInfo {
 			for dbName, info := range getStructInfo(field.Type) {
 				si[dbName] = info
 			}
+			if names, ok := unexportedFields.Load(field.Type); ok {
+				skipped = append(skipped, names.([]string)...)
+			}
 		}
 	}
 
@@ -204,8 +343,9 @@ func getStructInfo(t reflect.Type) structInfo {
 		}
 
 		if field.PkgPath != "" {
-			// unexported field
-			panic(fmt.Errorf("getStructInfo: Unable to use unexported field for sqlpro: %s", field.Name))
+			// unexported field, reported by warnUnexported
+			skipped = append(skipped, field.Name)
+			continue
 		}
 
 		info := fieldInfo{
@@ -237,10 +377,41 @@ func getStructInfo(t reflect.Type) structInfo {
 			info.dbName = field.Name
 		}
 
+		info.check = field.Tag.Get("check")
+		if info.check != "" {
+			info.checks = parseCheck(info.check)
+		}
+
+		prefix := false
+		flatten := false
+		group := false
+		if strings.HasPrefix(info.dbName, "group:") {
+			// "group:<prefix>" maps the key struct of a GROUP BY result,
+			// the fields are named "<prefix>_<column>" or "<column>"
+			// for "group:"
+			info.dbName = strings.TrimPrefix(info.dbName, "group:")
+			prefix = true
+			group = true
+		}
+
 		for idx, p := range path {
 			if idx == 0 {
 				continue
 			}
+			if strings.HasPrefix(p, "comment=") {
+				// the comment is the last option and may contain commas
+				info.comment = strings.TrimPrefix(strings.Join(path[idx:], ","), "comment=")
+				break
+			}
+			if strings.HasPrefix(p, "anonymize=") {
+				info.anonymize = strings.TrimPrefix(p, "anonymize=")
+				switch info.anonymize {
+				case "hash", "null", "fake":
+				default:
+					panic(fmt.Errorf("getStructInfo: Unknown anonymize mode %q for field: %s", info.anonymize, field.Name))
+				}
+				continue
+			}
 			switch p {
 			case "pk":
 				info.primaryKey = true
@@ -252,13 +423,69 @@ func getStructInfo(t reflect.Type) structInfo {
 				info.notNull = true
 			case "json":
 				info.isJson = true
+			case "array":
+				info.isArray = true
+			case "duration":
+				info.isDuration = true
 			case "readonly":
 				info.readOnly = true
+			case "prefix":
+				prefix = true
+			case "flatten":
+				flatten = true
 			default:
 				// ignore unrecognized
 			}
 		}
 
+		if flatten {
+			// Map the fields of the nested struct to sibling columns,
+			// these are read and written
+			if field.Type.Kind() != reflect.Struct {
+				panic(fmt.Errorf("getStructInfo: flatten needs a struct field: %s", field.Name))
+			}
+			for _, nested := range getStructInfo(field.Type) {
+				nestedInfo := *nested
+				nestedInfo.path = append([]string{field.Name}, nested.path...)
+				if _, ok := si[nestedInfo.dbName]; ok {
+					// direct fields win
+					continue
+				}
+				si[nestedInfo.dbName] = &nestedInfo
+			}
+			continue
+		}
+
+		if prefix {
+			// Map the fields of the nested struct to "<name>_<column>",
+			// these are only read, e.g. from a JOIN. A pointer to the
+			// nested struct is left <nil> if all its columns are NULL.
+			nestedType := field.Type
+			if nestedType.Kind() == reflect.Ptr {
+				nestedType = nestedType.Elem()
+			}
+			if nestedType.Kind() != reflect.Struct {
+				panic(fmt.Errorf("getStructInfo: prefix needs a struct field: %s", field.Name))
+			}
+			for _, nested := range getStructInfo(nestedType) {
+				nestedInfo := *nested
+				nestedInfo.path = append([]string{field.Name}, nested.path...)
+				if group && info.dbName == "" {
+					nestedInfo.dbName = nested.dbName
+				} else {
+					nestedInfo.dbName = info.dbName + "_" + nested.dbName
+				}
+				nestedInfo.readOnly = true
+				nestedInfo.primaryKey = false
+				if _, ok := si[nestedInfo.dbName]; ok {
+					// direct fields win
+					continue
+				}
+				si[nestedInfo.dbName] = &nestedInfo
+			}
+			continue
+		}
+
 		if info.allowNull() && info.emptyValue == "null" {
 			info.emptyValue = "''"
 		}
@@ -266,12 +493,18 @@ func getStructInfo(t reflect.Type) structInfo {
 		si[info.dbName] = &info
 	}
 
+	if len(skipped) > 0 {
+		unexportedFields.Store(t, skipped)
+	}
+
 	// logrus.Infof("%s %#v", t.Name(), si)
 	return si
 }
 
 // replaceArgs rewrites the string sqlS to embed the slice args given
 // it returns the new placeholder string and the reduced list of arguments.
+// Plain strings are always bound as value or escaped as identifier, only Raw
+// is inserted into the statement as is.
 func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface{}, error) {
 	var (
 		nthArg, lenRunes   int
@@ -319,6 +552,25 @@ func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface
 		arg := args[nthArg]
 		nthArg++
 
+		switch v := arg.(type) {
+		case *Condition:
+			// splice the condition into the statement, so its
+			// placeholders are replaced in order
+			condSql, condArgs := v.sql(db)
+			runes = append(append(append([]rune{}, runes[:i]...), []rune(condSql)...), runes[i+1:]...)
+			lenRunes = len(runes)
+			args = append(append(append([]interface{}{}, args[:nthArg-1]...), condArgs...), args[nthArg:]...)
+			nthArg--
+			i--
+			continue
+		case Raw:
+			sb.WriteString(string(v))
+			continue
+		case Ident:
+			sb.WriteString(db.Esc(string(v)))
+			continue
+		}
+
 		if currRune == db.PlaceholderKey {
 			switch v := arg.(type) {
 			case *string:
@@ -331,6 +583,11 @@ func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface
 			continue
 		}
 
+		arg, err := convertToDB(arg)
+		if err != nil {
+			return "", nil, err
+		}
+
 		isValue := false
 		switch arg.(type) {
 		case json.RawMessage:
@@ -338,7 +595,7 @@ func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface
 		}
 
 		if isValue || driver.IsValue(arg) {
-			newArgs = append(newArgs, arg)
+			newArgs = append(newArgs, db.timeForWrite(arg))
 			db.appendPlaceholder(&sb, len(newArgs)-1)
 			continue
 		}
@@ -353,12 +610,15 @@ func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface
 			}
 			sb.WriteRune('(')
 			fi := &fieldInfo{ptr: rv.Type().Elem().Kind() == reflect.Ptr}
+			if len(newArgs)+l > db.MaxPlaceholder {
+				db.warn(WarningLiteralFallback, sqlS, "%d values inlined as literals, MaxPlaceholder is %d", l, db.MaxPlaceholder)
+			}
 			for i := 0; i < l; i++ {
 				if i > 0 {
 					sb.WriteRune(',')
 				}
 				item := rv.Index(i).Interface()
-				if l > db.MaxPlaceholder {
+				if len(newArgs)+l > db.MaxPlaceholder {
 					// append literals
 					switch v := item.(type) {
 					case string:
@@ -406,7 +666,7 @@ func (db *DB) replaceArgs(sqlS string, args ...interface{}) (string, []interface
 			continue
 		}
 
-		newArgs = append(newArgs, arg)
+		newArgs = append(newArgs, db.timeForWrite(arg))
 		db.appendPlaceholder(&sb, len(newArgs)-1)
 
 	}
@@ -429,12 +689,44 @@ func (db *DB) appendPlaceholder(sb *strings.Builder, numArg int) {
 	case DOLLAR:
 		sb.WriteRune('$')
 		sb.WriteString(strconv.Itoa(numArg + 1))
+	case AT:
+		sb.WriteString("@p")
+		sb.WriteString(strconv.Itoa(numArg + 1))
+	}
+}
+
+// escBool returns the literal for b, MySQL stores bools as TINYINT(1),
+// SQL Server as BIT
+func (db *DB) escBool(b bool) string {
+	numeric := db.Driver == MYSQL || db.Driver == MSSQL
+	switch {
+	case numeric && b:
+		return "1"
+	case numeric:
+		return "0"
+	case b:
+		return "TRUE"
+	default:
+		return "FALSE"
+	}
+}
+
+// formatTime formats t for a literal. MySQL and SQL Server (datetime2) do
+// not accept time zones in literals, the time is written in UTC.
+func (db *DB) formatTime(t time.Time) string {
+	switch db.Driver {
+	case MYSQL:
+		return t.UTC().Format("2006-01-02 15:04:05.999999")
+	case MSSQL:
+		return t.UTC().Format("2006-01-02T15:04:05.9999999")
 	}
+	return t.Format(time.RFC3339Nano)
 }
 
 func (db *DB) EscValueForInsert(value interface{}, fi *fieldInfo) string {
 	var s string
 
+	value = mustConvertToDB(value)
 	v0 := db.nullValue(value, fi)
 	if v0 == nil {
 		return "NULL"
@@ -489,17 +781,9 @@ func (db *DB) EscValueForInsert(value interface{}, fi *fieldInfo) string {
 	case *float64:
 		return strconv.FormatFloat(*v, 'f', -1, 64)
 	case bool:
-		if v == false {
-			return "FALSE"
-		} else {
-			return "TRUE"
-		}
+		return db.escBool(v)
 	case *bool:
-		if *v == false {
-			return "FALSE"
-		} else {
-			return "TRUE"
-		}
+		return db.escBool(*v)
 	case []uint8:
 		s = string(v)
 	case json.RawMessage:
@@ -509,9 +793,9 @@ func (db *DB) EscValueForInsert(value interface{}, fi *fieldInfo) string {
 	case *string:
 		s = *v
 	case time.Time:
-		s = v.Format(time.RFC3339Nano)
+		s = db.formatTime(v)
 	case *time.Time:
-		s = v.Format(time.RFC3339Nano)
+		s = db.formatTime(*v)
 	default:
 		vr, ok := value.(driver.Valuer)
 		if ok {
@@ -544,6 +828,7 @@ func (db *DB) EscValueForInsert(value interface{}, fi *fieldInfo) string {
 
 // nullValue returns the escaped value suitable for UPDATE & INSERT
 func (db *DB) nullValue(value interface{}, fi *fieldInfo) interface{} {
+	value = mustConvertToDB(value)
 
 	if isZero(value) {
 		if fi.allowNull() {
@@ -605,8 +890,13 @@ func (db *DB) Close() error {
 		panic("sqlpro.TX.Close: Unable to close a tx handle")
 	}
 	db.isClosed = true
+	db.snapshotPool.closeAll()
+	db.stmtCache.clear()
+	if db.replicas != nil {
+		db.replicas.close()
+	}
 
-	// log.Printf("%s sqlpro.Close: %s", db, db.DSN)
+	// log.Printf("%s sqlpro.Close: %s", db, db.SafeDSN())
 	return db.sqlDB.Close()
 }
 
@@ -617,57 +907,145 @@ func (db *DB) IsClosed() bool {
 	return db.isClosed
 }
 
-// Open opens a database connection and returns an sqlpro wrap handle
-func Open(driverS, dsn string) (*DB, error) {
+// Open opens a database connection and returns an sqlpro wrap handle. driverS
+// is the name of a dialect, see RegisterDialect. For sqlite3, the DSN
+// parameter "_key" opens a SQLCipher encrypted database, see Rekey. The
+// SQLite URI parameters "mode=ro" and "immutable=1" return a ReadOnly
+// handle, e.g. for databases shipped with the application. opts are
+// applied to the handle, e.g. WithMaxOpenConns.
+func Open(driverS, dsn string, opts ...Option) (*DB, error) {
 
-	var driver dbDriver
-
-	switch driverS {
-	default:
+	dialect := lookupDialect(driverS)
+	if dialect == nil {
 		return nil, fmt.Errorf(`Unknown driver "%s"`, driverS)
-	case "sqlite3":
-		driver = SQLITE3
-	case "postgres":
-		driver = POSTGRES
 	}
 
-	conn, err := sql.Open(string(driver), dsn)
+	var (
+		conn       *sql.DB
+		sqliteConn *sqliteConnector
+		key        string
+		readOnly   bool
+		err        error
+	)
+	if dialect.Driver() == SQLITE3 {
+		var openDSN string
+		openDSN, key, _, err = splitSQLiteKey(dsn)
+		if err != nil {
+			return nil, err
+		}
+		openDSN, readOnly = sqliteReadOnlyDSN(openDSN)
+		conn, sqliteConn, err = openSQLite(dialect.Driver(), openDSN, key)
+	} else {
+		conn, err = sql.Open(dialect.Driver(), dsn)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// conn.SetMaxOpenConns(1)
 
-	err = conn.Ping()
+	wrapper, err := openDB(driverS, conn, sqliteConn, opts...)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
+	wrapper.readOnly = readOnly
+
+	// wrapper.Debug = true
+
+	wrapper.DSN = dsn
+
+	return wrapper, nil
+}
+
+// OpenDB returns an sqlpro wrap handle for the opened conn, which uses the
+// dialect driverS, see RegisterDialect. Use it for connections not opened
+// by a DSN, e.g. a pgx pool wrapped by stdlib.OpenDBFromPool:
+//
+//	db, err := sqlpro.OpenDB("postgres", stdlib.OpenDBFromPool(pool))
+//
+// Closing the handle closes conn.
+func OpenDB(driverS string, conn *sql.DB, opts ...Option) (*DB, error) {
+	return openDB(driverS, conn, nil, opts...)
+}
+
+// openDB is OpenDB with the sqliteConnector of conn, which is set before
+// the options are applied
+func openDB(driverS string, conn *sql.DB, sqliteConn *sqliteConnector, opts ...Option) (*DB, error) {
+	dialect := lookupDialect(driverS)
+	if dialect == nil {
+		return nil, fmt.Errorf(`Unknown driver "%s"`, driverS)
+	}
+
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
 
 	wrapper := New(conn)
 
 	wrapper.sqlDB = conn
-	wrapper.Driver = driver
-
-	// wrapper.Debug = true
+	wrapper.sqliteConn = sqliteConn
+	wrapper.Driver = dbDriver(dialect.Driver())
+	wrapper.dialectImpl = dialect
+	wrapper.pgx = isPgx(conn)
+
+	wrapper.PlaceholderMode = dialect.Placeholder()
+	wrapper.UseReturningForLastId = dialect.Returning()
+	wrapper.SupportsLastInsertId = !dialect.Returning()
+	if driverS == "cockroach" {
+		wrapper.Cockroach = true
+		wrapper.TxRetry.Max = 5
+	}
 
-	wrapper.DSN = dsn
+	wrapper.MaxPlaceholder, err = wrapper.placeholderLimit()
+	if err != nil {
+		return nil, err
+	}
 
-	switch driver {
-	case POSTGRES:
-		wrapper.PlaceholderMode = DOLLAR
-		wrapper.UseReturningForLastId = true
-		wrapper.SupportsLastInsertId = false
-	case SQLITE3:
-	default:
-		return nil, errors.Errorf("sqlpro.Open: Unsupported driver '%s'.", driver)
+	for _, opt := range opts {
+		opt(wrapper)
 	}
 
 	return wrapper, nil
 }
 
+// placeholderLimit returns the maximum number of placeholders per
+// statement supported by the driver and version of the database
+func (db *DB) placeholderLimit() (int, error) {
+	switch db.Driver {
+	case POSTGRES, MYSQL, DUCKDB:
+		return 65535, nil
+	case MSSQL:
+		return 2100, nil
+	case SQLITE3:
+		var version string
+		err := db.Query(&version, "SELECT sqlite_version()")
+		if err != nil {
+			return 0, fmt.Errorf("sqlpro.Open: Unable to read sqlite version: %w", err)
+		}
+		// SQLITE_MAX_VARIABLE_NUMBER was raised in 3.32.0
+		var major, minor int
+		fmt.Sscanf(version, "%d.%d", &major, &minor)
+		if major > 3 || major == 3 && minor >= 32 {
+			return 32766, nil
+		}
+		return 999, nil
+	}
+	return db.MaxPlaceholder, nil
+}
+
 // Open -> handle
 // handle.New -> NewConnection
 // handle.Wrap -> Wrap yourself
 // handle.Tx -> NewTransaction
 // handle.Prepare -> NewPrearedStatement
+
+// mapKeys returns the keys of m in no particular order
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,68 @@
+package sqlpro
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// WarningKind classifies a Warning
+type WarningKind string
+
+const (
+	WarningIdentifier      WarningKind = "identifier"       // invalid identifier, see StrictIdentifiers
+	WarningTimeAudit       WarningKind = "time audit"       // written time changed, see TimeAudit
+	WarningRowsAffected    WarningKind = "rows affected"    // driver did not report the affected rows
+	WarningOmitEmpty       WarningKind = "omitempty"        // empty omitempty columns left out of an UPDATE
+	WarningLiteralFallback WarningKind = "literal fallback" // slice inlined as literals, see MaxPlaceholder
+	WarningSlowQuery       WarningKind = "slow query"       // statement exceeded the SlowQueryThreshold, see Config
+	WarningUnexported      WarningKind = "unexported"       // unexported fields with a "db" tag are skipped
+)
+
+// Warning is a non-fatal condition reported to DB.WarningHook
+type Warning struct {
+	Kind    WarningKind
+	Message string
+	SQL     string // the statement, if any
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("sqlpro %s: %s", w.Kind, w.Message)
+}
+
+// warn reports a warning to the WarningHook. Without hook, identifier,
+// time audit, slow query and unexported warnings are logged, others are
+// dropped.
+func (db *DB) warn(kind WarningKind, sql string, format string, args ...interface{}) {
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...), SQL: sql}
+	if db.WarningHook != nil {
+		db.WarningHook(w)
+		return
+	}
+	switch kind {
+	case WarningIdentifier, WarningTimeAudit, WarningSlowQuery, WarningUnexported:
+		if db.slogger != nil {
+			db.slogger.Warn("sqlpro "+w.Message, "kind", string(w.Kind), "sql", w.SQL)
+			return
+		}
+		db.logf("%s", w)
+	}
+}
+
+// warnUnexported reports the unexported fields with a "db" tag of the
+// struct type t, which may be wrapped in pointers and slices. These fields
+// are skipped when reading and writing.
+func (db *DB) warnUnexported(t reflect.Type) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	getStructInfo(t)
+	names, ok := unexportedFields.Load(t)
+	if !ok {
+		return
+	}
+	db.warn(WarningUnexported, "", "Skipping unexported fields of %s: %s", t, strings.Join(names.([]string), ", "))
+}
@@ -0,0 +1,140 @@
+package sqlpro
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Condition is a WHERE condition built from column comparisons. Passed as
+// arg, it replaces its placeholder with the rendered condition, the values
+// are bound using the PlaceholderMode of the handle:
+//
+//	cond := sqlpro.Where().Eq("a", 1).In("b", ids).Or(sqlpro.Where().IsNull("c"), sqlpro.Where().Gt("d", 5))
+//	db.Query(&rows, "SELECT * FROM test WHERE ?", cond)
+//
+// All terms are joined with AND, an empty Condition is true.
+type Condition struct {
+	terms []conditionTerm
+	args  []interface{}
+}
+
+// conditionTerm is a term of a Condition. The generated terms use "@" and
+// "?" as placeholders, which are mapped to the placeholders of the handle
+// when rendering. Expr terms use the placeholders of the handle.
+type conditionTerm struct {
+	sql  string
+	expr bool
+}
+
+// Where returns an empty Condition
+func Where() *Condition {
+	return &Condition{}
+}
+
+func (c *Condition) add(term string, args ...interface{}) *Condition {
+	c.terms = append(c.terms, conditionTerm{sql: term})
+	c.args = append(c.args, args...)
+	return c
+}
+
+// Eq adds "col = v", or "col IS NULL" if v is <nil>
+func (c *Condition) Eq(col string, v interface{}) *Condition {
+	if v == nil {
+		return c.IsNull(col)
+	}
+	return c.add("@ = ?", col, v)
+}
+
+// Ne adds "col <> v", or "col IS NOT NULL" if v is <nil>
+func (c *Condition) Ne(col string, v interface{}) *Condition {
+	if v == nil {
+		return c.NotNull(col)
+	}
+	return c.add("@ <> ?", col, v)
+}
+
+func (c *Condition) Lt(col string, v interface{}) *Condition {
+	return c.add("@ < ?", col, v)
+}
+
+func (c *Condition) Lte(col string, v interface{}) *Condition {
+	return c.add("@ <= ?", col, v)
+}
+
+func (c *Condition) Gt(col string, v interface{}) *Condition {
+	return c.add("@ > ?", col, v)
+}
+
+func (c *Condition) Gte(col string, v interface{}) *Condition {
+	return c.add("@ >= ?", col, v)
+}
+
+func (c *Condition) Like(col string, pattern string) *Condition {
+	return c.add("@ LIKE ?", col, pattern)
+}
+
+// In adds "col IN (...)" for the slice values. An empty slice matches
+// no rows.
+func (c *Condition) In(col string, values interface{}) *Condition {
+	rv := reflect.ValueOf(values)
+	if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+		return c.add("1 = 0")
+	}
+	return c.add("@ IN ?", col, values)
+}
+
+func (c *Condition) IsNull(col string) *Condition {
+	return c.add("@ IS NULL", col)
+}
+
+func (c *Condition) NotNull(col string) *Condition {
+	return c.add("@ IS NOT NULL", col)
+}
+
+// Expr adds the SQL expression sqlS with its args, placeholders work as
+// for Query
+func (c *Condition) Expr(sqlS string, args ...interface{}) *Condition {
+	c.terms = append(c.terms, conditionTerm{sql: "(" + sqlS + ")", expr: true})
+	c.args = append(c.args, args...)
+	return c
+}
+
+// Or adds the conditions joined with OR. Without conditions nothing is
+// added.
+func (c *Condition) Or(conds ...*Condition) *Condition {
+	if len(conds) == 0 {
+		return c
+	}
+	terms := make([]string, 0, len(conds))
+	args := []interface{}{}
+	for _, cond := range conds {
+		terms = append(terms, "?")
+		args = append(args, cond)
+	}
+	return c.add("("+strings.Join(terms, " OR ")+")", args...)
+}
+
+// Not adds the negated condition
+func (c *Condition) Not(cond *Condition) *Condition {
+	return c.add("NOT (?)", cond)
+}
+
+// sql returns the condition with the placeholders of db and its args
+func (c *Condition) sql(db *DB) (string, []interface{}) {
+	if len(c.terms) == 0 {
+		return "1 = 1", nil
+	}
+	repl := strings.NewReplacer("@", string(db.PlaceholderKey), "?", string(db.PlaceholderValue))
+	terms := make([]string, 0, len(c.terms))
+	for _, term := range c.terms {
+		if term.expr {
+			terms = append(terms, term.sql)
+		} else {
+			terms = append(terms, repl.Replace(term.sql))
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], c.args
+	}
+	return "(" + strings.Join(terms, " AND ") + ")", c.args
+}
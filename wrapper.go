@@ -4,17 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/olekukonko/tablewriter"
 	"github.com/pkg/errors"
 	"github.com/programmfabrik/golib"
-	"github.com/yudai/pp"
 )
 
 type dbDriver string
@@ -22,33 +23,115 @@ type dbDriver string
 // The driver strings must match the driver from the stdlib
 const POSTGRES = "postgres"
 const SQLITE3 = "sqlite3"
+const MYSQL = "mysql" // MySQL and MariaDB
+const MSSQL = "sqlserver"
+const DUCKDB = "duckdb"
 
 type DB struct {
 	db                    dbWrappable
 	sqlDB                 *sql.DB // this can be <nil>
 	sqlTx                 *sql.Tx // this can be <nil>
-	Debug                 bool
-	DebugExec             bool
-	DebugQuery            bool
+	Debug                 bool    // shorthand for all levels but PANIC and QUERY, see SetDebug
+	DebugExec             bool    // shorthand for EXEC, INSERT and UPDATE
+	DebugQuery            bool    // shorthand for QUERY_DUMP
+	debugLevel            DebugLevel
+	config                *atomic.Pointer[Config] // shared by all copies, see ApplyConfig
 	PlaceholderMode       PlaceholderMode
 	PlaceholderEscape     rune
 	PlaceholderValue      rune
 	PlaceholderKey        rune
-	MaxPlaceholder        int
+	MaxPlaceholder        int // slices with more values are inlined as literals, set from the driver limit by Open
 	UseReturningForLastId bool
 	SupportsLastInsertId  bool
 	Driver                dbDriver
-	DSN                   string
+	dialectImpl           Dialect          // set by Open, see dialect
+	sqliteConn            *sqliteConnector // set by Open for sqlite3, see Rekey and SQLitePragmas
+	pgx                   bool             // set by OpenDB for the pgx driver, see OpenPgx
+	appender              AppenderFunc     // set by WithAppender
+	maxIdleConns          int              // restored by closeIdleConns, see WithMaxIdleConns
+	DSN                   string           // may contain passwords, use SafeDSN for logging
 	isClosed              bool
 
 	txWriteMode bool
+	readOnly    bool // set by ReadOnly
 
 	LastError error // This is set to the last error
 
-	txAfterCommit   []func()
+	txAfterCommit   []func(ctx context.Context) error
 	txAfterRollback []func()
 
 	txBeginMtx *sync.Mutex // used to protect write tx begin for SQLITE3
+
+	commitToken         CommitToken // set by Commit
+	CaptureCommitTokens bool        // if set, Commit captures the Token on Postgres, see Token
+
+	// SnapshotMaxAge is the maximum age of a pooled transaction
+	// handed out by SnapshotRead
+	SnapshotMaxAge time.Duration
+	snapshotPool   *snapshotPool
+
+	TxRetry   TxRetry // retry policy for ExecTX
+	Cockroach bool    // set by Open for "cockroach", the Postgres dialect with the CockroachDB retry protocol in ExecTX
+
+	TxWatchdog TxWatchdog // watchdog for long running write transactions
+	txStart    time.Time  // set by Begin
+	txID       uint64     // set by Begin, identifies the transaction in log events
+	txWatchdog *time.Timer
+	txStats    *txStats // set by Begin
+
+	LogTxStats bool // if set, the Stats of transactions are logged at Commit
+
+	// TxOrphanHook is called before an orphaned transaction is rolled
+	// back, see RollbackOrphans
+	TxOrphanHook func(tx *DB, cause error)
+	openTxs      *openTxs
+	txStopOrphan func() bool
+
+	ExplainGuard *ExplainGuard // if set, statements are checked using EXPLAIN before running them
+
+	StmtCacheSize int // number of prepared statements cached, 0 disables the cache
+	stmtCache     *stmtCache
+
+	StrictScan           bool // if set, scanning into structs fails for columns not mapped to a field
+	ScanRequireAllFields bool // if set, scanning into structs fails for fields receiving no column
+
+	StrictIdentifiers bool // if set, table and column names used by Insert, Update and Esc are checked using CheckIdentifier
+
+	throttle        *throttle     // set by MaxConcurrentQueries
+	flights         *flightGroup  // set by Singleflight
+	ThrottleTimeout time.Duration // maximum wait for a statement slot, 0 waits until the ctx is done
+
+	ReadRetries int // retries of SELECT queries outside transactions failing with a connection error, defaults to 1
+
+	replicas    *replicaSet // set by OpenCluster
+	primaryOnly bool        // set by Primary
+	schema      string      // set by WithSchema
+
+	rowAllocator RowAllocator // set by WithRowAllocator
+
+	fallback     *DB                           // set by WithFallback
+	FallbackHook func(fallback *DB, err error) // called when a query is retried on the fallback handle
+
+	InspectMaxRows      int           // maximum number of rows returned by InspectQuery and printed, 0 for no limit
+	InspectQueryTimeout time.Duration // timeout for InspectQuery and PrintQuery, if the ctx has no deadline
+	PrintOptions        RenderOptions // used by PrintQuery
+
+	TimeUTC     bool     // if set, time values are converted to UTC before writing
+	TimeLayouts []string // layouts accepted besides RFC3339 when scanning strings into time values, e.g. "2006-01-02 15:04:05"
+	TimeAudit   bool     // if set, written time values are read back and a warning is logged if they changed
+
+	WarningHook func(w Warning) // receives non-fatal conditions, see Warning
+
+	Logger       *log.Logger   // used for debug output and warnings, <nil> uses the standard logger
+	slogger      *slog.Logger  // set by SetLogger, takes precedence over Logger
+	QueryTimeout time.Duration // timeout for statements if the ctx has no deadline, not applied to queries into **sql.Rows
+
+	Clock     Clock                                   // time source, <nil> uses the system time
+	Rand      io.Reader                               // source of randomness for generated ids, <nil> uses crypto/rand
+	QueryHook func(ctx context.Context, qi QueryInfo) // called after each statement sent to the database
+
+	middlewares []Middleware // set by Use
+	rewriters   []Rewriter   // set by AddRewriter
 }
 
 // DB returns the wrapped sql.DB handle
@@ -64,23 +147,63 @@ func (db *DB) String() string {
 	return fmt.Sprintf("[%s, %p]", db.Driver, db)
 }
 
+// DebugLevel selects the operations logged, see SetDebug. The levels can
+// be combined, e.g. EXEC|QUERY.
 type DebugLevel int
 
 const (
-	PANIC      DebugLevel = 1
-	ERROR                 = 2
-	UPDATE                = 4
-	INSERT                = 8
-	EXEC                  = 16
-	QUERY                 = 32
-	QUERY_DUMP            = 64
+	PANIC      DebugLevel = 1  // panic on errors
+	ERROR                 = 2  // log errors
+	UPDATE                = 4  // log UPDATE statements
+	INSERT                = 8  // log INSERT statements
+	EXEC                  = 16 // log other statements and BEGIN, COMMIT and ROLLBACK
+	QUERY                 = 32 // log queries
+	QUERY_DUMP            = 64 // log queries and print their result, this runs SELECTs twice
 )
 
+// SetDebug sets the operations logged. The flags Debug, DebugExec and
+// DebugQuery add their levels to level.
+func (db *DB) SetDebug(level DebugLevel) {
+	db.debugLevel = level
+}
+
+// debug returns true if operations of the given level are logged
+func (db *DB) debug(level DebugLevel) bool {
+	l := db.debugLevel
+	if cfg := db.loadConfig(); cfg != nil {
+		l = cfg.Debug
+	}
+	if db.Debug {
+		l |= ERROR | UPDATE | INSERT | EXEC | QUERY_DUMP
+	}
+	if db.DebugExec {
+		l |= EXEC | INSERT | UPDATE
+	}
+	if db.DebugQuery {
+		l |= QUERY_DUMP
+	}
+	return l&level != 0
+}
+
+// execDebugLevel returns the DebugLevel of the statement execSql
+func execDebugLevel(execSql string) DebugLevel {
+	stmt := strings.ToUpper(strings.TrimSpace(execSql))
+	switch {
+	case strings.HasPrefix(stmt, "INSERT"):
+		return INSERT
+	case strings.HasPrefix(stmt, "UPDATE"):
+		return UPDATE
+	default:
+		return EXEC
+	}
+}
+
 type PlaceholderMode int
 
 const (
 	DOLLAR   PlaceholderMode = 1
 	QUESTION                 = 2
+	AT                       = 3 // @p1, @p2, ...
 )
 
 type dbWrappable interface {
@@ -99,6 +222,9 @@ func New(dbWrap dbWrappable) *DB {
 	db = new(DB)
 
 	db.txBeginMtx = &sync.Mutex{}
+	db.snapshotPool = &snapshotPool{}
+	db.openTxs = &openTxs{txs: map[*DB]*sql.Tx{}}
+	db.stmtCache = newStmtCache()
 	db.db = dbWrap
 
 	// DEFAULTs for sqlite
@@ -110,15 +236,26 @@ func New(dbWrap dbWrappable) *DB {
 	db.MaxPlaceholder = 100
 	db.SupportsLastInsertId = true
 	db.UseReturningForLastId = false
+	db.SnapshotMaxAge = 100 * time.Millisecond
+	db.InspectMaxRows = 100
+	db.InspectQueryTimeout = 10 * time.Second
+	db.ReadRetries = 1
+	db.maxIdleConns = 2 // default of database/sql
+	db.config = &atomic.Pointer[Config]{}
 
 	return db
 }
 
 func (db *DB) Esc(s string) string {
-	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	db.warnIdentifier(s)
+	return db.dialect().EscIdent(s)
 }
 
 func (db *DB) EscValue(s string) string {
+	if db.Driver == MYSQL {
+		// MySQL treats backslashes in strings as escape characters
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
 	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
 }
 
@@ -131,6 +268,14 @@ func (db *DB) Version() (version string, err error) {
 	case SQLITE3:
 		selVersion = "SELECT sqlite_version()"
 		prefix = "Sqlite "
+	case MYSQL:
+		selVersion = "SELECT version()"
+		prefix = "MySQL "
+	case MSSQL:
+		selVersion = "SELECT @@VERSION"
+	case DUCKDB:
+		selVersion = "SELECT version()"
+		prefix = "DuckDB "
 	}
 	if selVersion != "" {
 		err = db.Query(&version, selVersion)
@@ -150,6 +295,60 @@ func (db *DB) Log() *DB {
 	return &newDB
 }
 
+// Strict returns a copy with StrictScan enabled
+func (db *DB) Strict() *DB {
+	newDB := *db
+	newDB.StrictScan = true
+	return &newDB
+}
+
+// ReadOnly returns a copy which rejects Insert, Update, Save and Exec with
+// ErrReadOnly. Transactions started on the copy are read-only, too.
+func (db *DB) ReadOnly() *DB {
+	newDB := *db
+	newDB.readOnly = true
+	return &newDB
+}
+
+// WithMaxPlaceholder returns a copy with MaxPlaceholder set to n
+func (db *DB) WithMaxPlaceholder(n int) *DB {
+	newDB := *db
+	newDB.MaxPlaceholder = n
+	return &newDB
+}
+
+// RequireAllFields returns a copy with ScanRequireAllFields enabled
+func (db *DB) RequireAllFields() *DB {
+	newDB := *db
+	newDB.ScanRequireAllFields = true
+	return &newDB
+}
+
+// checkScanColumns returns an error wrapping ErrUnmappedColumns if StrictScan
+// is set or target is a ReadOnlyModel and rows has columns which are not
+// mapped in target, and an error wrapping ErrMissingColumns if
+// ScanRequireAllFields is set and fields of target receive no column
+func (db *DB) checkScanColumns(target reflect.Type, rows *sql.Rows) error {
+	if target == nil {
+		return nil
+	}
+	strict := db.StrictScan || isReadOnlyModel(target)
+	if !strict && !db.ScanRequireAllFields {
+		return nil
+	}
+	unmapped, missing, err := scanColumnsMismatch(target, rows)
+	if err != nil {
+		return err
+	}
+	if strict && len(unmapped) > 0 {
+		return fmt.Errorf("%w %s: %s", ErrUnmappedColumns, target, strings.Join(unmapped, ", "))
+	}
+	if db.ScanRequireAllFields && len(missing) > 0 {
+		return fmt.Errorf("%w %s: %s", ErrMissingColumns, target, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 func (db *DB) Query(target interface{}, query string, args ...interface{}) error {
 	return db.QueryContext(context.Background(), target, query, args...)
 }
@@ -158,37 +357,49 @@ func (db *DB) Query(target interface{}, query string, args ...interface{}) error
 // It is a wrapper method around the
 func (db *DB) QueryContext(ctx context.Context, target interface{}, query string, args ...interface{}) error {
 	var (
-		rows    *sql.Rows
-		err     error
-		query0  string
-		newArgs []interface{}
+		rows *sql.Rows
+		err  error
 	)
 
-	query0, newArgs, err = db.replaceArgs(query, args...)
-	if err != nil {
-		return err
+	if _, isRows := target.(**sql.Rows); db.flights != nil && !isRows && db.sqlTx == nil && isSelect(query) {
+		return db.queryShared(ctx, target, query, args...)
+	}
+
+	if _, isRows := target.(**sql.Rows); !isRows {
+		// the caller reads **sql.Rows after we return
+		var cancel context.CancelFunc
+		ctx, cancel = db.queryTimeout(ctx)
+		defer cancel()
 	}
 
-	// log.Printf("RowMode: %s %v", targetValue.Type().Kind(), rowMode)
-	rows, err = db.db.QueryContext(ctx, query0, newArgs...)
+	rows, err = db.queryRows(ctx, query, args...)
 	if err != nil {
-		return db.debugError(db.sqlError(err, query0, newArgs))
+		return err
 	}
 
 	switch target.(type) {
 	case **sql.Rows:
+		// the caller closes the rows, don't hold the slot
+		releaseRows(rows)
 		reflect.ValueOf(target).Elem().Set(reflect.ValueOf(rows))
 		return nil
 	}
 
-	defer rows.Close()
+	defer closeRows(rows)
+
+	err = db.checkScanColumns(reflect.TypeOf(target), rows)
+	if err != nil {
+		return db.debugError(err)
+	}
+	db.warnUnexported(reflect.TypeOf(target))
 
-	err = Scan(target, rows)
+	err = scan(target, rows, db.TimeLayouts, db.rowAllocator)
 	if err != nil {
 		return db.debugError(err)
 	}
 
-	if (db.Debug || db.DebugQuery) && !strings.HasPrefix(query, "INSERT INTO") {
+	if db.debug(QUERY_DUMP) && isSelect(query) {
+		// only SELECTs can run a second time
 		// log.Printf("Query: %s Args: %v", query, args)
 		err = db.PrintQueryContext(ctx, query, args...)
 		if err != nil {
@@ -199,6 +410,161 @@ func (db *DB) QueryContext(ctx context.Context, target interface{}, query string
 	return nil
 }
 
+// queryRows replaces the args in query and runs it
+func (db *DB) queryRows(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.replicas != nil {
+		rows, err := db.queryReplica(ctx, query, args...)
+		if rows != nil || err != nil {
+			return rows, err
+		}
+	}
+
+	if db.debug(QUERY) && !db.debug(QUERY_DUMP) {
+		db.logf("%s SQL: %s\nARGS:\n%s", db, golib.CutStr(query, 2000, "..."), argsToString(args...))
+	}
+
+	query0, newArgs, err := db.replaceArgs(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	query0 = db.rewrite(query0)
+
+	err = db.checkExplainGuard(ctx, query0, newArgs)
+	if err != nil {
+		return nil, db.debugError(err)
+	}
+
+	rows, err := db.queryContext(ctx, query0, newArgs...)
+	for retry := 0; err != nil && retry < db.ReadRetries && db.isRetryableRead(ctx, query0, err); retry++ {
+		rows, err = db.queryContext(ctx, query0, newArgs...)
+	}
+	if err != nil {
+		fbRows, fbErr := db.queryFallback(ctx, err, query, args...)
+		if fbRows != nil || fbErr != nil {
+			return fbRows, fbErr
+		}
+		return nil, db.debugError(db.sqlError(err, query0, newArgs))
+	}
+	return rows, nil
+}
+
+func (db *DB) QueryEach(target interface{}, fn func() error, query string, args ...interface{}) error {
+	return db.QueryEachContext(context.Background(), target, fn, query, args...)
+}
+
+// QueryEachContext runs the query and scans the rows one by one into target,
+// calling fn after each row. target needs to be a pointer to a struct or a
+// scalar, it is reset and reused for each row. Use this to process large
+// result sets without holding them in memory. If fn returns an error, the
+// iteration stops and the error is returned.
+func (db *DB) QueryEachContext(ctx context.Context, target interface{}, fn func() error, query string, args ...interface{}) error {
+	v := reflect.ValueOf(target)
+	if target == nil || v.Type().Kind() != reflect.Ptr {
+		panic(fmt.Errorf("QueryEach: non-pointer %T", target))
+	}
+	targetValue := v.Elem()
+
+	ctx, cancel := db.queryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRows(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	// fn may run statements, don't hold the slot
+	releaseRows(rows)
+	defer closeRows(rows)
+
+	err = db.checkScanColumns(targetValue.Type(), rows)
+	if err != nil {
+		return db.debugError(err)
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return db.debugError(err)
+	}
+	scanner := db.newScanner(targetValue.Type(), cols)
+
+	for rows.Next() {
+		targetValue.Set(reflect.Zero(targetValue.Type()))
+		err = scanner.scanRow(targetValue, rows)
+		if err != nil {
+			return db.debugError(err)
+		}
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return db.debugError(err)
+	}
+	return nil
+}
+
+// QueryFirst runs the query and scans the first row into target like Query.
+// If the query returns no rows, target is left untouched and found is
+// false instead of returning ErrQueryReturnedZeroRows as QueryOne does.
+// Slice targets receive all rows like in Query, found is true if rows were
+// appended.
+func (db *DB) QueryFirst(ctx context.Context, target interface{}, query string, args ...interface{}) (found bool, err error) {
+	before := -1
+	if tv := reflect.ValueOf(target); tv.Kind() == reflect.Ptr && tv.Elem().Kind() == reflect.Slice {
+		before = tv.Elem().Len()
+	}
+
+	err = db.QueryContext(ctx, target, query, args...)
+	if err == ErrQueryReturnedZeroRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if before >= 0 {
+		return reflect.ValueOf(target).Elem().Len() > before, nil
+	}
+	if tbl, ok := target.(*Table); ok {
+		return len(tbl.Rows) > 0, nil
+	}
+	return true, nil
+}
+
+func (db *DB) QueryRow(query string, args []interface{}, dest ...interface{}) error {
+	return db.QueryRowContext(context.Background(), query, args, dest...)
+}
+
+// QueryRowContext runs the query and scans the columns of the first row into
+// dest, like sql.Row.Scan. Placeholders in query are replaced like for
+// Query. If the query returns no rows, ErrQueryReturnedZeroRows is returned.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	ctx, cancel := db.queryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRows(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer closeRows(rows)
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			return db.debugError(err)
+		}
+		return ErrQueryReturnedZeroRows
+	}
+
+	err = rows.Scan(dest...)
+	if err != nil {
+		return db.debugError(err)
+	}
+	return nil
+}
+
 func (db *DB) Exec(execSql string, args ...interface{}) error {
 	return db.ExecContext(context.Background(), execSql, args...)
 }
@@ -220,41 +586,38 @@ func (db *DB) ExecContextRowsAffected(ctx context.Context, execSql string, args
 	return db.execContext(ctx, execSql, args...)
 }
 
-func (db *DB) PrintQueryContext(ctx context.Context, query string, args ...interface{}) error {
-	var (
-		rows    *sql.Rows
-		err     error
-		query0  string
-		newArgs []interface{}
-	)
-
-	data := make([][]string, 0)
-
-	query0, newArgs, err = db.replaceArgs(query, args...)
-
-	start := time.Now()
-	rows, err = db.db.QueryContext(ctx, query0, newArgs...)
-	if err != nil {
-		pp.Println(query0)
-		pp.Println(newArgs)
-		return db.sqlError(err, query0, newArgs)
+// ExecResult runs execSql like ExecContext and returns the sql.Result of
+// the driver
+func (db *DB) ExecResult(ctx context.Context, execSql string, args ...interface{}) (sql.Result, error) {
+	if execSql == "" {
+		return nil, db.debugError(errors.New("Exec: Empty query"))
 	}
-	cols, _ := rows.Columns()
-	defer rows.Close()
+	result, _, err := db.execResult(ctx, execSql, args...)
+	return result, err
+}
+
+func (db *DB) PrintQuery(query string, args ...interface{}) error {
+	return db.PrintQueryContext(context.Background(), query, args...)
+}
 
-	err = Scan(&data, rows)
+// PrintQueryContext runs the query and prints the SQL and the
+// result table to stdout. See InspectQueryContext.
+func (db *DB) PrintQueryContext(ctx context.Context, query string, args ...interface{}) error {
+	qr, err := db.InspectQueryContext(ctx, query, args...)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
-
-	fmt.Fprint(os.Stdout, db.sqlDebug(query0, newArgs))
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader(cols)
-	table.AppendBulk(data)
-	table.SetCaption(true, "Took: "+time.Since(start).String())
-	table.Render()
-
+	if db.slogger != nil {
+		var sb strings.Builder
+		qr.RenderWith(&sb, db.PrintOptions)
+		db.logEvent(ctx, "sqlpro print",
+			slog.String("sql", qr.SQL),
+			slog.Int("args", len(qr.Args)),
+			slog.String("result", sb.String()))
+		return nil
+	}
+	fmt.Fprint(os.Stdout, db.sqlDebug(qr.SQL, qr.Args))
+	qr.RenderWith(os.Stdout, db.PrintOptions)
 	return nil
 }
 
@@ -263,8 +626,11 @@ func (db *DB) debugError(err error) error {
 		return err
 	}
 	db.LastError = err
-	if db.Debug {
-		log.Printf("sqlpro error: %s", err)
+	if db.debug(PANIC) {
+		panic(err)
+	}
+	if db.debug(ERROR) {
+		db.logf("sqlpro error: %s", err)
 	}
 	return err
 }